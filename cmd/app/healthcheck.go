@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"exam/internal/config"
+)
+
+const defaultHealthcheckTimeout = 3 * time.Second
+
+// runHealthcheck implements "exam healthcheck": it probes the running server
+// through the address the configuration says it listens on and returns the
+// exit code, 0 for healthy. It exists for images without curl or wget.
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("exam healthcheck", flag.ContinueOnError)
+	ready := fs.Bool("ready", false, "check readiness, including the database, instead of liveness")
+	timeout := fs.Duration("timeout", defaultHealthcheckTimeout, "give up after this long")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := config.LoadDotEnv(""); err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		return 1
+	}
+	cfg, err := config.LoadConfig(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: invalid configuration: %v\n", err)
+		return 1
+	}
+
+	path := "/_internal/livez"
+	if *ready {
+		path = "/_internal/readyz?verbose=false"
+	}
+	client, url := healthcheckClient(cfg, *timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+path, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		return 1
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "healthcheck: %s\n", resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// healthcheckClient returns a client and base URL reaching the local server:
+// over the Unix socket when there is one, else over TCP to the main port.
+func healthcheckClient(cfg config.Config, timeout time.Duration) (*http.Client, string) {
+	transport := &http.Transport{DisableKeepAlives: true}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	if cfg.SocketPath != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", cfg.SocketPath)
+		}
+		return client, "http://unix"
+	}
+
+	host := cfg.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	scheme := "http"
+	if cfg.TLS.Enabled() {
+		// The certificate names the public host, not localhost.
+		scheme = "https"
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return client, scheme + "://" + net.JoinHostPort(host, cfg.Port)
+}
@@ -0,0 +1,56 @@
+// Command exam serves the users app. Every setting comes from a flag, an
+// env var or a config file; run it with -h for the list.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/store"
+	"exam/internal/web"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(runHealthcheck(os.Args[2:]))
+	}
+	flags, err := config.ParseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if err := config.LoadDotEnv(flags.Values[config.EnvFileEnvKey]); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	cfg, err := config.LoadConfig(flags.Values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  %s\n", line)
+		}
+		os.Exit(1)
+	}
+	if flags.PrintConfig {
+		cfg.PrintConfig(os.Stdout)
+		return
+	}
+	logger, logLevel := web.NewLogger(os.Stderr, cfg)
+	cfg.LogSources(logger)
+
+	logger.Info("starting", "version", web.Build.Version, "commit", web.Build.Commit, "build_date", web.Build.BuildDate, "go_version", web.Build.GoVersion)
+
+	users, err := store.Open(logger, cfg, clock.System)
+	if err != nil {
+		logger.Error("failed to open store", "store", cfg.Store, "error", err)
+		os.Exit(1)
+	}
+	os.Exit(web.Run(cfg, users, logger, logLevel))
+}
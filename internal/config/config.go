@@ -0,0 +1,753 @@
+// Package config loads the app's settings from flags, env vars, an optional
+// config file and .env, validates them and applies the defaults.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultAppPort = "8080"
+	// secretFileSuffix marks the variant of a secret that names a file.
+	secretFileSuffix = "_FILE"
+)
+
+// Config is everything the app reads from the environment, parsed, validated
+// and with defaults applied. LoadConfig builds it from env vars; tests can
+// construct one directly.
+type Config struct {
+	LogLevel  slog.Level
+	LogFormat string
+
+	// Host and Port are the main TCP listener; Port is "" when only the
+	// Unix socket is served.
+	Host       string
+	Port       string
+	SocketPath string
+	SocketMode fs.FileMode
+	EnableH2C  bool
+
+	TLS TLSConfig
+
+	DebugPort   string
+	EnablePprof bool
+
+	ShutdownTimeout time.Duration
+	Timeouts        ServerTimeouts
+	RequestTimeout  time.Duration
+	BodyLimits      BodyLimits
+
+	MaxConcurrent    int
+	ConcurrencyQueue time.Duration
+	// RateLimit is how many users a client may create in any
+	// RateLimitWindow; a zero window turns the limit off.
+	RateLimit       int
+	RateLimitWindow time.Duration
+	// FormMinFillTime is how long the homepage form must have been shown
+	// before it is submitted; zero turns the check off. FormSecret signs
+	// the time it was shown and must be the same on every instance; when
+	// unset the key is random per process.
+	FormMinFillTime time.Duration
+	FormSecret      string
+
+	Store            string
+	SQLitePath       string
+	DB               DBConfig
+	DBReadRetries    int
+	DBCopyThreshold  int
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	HealthCacheTTL   time.Duration
+	// HealthPingTimeout bounds the readiness check's database ping.
+	HealthPingTimeout time.Duration
+	// CleanupInterval is how often the janitor sweeps expired rows, zero
+	// to never; it removes up to CleanupBatchSize per statement and keeps
+	// retained rows for Retention.
+	CleanupInterval  time.Duration
+	CleanupBatchSize int
+	Retention        time.Duration
+	// JobWorkers run background jobs, each checking for due ones every
+	// JobPollInterval while idle; a job failing JobMaxAttempts times is
+	// given up on.
+	JobWorkers      int
+	JobPollInterval time.Duration
+	JobMaxAttempts  int
+	// UsersCacheTTL is how long GET /api/users answers from memory, zero
+	// to always query.
+	UsersCacheTTL time.Duration
+	// RedisURL, when set, shares the cache between instances through Redis.
+	RedisURL string
+
+	InternalToken  string
+	DebugToken     string
+	TrustedProxies []netip.Prefix
+	AccessLogSkip  map[string]struct{}
+	Maintenance    bool
+	// TestEndpoints registers POST /_internal/reset, for end-to-end test
+	// environments; without it the route doesn't exist.
+	TestEndpoints bool
+	Branding      Branding
+	// FeatureFlags overrides the web package's feature flag defaults by
+	// name; names it doesn't know are kept so it can warn about them.
+	FeatureFlags   map[string]bool
+	OtelEndpoint   string
+	SlowQuery      time.Duration
+	LatencyBudgets LatencyBudgets
+
+	// sources records where each setting came from, and unknownFileKeys
+	// the config file entries that matched no setting; see LogSources.
+	sources         []configSource
+	configFile      string
+	unknownFileKeys []string
+}
+
+// configSource is one setting and where its value came from.
+type configSource struct {
+	Key, Value, Source string
+}
+
+// TLSConfig selects how the main listener serves HTTPS. At most one of
+// certificate files, ACME and DevTLS is set.
+type TLSConfig struct {
+	CertFile, KeyFile string
+	ACMEDomains       []string
+	ACMECacheDir      string
+	DevTLS            bool
+	RedirectPort      string
+	CanonicalHost     string
+}
+
+// Enabled reports whether the main listener serves HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || len(c.ACMEDomains) > 0 || c.DevTLS
+}
+
+// ServerTimeouts are the http.Server limits. WriteTimeout doubles as the
+// deadline on each request's context, since nothing can be written after it.
+type ServerTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// Branding is how the pages present the app, so each deployment can
+// look like its own.
+type Branding struct {
+	Title string
+	// AccentColor is one of accentColors, interpolated into Tailwind
+	// class names.
+	AccentColor string
+	LogoURL     string
+}
+
+// BodyLimits caps request body sizes. Multipart bodies (CSV imports, avatar
+// uploads) get their own, larger limit.
+type BodyLimits struct {
+	Body   int64
+	Upload int64
+}
+
+// LatencyBudgets is the default budget and its per-route overrides, matched
+// by path prefix with the first match winning, like the web package's route
+// timeouts. Zero disables the warning for a route.
+type LatencyBudgets struct {
+	Default time.Duration
+	Routes  []routeBudget
+}
+
+type routeBudget struct {
+	prefix string
+	budget time.Duration
+}
+
+// parseLatencyBudgets reads ROUTE_LATENCY_BUDGETS, a comma-separated list of
+// prefix=duration pairs such as "/users/bulk=5s,/api/=200ms".
+func parseLatencyBudgets(def time.Duration, list string) (LatencyBudgets, error) {
+	b := LatencyBudgets{Default: def}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, raw, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return b, fmt.Errorf("%q is not a /prefix=duration pair", entry)
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return b, fmt.Errorf("%q: invalid duration %q", prefix, raw)
+		}
+		b.Routes = append(b.Routes, routeBudget{prefix: prefix, budget: d})
+	}
+	return b, nil
+}
+
+func (b LatencyBudgets) ForPath(path string) time.Duration {
+	for _, rb := range b.Routes {
+		if strings.HasPrefix(path, rb.prefix) {
+			return rb.budget
+		}
+	}
+	return b.Default
+}
+
+// DBConfig locates the database. With URL set, the other fields are
+// overrides on top of it and empty ones leave the URL's value alone.
+type DBConfig struct {
+	URL      string
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Name     string
+
+	// ReplicaURL, when set, is a read replica that read-only queries go to.
+	ReplicaURL string
+
+	// SSLMode is one of pgSSLModes; empty keeps the URL's (or, without a
+	// URL, disable). The files are PEM paths that pgx reads at parse time.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	// Pool sizing and lifetimes; zero keeps the URL's pool_* parameter or
+	// the pgxpool default.
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// ConnectTimeout bounds the whole startup connection loop,
+	// AttemptTimeout each try within it.
+	ConnectTimeout time.Duration
+	AttemptTimeout time.Duration
+	// SchemaTimeout bounds the schema setup after connecting.
+	SchemaTimeout time.Duration
+	// StatementTimeout is the session statement_timeout; 0 leaves the
+	// server's.
+	StatementTimeout time.Duration
+
+	// LazyConnect lets the server start before the database is reachable.
+	LazyConnect bool
+	// MigrateOnStart applies pending migrations after connecting.
+	MigrateOnStart bool
+}
+
+var pgSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// PoolConfig builds the pool configuration. Without a URL it composes one
+// from the fields with sslmode=disable, as before DATABASE_URL existed; with
+// one, the URL's query parameters (sslmode, pool_max_conns, ...) are kept.
+// Overrides go in as connection string parameters rather than onto the
+// parsed config, so pgx derives TLS settings such as the verify-full server
+// name from the final host.
+func (c DBConfig) PoolConfig() (*pgxpool.Config, error) {
+	dsn := c.URL
+	var params [][2]string
+	if dsn == "" {
+		// url.URL escapes credentials and names, which may contain @, / or %.
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(c.User, c.Password),
+			Host:     net.JoinHostPort(strings.Trim(c.Host, "[]"), c.Port),
+			Path:     "/" + c.Name,
+			RawQuery: "sslmode=disable",
+		}
+		dsn = u.String()
+	} else {
+		params = [][2]string{{"user", c.User}, {"password", c.Password}, {"host", c.Host}, {"port", c.Port}, {"dbname", c.Name}}
+	}
+	params = append(params, [][2]string{{"sslmode", c.SSLMode}, {"sslrootcert", c.SSLRootCert}, {"sslcert", c.SSLCert}, {"sslkey", c.SSLKey}}...)
+	dsn, err := withDSNParams(dsn, params)
+	if err != nil {
+		return nil, err
+	}
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxConns > 0 {
+		poolCfg.MaxConns = c.MaxConns
+	}
+	if c.MinConns > 0 {
+		poolCfg.MinConns = c.MinConns
+	}
+	if c.MaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = c.MaxConnLifetime
+	}
+	if c.MaxConnIdleTime > 0 {
+		poolCfg.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	if c.HealthCheckPeriod > 0 {
+		poolCfg.HealthCheckPeriod = c.HealthCheckPeriod
+	}
+	if c.StatementTimeout > 0 {
+		poolCfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	return poolCfg, nil
+}
+
+// Replica returns the configuration of the read replica: its URL, with the
+// credentials, TLS and pool settings of the primary but not its address.
+func (c DBConfig) Replica() DBConfig {
+	r := c
+	r.URL, r.ReplicaURL = c.ReplicaURL, ""
+	r.Host, r.Port = "", ""
+	return r
+}
+
+// withDSNParams sets the non-empty params on a postgres:// URL or a
+// keyword/value connection string, replacing any it already has.
+func withDSNParams(dsn string, params [][2]string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		for _, p := range params {
+			if p[1] != "" {
+				q.Set(p[0], p[1])
+			}
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	// In keyword/value form the last occurrence of a keyword wins.
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for _, p := range params {
+		if p[1] != "" {
+			dsn += fmt.Sprintf(" %s='%s'", p[0], quote.Replace(p[1]))
+		}
+	}
+	return dsn, nil
+}
+
+// Addr joins c.Host with port into a listen address.
+func (c Config) Addr(port string) string {
+	return net.JoinHostPort(strings.Trim(c.Host, "[]"), port)
+}
+
+// LoadConfig reads the configuration from flags (keyed by env var name),
+// the environment and, when CONFIG_FILE is set, that file, in that order of
+// precedence. It reports every problem it finds, not just the first, each
+// prefixed with its env var.
+func LoadConfig(flags map[string]string) (Config, error) {
+	return load(envLoader{flags: flags, asked: map[string]bool{}})
+}
+
+// Default is the configuration with every setting at its default, whatever
+// the environment says, for building an App in tests and tools.
+func Default() Config {
+	c, _ := load(envLoader{asked: map[string]bool{}, defaultsOnly: true})
+	return c
+}
+
+func load(l envLoader) (Config, error) {
+	configFile := l.flags[ConfigFileEnvKey]
+	if configFile == "" && !l.defaultsOnly {
+		configFile = os.Getenv(ConfigFileEnvKey)
+	}
+	if configFile != "" {
+		file, err := loadConfigFile(configFile)
+		l.check(ConfigFileEnvKey, err)
+		l.file = file
+	}
+
+	c := Config{
+		LogFormat:  l.str(LogFormatEnvKey, "json"),
+		Host:       l.str(AppHostEnvKey, ""),
+		Port:       l.str(AppPortEnvKey, ""),
+		SocketPath: l.str(ListenSocketEnvKey, ""),
+		EnableH2C:  l.bool(EnableH2CEnvKey, false),
+		TLS: TLSConfig{
+			CertFile:      l.str(TLSCertFileEnvKey, ""),
+			KeyFile:       l.str(TLSKeyFileEnvKey, ""),
+			ACMEDomains:   parseDomains(l.str(ACMEDomainsEnvKey, "")),
+			ACMECacheDir:  l.str(ACMECacheDirEnvKey, defaultACMECacheDir),
+			DevTLS:        l.bool(DevTLSEnvKey, false),
+			RedirectPort:  l.str(HTTPRedirectPortEnvKey, ""),
+			CanonicalHost: l.str(CanonicalHostEnvKey, ""),
+		},
+		DebugPort:       l.str(DebugPortEnvKey, ""),
+		EnablePprof:     l.bool(EnablePprofEnvKey, false),
+		ShutdownTimeout: l.duration(ShutdownTimeoutEnvKey, defaultShutdownTimeout),
+		Timeouts: ServerTimeouts{
+			ReadHeader: l.duration(ReadHeaderTimeoutEnvKey, defaultReadHeaderTimeout),
+			Read:       l.duration(ReadTimeoutEnvKey, defaultReadTimeout),
+			Write:      l.duration(WriteTimeoutEnvKey, defaultWriteTimeout),
+			Idle:       l.duration(IdleTimeoutEnvKey, defaultIdleTimeout),
+		},
+		RequestTimeout: l.duration(RequestTimeoutEnvKey, defaultRequestTimeout),
+		BodyLimits: BodyLimits{
+			Body:   l.int(MaxBodyBytesEnvKey, defaultMaxBodyBytes, 1),
+			Upload: l.int(MaxUploadBytesEnvKey, defaultMaxUploadBytes, 1),
+		},
+		MaxConcurrent:     int(l.int(MaxConcurrentEnvKey, 0, 0)),
+		ConcurrencyQueue:  l.duration(ConcurrencyQueueEnvKey, defaultConcurrencyQueue),
+		RateLimit:         int(l.int(RateLimitEnvKey, defaultRateLimit, 1)),
+		RateLimitWindow:   l.duration(RateLimitWindowEnvKey, defaultRateLimitWindow),
+		FormMinFillTime:   l.duration(FormMinFillTimeEnvKey, defaultFormMinFillTime),
+		FormSecret:        l.str(FormSecretEnvKey, ""),
+		Store:             l.str(StoreEnvKey, "postgres"),
+		SQLitePath:        l.str(SQLitePathEnvKey, defaultSQLitePath),
+		DB:                l.dbConfig(),
+		DBReadRetries:     int(l.int(DBReadRetriesEnvKey, defaultDBReadRetries, 0)),
+		DBCopyThreshold:   int(l.int(DBCopyThresholdEnvKey, defaultDBCopyThreshold, 0)),
+		BreakerThreshold:  int(l.int(DBBreakerThresholdEnvKey, defaultBreakerThreshold, 1)),
+		BreakerCooldown:   l.duration(DBBreakerCooldownEnvKey, defaultBreakerCooldown),
+		HealthCacheTTL:    l.duration(HealthCacheTTLEnvKey, defaultHealthCacheTTL),
+		HealthPingTimeout: l.duration(HealthPingTimeoutEnvKey, defaultHealthPingTimeout),
+		CleanupInterval:   l.duration(CleanupIntervalEnvKey, defaultCleanupInterval),
+		CleanupBatchSize:  int(l.int(CleanupBatchSizeEnvKey, defaultCleanupBatchSize, 1)),
+		Retention:         time.Duration(l.int(RetentionDaysEnvKey, defaultRetentionDays, 1)) * 24 * time.Hour,
+		JobWorkers:        int(l.int(JobWorkersEnvKey, defaultJobWorkers, 0)),
+		JobPollInterval:   l.duration(JobPollIntervalEnvKey, defaultJobPollInterval),
+		JobMaxAttempts:    int(l.int(JobMaxAttemptsEnvKey, defaultJobMaxAttempts, 1)),
+		UsersCacheTTL:     l.duration(UsersCacheTTLEnvKey, defaultUsersCacheTTL),
+		RedisURL:          l.str(RedisURLEnvKey, ""),
+		InternalToken:     l.str(InternalTokenEnvKey, ""),
+		DebugToken:        l.str(DebugTokenEnvKey, ""),
+		AccessLogSkip:     parsePathSet(l.str(AccessLogSkipPathsEnvKey, "")),
+		Maintenance:       l.bool(MaintenanceModeEnvKey, false),
+		TestEndpoints:     l.bool(EnableTestEndpointsEnvKey, false),
+		Branding: Branding{
+			Title:       l.str(AppTitleEnvKey, defaultAppTitle),
+			AccentColor: l.str(AppAccentColorEnvKey, defaultAccentColor),
+			LogoURL:     l.str(AppLogoURLEnvKey, ""),
+		},
+		OtelEndpoint: l.str(OtelEndpointEnvKey, ""),
+		SlowQuery:    l.duration(SlowQueryEnvKey, defaultSlowQueryThreshold),
+	}
+
+	if v := l.str(LogLevelEnvKey, ""); v != "" {
+		level, err := ParseLogLevel(v)
+		l.check(LogLevelEnvKey, err)
+		c.LogLevel = level
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		l.fail(LogFormatEnvKey, "must be json or text, got %q", c.LogFormat)
+	}
+
+	c.SocketMode = defaultSocketMode
+	if v := l.str(SocketModeEnvKey, ""); v != "" {
+		mode, err := parseSocketMode(v)
+		l.check(SocketModeEnvKey, err)
+		c.SocketMode = mode
+	}
+
+	budgets, err := parseLatencyBudgets(l.duration(LatencyBudgetEnvKey, defaultLatencyBudget), l.str(RouteLatencyBudgetsEnvKey, ""))
+	l.check(RouteLatencyBudgetsEnvKey, err)
+	c.LatencyBudgets = budgets
+
+	proxies, err := parseTrustedProxies(l.str(TrustedProxiesEnvKey, ""))
+	l.check(TrustedProxiesEnvKey, err)
+	c.TrustedProxies = proxies
+
+	flags, err := parseFeatureFlags(l.str(FeatureFlagsEnvKey, ""))
+	l.check(FeatureFlagsEnvKey, err)
+	c.FeatureFlags = flags
+
+	if !slices.Contains(accentColors, c.Branding.AccentColor) {
+		l.fail(AppAccentColorEnvKey, "must be one of %s, got %q", strings.Join(accentColors, ", "), c.Branding.AccentColor)
+	}
+	l.check(AppLogoURLEnvKey, checkLogoURL(c.Branding.LogoURL))
+	if !slices.Contains(storeBackends, c.Store) {
+		l.fail(StoreEnvKey, "must be one of %s, got %q", strings.Join(storeBackends, ", "), c.Store)
+	}
+	if c.HealthPingTimeout == 0 {
+		l.fail(HealthPingTimeoutEnvKey, "must be greater than zero")
+	}
+	if c.JobPollInterval == 0 {
+		l.fail(JobPollIntervalEnvKey, "must be greater than zero")
+	}
+	if c.DB.SSLMode != "" && !slices.Contains(pgSSLModes, c.DB.SSLMode) {
+		l.fail(DbSSLModeEnvKey, "must be one of %s, got %q", strings.Join(pgSSLModes, ", "), c.DB.SSLMode)
+	}
+	for _, f := range []struct{ key, path string }{
+		{DbSSLRootCertEnvKey, c.DB.SSLRootCert},
+		{DbSSLCertEnvKey, c.DB.SSLCert},
+		{DbSSLKeyEnvKey, c.DB.SSLKey},
+	} {
+		if f.path != "" {
+			_, err := os.Stat(f.path)
+			l.check(f.key, err)
+		}
+	}
+	if (c.DB.SSLCert == "") != (c.DB.SSLKey == "") {
+		l.fail(DbSSLCertEnvKey, "must be set together with %s", DbSSLKeyEnvKey)
+	}
+	// Parse the pool config now so that a bad URL or pool size stops
+	// startup rather than the first connection attempt.
+	if poolCfg, err := c.DB.PoolConfig(); err != nil {
+		if c.DB.URL != "" {
+			l.check(DatabaseURLEnvKey, err)
+		}
+	} else if poolCfg.MinConns > poolCfg.MaxConns {
+		l.fail(DbMinConnsEnvKey, "%d is more than the %d max connections", poolCfg.MinConns, poolCfg.MaxConns)
+	}
+	if c.DB.ReplicaURL != "" {
+		_, err := c.DB.Replica().PoolConfig()
+		l.check(DbReplicaURLEnvKey, err)
+	}
+	if c.DB.URL == "" {
+		if c.DB.Password != "" && c.DB.Host == "" {
+			l.fail(DbHostEnvKey, "must be set when %s is set", DbPasswordEnvKey)
+		}
+		if c.DB.Host == "" {
+			c.DB.Host = "localhost"
+		}
+	}
+	if c.DB.Port != "" {
+		l.checkPort(DbPortEnvKey, c.DB.Port)
+	}
+
+	c.validateTLS(&l)
+
+	// The main listener moves to 443 under ACME; otherwise it defaults to
+	// 8080 unless only the Unix socket was asked for.
+	switch {
+	case len(c.TLS.ACMEDomains) > 0:
+		c.Port = ACMEHTTPSPort
+	case c.Port == "" && (c.SocketPath == "" || c.TLS.Enabled()):
+		c.Port = defaultAppPort
+	}
+	for _, p := range []struct{ key, port string }{
+		{AppPortEnvKey, c.Port},
+		{HTTPRedirectPortEnvKey, c.TLS.RedirectPort},
+		{DebugPortEnvKey, c.DebugPort},
+	} {
+		if p.port == "" {
+			continue
+		}
+		if _, err := bindAddr(c.Host, p.port); err != nil {
+			l.fail(p.key, "%v", err)
+		}
+	}
+
+	c.sources, c.configFile = l.sources, configFile
+	for key := range l.file {
+		if !l.asked[key] {
+			c.unknownFileKeys = append(c.unknownFileKeys, key)
+		}
+	}
+	slices.Sort(c.unknownFileKeys)
+
+	if len(l.errs) > 0 {
+		return c, errors.Join(l.errs...)
+	}
+	return c, nil
+}
+
+// LogSources logs where every setting came from (secrets redacted) at
+// debug level, and warns about config file keys that matched nothing.
+func (c Config) LogSources(logger *slog.Logger) {
+	if len(c.unknownFileKeys) > 0 {
+		logger.Warn("unknown keys in config file", "file", c.configFile, "keys", c.unknownFileKeys)
+	}
+	logger.Debug("configuration loaded", "precedence", "flag > env > file > default", "file", c.configFile)
+	for _, s := range c.sources {
+		value := s.Value
+		if isSecretKey(s.Key) && value != "" {
+			value = "[redacted]"
+		}
+		logger.Debug("config", "key", s.Key, "value", value, "source", s.Source)
+	}
+}
+
+func isSecretKey(key string) bool {
+	// DATABASE_URL usually embeds the password.
+	return key == DatabaseURLEnvKey || key == DbReplicaURLEnvKey || strings.Contains(key, "PASSWORD") || strings.Contains(key, "TOKEN") || strings.Contains(key, "SECRET")
+}
+
+func (c Config) validateTLS(l *envLoader) {
+	t := c.TLS
+	files := t.CertFile != "" || t.KeyFile != ""
+	if t.DevTLS && (files || len(t.ACMEDomains) > 0) {
+		l.fail(DevTLSEnvKey, "is for local development and cannot be combined with %s/%s or %s", TLSCertFileEnvKey, TLSKeyFileEnvKey, ACMEDomainsEnvKey)
+	}
+	if len(t.ACMEDomains) > 0 && files {
+		l.fail(ACMEDomainsEnvKey, "cannot be combined with %s/%s", TLSCertFileEnvKey, TLSKeyFileEnvKey)
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		l.fail(TLSCertFileEnvKey, "%s and %s must be set together", TLSCertFileEnvKey, TLSKeyFileEnvKey)
+	}
+	if t.CanonicalHost != "" && !ValidHost(t.CanonicalHost) {
+		l.fail(CanonicalHostEnvKey, "%q is not a valid host name", t.CanonicalHost)
+	}
+}
+
+// envLoader reads settings from flags, env vars and the config file, and
+// collects parse errors instead of stopping at the first one.
+type envLoader struct {
+	flags   map[string]string
+	file    map[string]string
+	asked   map[string]bool
+	sources []configSource
+	errs    []error
+	// defaultsOnly ignores env vars, leaving every setting at its default.
+	defaultsOnly bool
+}
+
+func (l *envLoader) fail(key, format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+func (l *envLoader) check(key string, err error) {
+	if err != nil {
+		l.fail(key, "%v", err)
+	}
+}
+
+// lookup returns the raw value for key from the first of flags,
+// environment and config file that sets it, and records where it came from.
+// Secrets can instead name a file in KEY_FILE (Docker and Kubernetes mount
+// secrets as files); its trimmed contents are the value.
+func (l *envLoader) lookup(key string) string {
+	source, v := l.raw(key)
+	if isSecretKey(key) {
+		fileKey := key + secretFileSuffix
+		if fileSource, path := l.raw(fileKey); path != "" {
+			if v != "" {
+				l.fail(fileKey, "cannot be set together with %s", key)
+			} else if data, err := os.ReadFile(path); err != nil {
+				l.check(fileKey, err)
+			} else {
+				source, v = fileSource+" "+fileKey, strings.TrimSpace(string(data))
+			}
+		}
+	}
+	l.sources = append(l.sources, configSource{Key: key, Value: v, Source: source})
+	return v
+}
+
+func (l *envLoader) raw(key string) (source, value string) {
+	l.asked[key] = true
+	if flag, ok := l.flags[key]; ok {
+		return "flag", flag
+	}
+	if env := os.Getenv(key); env != "" && !l.defaultsOnly {
+		return "env", env
+	}
+	if file := l.file[key]; file != "" {
+		return "file", file
+	}
+	return "default", ""
+}
+
+// useDefault records def as the value of the key just looked up.
+func (l *envLoader) useDefault(def string) {
+	l.sources[len(l.sources)-1].Value = def
+}
+
+func (l *envLoader) str(key, def string) string {
+	if v := l.lookup(key); v != "" {
+		return v
+	}
+	l.useDefault(def)
+	return def
+}
+
+// dbConfig reads DATABASE_URL and the DB_* vars. Next to a URL the DB_* vars
+// have no defaults, so only the ones actually set override it.
+func (l *envLoader) dbConfig() DBConfig {
+	c := DBConfig{URL: l.str(DatabaseURLEnvKey, ""), ReplicaURL: l.str(DbReplicaURLEnvKey, "")}
+	def := func(v string) string {
+		if c.URL != "" {
+			return ""
+		}
+		return v
+	}
+	c.User = l.str(DbUserEnvKey, def("postgres"))
+	c.Password = l.str(DbPasswordEnvKey, "")
+	c.Host = l.str(DbHostEnvKey, "")
+	c.Port = l.str(DbPortEnvKey, def("5432"))
+	c.Name = l.str(DbNameEnvKey, def("postgres"))
+	c.SSLRootCert = l.str(DbSSLRootCertEnvKey, "")
+	c.SSLCert = l.str(DbSSLCertEnvKey, "")
+	c.SSLKey = l.str(DbSSLKeyEnvKey, "")
+	// A root certificate is only useful when it is checked.
+	sslDefault := ""
+	if c.SSLRootCert != "" {
+		sslDefault = "verify-full"
+	}
+	c.SSLMode = l.str(DbSSLModeEnvKey, sslDefault)
+	c.MaxConns = int32(l.int(DbMaxConnsEnvKey, 0, 1))
+	c.MinConns = int32(l.int(DbMinConnsEnvKey, 0, 0))
+	c.MaxConnLifetime = l.duration(DbMaxConnLifetimeEnvKey, 0)
+	c.MaxConnIdleTime = l.duration(DbMaxConnIdleEnvKey, 0)
+	c.HealthCheckPeriod = l.duration(DbHealthCheckEnvKey, 0)
+	c.ConnectTimeout = l.duration(DbConnectTimeoutEnvKey, defaultDBConnectTimeout)
+	c.AttemptTimeout = l.duration(DbAttemptTimeoutEnvKey, defaultDBAttemptTimeout)
+	c.SchemaTimeout = l.duration(DbSchemaTimeoutEnvKey, defaultDBSchemaTimeout)
+	c.StatementTimeout = l.duration(DbStmtTimeoutEnvKey, defaultStatementTimeout)
+	c.LazyConnect = l.bool(DbLazyConnectEnvKey, false)
+	c.MigrateOnStart = l.bool(MigrateOnStartEnvKey, true)
+	return c
+}
+
+func (l *envLoader) bool(key string, def bool) bool {
+	v := l.lookup(key)
+	if v == "" {
+		l.useDefault(strconv.FormatBool(def))
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.fail(key, "invalid boolean %q (use true or false)", v)
+		return def
+	}
+	return b
+}
+
+func (l *envLoader) duration(key string, def time.Duration) time.Duration {
+	v := l.lookup(key)
+	if v == "" {
+		l.useDefault(def.String())
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		l.fail(key, "invalid duration %q (e.g. 500ms, 10s, 2m)", v)
+		return def
+	}
+	return d
+}
+
+// int reads an integer of at least min, which is 0 for the settings where
+// zero means none or no limit.
+func (l *envLoader) int(key string, def, min int64) int64 {
+	v := l.lookup(key)
+	if v == "" {
+		l.useDefault(strconv.FormatInt(def, 10))
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < min {
+		l.fail(key, "invalid integer %q (must be at least %d)", v, min)
+		return def
+	}
+	return n
+}
+
+func (l *envLoader) checkPort(key, port string) {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		l.fail(key, "invalid port %q (must be 1-65535)", port)
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const ConfigFileEnvKey = "CONFIG_FILE"
+
+// loadConfigFile reads a YAML or JSON (by .json extension) document and
+// flattens it onto env var names: nested keys are joined with underscores
+// and upper-cased, so
+//
+//	db:
+//	  host: db.internal
+//
+// sets DB_HOST. Lists become comma-separated values.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &doc)
+	} else {
+		err = yaml.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", doc, values)
+	return values, nil
+}
+
+func flattenConfig(prefix string, v any, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			key := strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+			if prefix != "" {
+				key = prefix + "_" + key
+			}
+			flattenConfig(key, child, out)
+		}
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+		out[prefix] = ""
+	default:
+		out[prefix] = fmt.Sprint(v)
+	}
+}
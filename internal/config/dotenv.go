@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	EnvFileEnvKey  = "ENV_FILE"
+	defaultEnvFile = ".env"
+)
+
+// LoadDotEnv sets variables from path, or ENV_FILE, or ./.env, for local
+// development. Variables already in the environment always win, and a
+// missing file is not an error.
+func LoadDotEnv(path string) error {
+	if path == "" {
+		path = os.Getenv(EnvFileEnvKey)
+	}
+	if path == "" {
+		path = defaultEnvFile
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	vars, err := parseDotEnv(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, kv := range vars {
+		if _, set := os.LookupEnv(kv[0]); !set {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+	return nil
+}
+
+// parseDotEnv parses KEY=VALUE lines in file order. It accepts an optional
+// "export " prefix, # comments, CRLF line endings, single-quoted (literal)
+// and double-quoted (with \n, \" and \\ escapes) values.
+func parseDotEnv(data []byte) ([][2]string, error) {
+	var vars [][2]string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(strings.TrimSuffix(sc.Text(), "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validEnvKey(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		value, err := parseDotEnvValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	return vars, sc.Err()
+}
+
+func parseDotEnvValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, "'"):
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated single quote")
+		}
+		return raw[1 : end+1], trailingComment(raw[end+2:])
+
+	case strings.HasPrefix(raw, `"`):
+		var b strings.Builder
+		for i := 1; i < len(raw); i++ {
+			c := raw[i]
+			if c == '"' {
+				return b.String(), trailingComment(raw[i+1:])
+			}
+			if c == '\\' && i+1 < len(raw) {
+				i++
+				switch raw[i] {
+				case 'n':
+					c = '\n'
+				case 'r':
+					c = '\r'
+				case 't':
+					c = '\t'
+				default:
+					c = raw[i]
+				}
+			}
+			b.WriteByte(c)
+		}
+		return "", errors.New("unterminated double quote")
+
+	default:
+		// An unquoted value ends at a " #" comment.
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = raw[:i]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+func trailingComment(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after closing quote", rest)
+	}
+	return nil
+}
+
+func validEnvKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, c := range key {
+		letter := c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	data := "# comment\r\n" +
+		"\r\n" +
+		"PLAIN=value\r\n" +
+		"export EXPORTED=yes\n" +
+		"SPACED = padded  # trailing comment\n" +
+		"HASH=a#b\n" +
+		"SINGLE='literal \\n $HOME' # comment\n" +
+		"DOUBLE=\"line\\nnext \\\"quoted\\\" \\\\\"\n" +
+		"EMPTY=\n"
+	got, err := parseDotEnv([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{
+		{"PLAIN", "value"},
+		{"EXPORTED", "yes"},
+		{"SPACED", "padded"},
+		{"HASH", "a#b"},
+		{"SINGLE", `literal \n $HOME`},
+		{"DOUBLE", "line\nnext \"quoted\" \\"},
+		{"EMPTY", ""},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseDotEnv = %q\nwant %q", got, want)
+	}
+
+	for _, bad := range []string{
+		"NOVALUE",
+		"1KEY=x",
+		"BAD-KEY=x",
+		"OPEN='x",
+		`OPEN="x`,
+		`AFTER="x" y`,
+	} {
+		if _, err := parseDotEnv([]byte("OK=1\n" + bad + "\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+			t.Errorf("parseDotEnv(%q) error = %v, want one on line 2", bad, err)
+		}
+	}
+}
+
+func TestLoadDotEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.env")
+	if err := os.WriteFile(path, []byte("DOTENV_TEST_NEW=file\nDOTENV_TEST_SET=file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOTENV_TEST_SET", "env")
+	t.Setenv("DOTENV_TEST_NEW", "")
+	os.Unsetenv("DOTENV_TEST_NEW")
+
+	if err := LoadDotEnv(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("DOTENV_TEST_NEW"); got != "file" {
+		t.Errorf("DOTENV_TEST_NEW = %q, want file", got)
+	}
+	if got := os.Getenv("DOTENV_TEST_SET"); got != "env" {
+		t.Errorf("DOTENV_TEST_SET = %q, want the environment's value to win", got)
+	}
+	if err := LoadDotEnv(filepath.Join(t.TempDir(), "missing.env")); err != nil {
+		t.Errorf("LoadDotEnv of a missing file = %v, want nil", err)
+	}
+}
+
+func TestLoadDotEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.env")
+	if err := os.WriteFile(path, []byte("export DOTENV_TEST_FILE='from ENV_FILE'\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvFileEnvKey, path)
+	t.Setenv("DOTENV_TEST_FILE", "")
+	os.Unsetenv("DOTENV_TEST_FILE")
+
+	if err := LoadDotEnv(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("DOTENV_TEST_FILE"); got != "from ENV_FILE" {
+		t.Errorf("DOTENV_TEST_FILE = %q, want the value from ENV_FILE", got)
+	}
+
+	bad := filepath.Join(dir, "bad.env")
+	if err := os.WriteFile(bad, []byte("OK=1\nNOT A LINE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDotEnv(bad); err == nil || !strings.HasPrefix(err.Error(), bad+": line 2: ") {
+		t.Errorf("LoadDotEnv of a bad file = %v, want an error naming the file and line", err)
+	}
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// configOption describes one setting for the command line. Every setting is
+// an env var; its flag is the lower-cased name with dashes (DB_HOST is
+// --db-host).
+type configOption struct {
+	env   string
+	group string
+	usage string
+}
+
+var configGroups = []string{"HTTP", "TLS", "Database", "Logging", "Branding", "Operations"}
+
+var configOptions = []configOption{
+	{AppHostEnvKey, "HTTP", "interface to bind, empty for all (alias --host)"},
+	{AppPortEnvKey, "HTTP", "main listener port (alias --port)"},
+	{ListenSocketEnvKey, "HTTP", "also serve on this Unix socket path"},
+	{SocketModeEnvKey, "HTTP", "octal permissions of the Unix socket"},
+	{EnableH2CEnvKey, "HTTP", "accept prior-knowledge HTTP/2 on cleartext listeners"},
+	{ShutdownTimeoutEnvKey, "HTTP", "how long to drain connections on shutdown"},
+	{ReadHeaderTimeoutEnvKey, "HTTP", "server read header timeout"},
+	{ReadTimeoutEnvKey, "HTTP", "server read timeout"},
+	{WriteTimeoutEnvKey, "HTTP", "server write timeout"},
+	{IdleTimeoutEnvKey, "HTTP", "keep-alive idle timeout"},
+	{RequestTimeoutEnvKey, "HTTP", "per-request timeout before answering 503"},
+	{MaxBodyBytesEnvKey, "HTTP", "request body limit in bytes"},
+	{MaxUploadBytesEnvKey, "HTTP", "multipart upload limit in bytes"},
+	{MaxConcurrentEnvKey, "HTTP", "max concurrent database-bound requests, 0 for no limit"},
+	{ConcurrencyQueueEnvKey, "HTTP", "how long a request may wait for a concurrency slot"},
+	{TrustedProxiesEnvKey, "HTTP", "comma-separated CIDRs allowed to set X-Forwarded-For"},
+	{RateLimitEnvKey, "HTTP", "user creations a client may make per window"},
+	{RateLimitWindowEnvKey, "HTTP", "sliding window of the rate limit, shared between instances on Postgres; 0 for no limit"},
+	{FormMinFillTimeEnvKey, "HTTP", "least time between showing the add-user form and its submission, 0 for no check"},
+	{FormSecretEnvKey, "HTTP", "key signing the add-user form's render time and undo links; required, the same on every instance, when running more than one; random per process when unset"},
+
+	{TLSCertFileEnvKey, "TLS", "certificate file; reloaded on SIGHUP"},
+	{TLSKeyFileEnvKey, "TLS", "private key file"},
+	{ACMEDomainsEnvKey, "TLS", "comma-separated domains to obtain certificates for over ACME"},
+	{ACMECacheDirEnvKey, "TLS", "directory caching ACME certificates"},
+	{DevTLSEnvKey, "TLS", "serve a self-signed certificate for local development"},
+	{HTTPRedirectPortEnvKey, "TLS", "port of a plain HTTP listener redirecting to HTTPS"},
+	{CanonicalHostEnvKey, "TLS", "host name HTTPS redirects point at"},
+
+	{StoreEnvKey, "Database", "where users are kept: postgres, sqlite, or memory for demos without a database"},
+	{SQLitePathEnvKey, "Database", "database file with STORE=sqlite"},
+	{DatabaseURLEnvKey, "Database", "Postgres connection string; DB_* settings override its parts"},
+	{DbReplicaURLEnvKey, "Database", "read replica connection string; read-only queries go there"},
+	{DbHostEnvKey, "Database", "Postgres host"},
+	{DbPortEnvKey, "Database", "Postgres port"},
+	{DbUserEnvKey, "Database", "Postgres user"},
+	{DbPasswordEnvKey, "Database", "Postgres password"},
+	{DbNameEnvKey, "Database", "Postgres database name"},
+	{DbSSLModeEnvKey, "Database", "disable, require, verify-ca or verify-full; verify-full when a root cert is given"},
+	{DbSSLRootCertEnvKey, "Database", "CA certificate to verify the server against"},
+	{DbSSLCertEnvKey, "Database", "client certificate"},
+	{DbSSLKeyEnvKey, "Database", "client private key"},
+	{DbMaxConnsEnvKey, "Database", "pool size limit"},
+	{DbMinConnsEnvKey, "Database", "connections the pool keeps open when idle"},
+	{DbMaxConnLifetimeEnvKey, "Database", "age after which a pooled connection is replaced"},
+	{DbMaxConnIdleEnvKey, "Database", "idle time after which a pooled connection is closed"},
+	{DbHealthCheckEnvKey, "Database", "how often idle pooled connections are checked"},
+	{DbConnectTimeoutEnvKey, "Database", "how long startup keeps retrying the first connection"},
+	{DbAttemptTimeoutEnvKey, "Database", "timeout of each startup connection attempt"},
+	{DbStmtTimeoutEnvKey, "Database", "longest a single statement may run, 0 for the server default"},
+	{DbSchemaTimeoutEnvKey, "Database", "timeout of the schema setup at startup"},
+	{MigrateOnStartEnvKey, "Database", "apply pending migrations at startup; when false, readiness fails while the schema is behind"},
+	{DbLazyConnectEnvKey, "Database", "start serving before the database is reachable, answering 503 until it is"},
+	{DBReadRetriesEnvKey, "Database", "retries of read queries on transient errors, 0 for none"},
+	{DBCopyThresholdEnvKey, "Database", "bulk inserts of more rows than this use COPY instead of a batch, 0 to always use COPY"},
+	{DBBreakerThresholdEnvKey, "Database", "consecutive failures that open the circuit breaker"},
+	{DBBreakerCooldownEnvKey, "Database", "how long the circuit breaker stays open"},
+	{HealthCacheTTLEnvKey, "Database", "how long a readiness database ping is reused"},
+	{UsersCacheTTLEnvKey, "Database", "how long GET /api/users responses are cached, 0 to never"},
+	{RedisURLEnvKey, "Database", "redis:// URL to share the cache between instances; the local cache stands in while it is down"},
+	{HealthPingTimeoutEnvKey, "Database", "how long the readiness database ping may take"},
+	{CleanupIntervalEnvKey, "Database", "how often expired rows are swept, 0 to never"},
+	{CleanupBatchSizeEnvKey, "Database", "most rows one cleanup statement deletes"},
+	{RetentionDaysEnvKey, "Database", "days deleted rows are kept before cleanup removes them"},
+	{JobWorkersEnvKey, "Database", "background job workers per instance, 0 to only enqueue jobs"},
+	{JobPollIntervalEnvKey, "Database", "how often an idle job worker checks for due jobs"},
+	{JobMaxAttemptsEnvKey, "Database", "attempts before a failing job is moved to the dead letters"},
+
+	{LogLevelEnvKey, "Logging", "debug, info, warn or error"},
+	{LogFormatEnvKey, "Logging", "json or text"},
+	{AccessLogSkipPathsEnvKey, "Logging", "comma-separated paths left out of the access log"},
+	{SlowQueryEnvKey, "Logging", "log queries slower than this"},
+	{LatencyBudgetEnvKey, "Logging", "log requests slower than this"},
+	{RouteLatencyBudgetsEnvKey, "Logging", "per-route budgets overriding it, as /prefix=duration,..."},
+	{OtelEndpointEnvKey, "Logging", "OTLP endpoint; enables tracing"},
+
+	{AppTitleEnvKey, "Branding", "name shown in page titles and the header"},
+	{AppAccentColorEnvKey, "Branding", "Tailwind color of buttons and links, e.g. indigo or emerald"},
+	{AppLogoURLEnvKey, "Branding", "http(s) or site-relative URL of a logo for the header"},
+
+	{ConfigFileEnvKey, "Operations", "YAML or JSON config file (alias --config)"},
+	{EnvFileEnvKey, "Operations", ".env file to load"},
+	{InternalTokenEnvKey, "Operations", "bearer token for /_internal/ admin endpoints"},
+	{DebugTokenEnvKey, "Operations", "X-Debug header value that enables debug logging for one request"},
+	{DebugPortEnvKey, "Operations", "serve debug endpoints on this port instead of the main one"},
+	{EnablePprofEnvKey, "Operations", "expose pprof under the debug endpoints"},
+	{MaintenanceModeEnvKey, "Operations", "start in maintenance mode"},
+	{FeatureFlagsEnvKey, "Operations", "feature flag overrides, as name=true,name=false,..."},
+	{EnableTestEndpointsEnvKey, "Operations", "serve POST /_internal/reset, which empties the database; never in production"},
+}
+
+var flagAliases = map[string]string{
+	"host":   AppHostEnvKey,
+	"port":   AppPortEnvKey,
+	"config": ConfigFileEnvKey,
+}
+
+// Flags is the parsed command line: explicit settings keyed by env var
+// name, which override everything else, and the one-off commands.
+type Flags struct {
+	Values      map[string]string
+	PrintConfig bool
+}
+
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// ParseFlags parses args. Only flags given explicitly end up in Values, so
+// unset flags fall through to env vars and the config file.
+func ParseFlags(args []string, usageOut io.Writer) (Flags, error) {
+	fs := flag.NewFlagSet("exam", flag.ContinueOnError)
+	fs.SetOutput(usageOut)
+	fs.Usage = func() { printUsage(usageOut) }
+
+	envByFlag := make(map[string]string, len(configOptions)+len(flagAliases))
+	for _, opt := range configOptions {
+		fs.String(flagName(opt.env), "", opt.usage)
+		envByFlag[flagName(opt.env)] = opt.env
+	}
+	for _, opt := range configOptions {
+		if isSecretKey(opt.env) {
+			name := flagName(opt.env + secretFileSuffix)
+			fs.String(name, "", "file holding --"+flagName(opt.env))
+			envByFlag[name] = opt.env + secretFileSuffix
+		}
+	}
+	for alias, env := range flagAliases {
+		fs.String(alias, "", "alias of --"+flagName(env))
+		envByFlag[alias] = env
+	}
+	var out Flags
+	fs.BoolVar(&out.PrintConfig, "print-config", false, "print the effective configuration and exit")
+
+	if err := fs.Parse(args); err != nil {
+		return out, err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return out, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	out.Values = map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		if env, ok := envByFlag[f.Name]; ok {
+			out.Values[env] = f.Value.String()
+		}
+	})
+	return out, nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: exam [flags]\n       exam healthcheck [--ready] [--timeout 3s]\n\n")
+	fmt.Fprintf(w, "Every flag mirrors an environment variable. Precedence: flag > env > CONFIG_FILE > default.\n")
+	fmt.Fprintf(w, "Secrets can also be read from a file: DB_PASSWORD_FILE or --db-password-file, and so on.\n")
+	for _, group := range configGroups {
+		fmt.Fprintf(w, "\n%s:\n", group)
+		for _, opt := range configOptions {
+			if opt.group != group {
+				continue
+			}
+			current := ""
+			if v := os.Getenv(opt.env); v != "" {
+				if isSecretKey(opt.env) {
+					v = "[redacted]"
+				}
+				current = ", now " + v
+			}
+			fmt.Fprintf(w, "  --%-30s %s (%s%s)\n", flagName(opt.env), opt.usage, opt.env, current)
+		}
+	}
+	fmt.Fprintf(w, "\n  --%-30s %s\n", "print-config", "print the effective configuration and exit")
+}
+
+// PrintConfig writes every setting with its source, secrets masked.
+func (c Config) PrintConfig(w io.Writer) {
+	for _, s := range c.sources {
+		value := s.Value
+		if isSecretKey(s.Key) && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(w, "%s=%s\t# %s\n", s.Key, value, s.Source)
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"io/fs"
+	"time"
+)
+
+// Every setting is read from an env var named by one of these keys; see
+// configOptions for what each one does.
+const (
+	AppHostEnvKey           = "APP_HOST"
+	AppPortEnvKey           = "APP_PORT"
+	DatabaseURLEnvKey       = "DATABASE_URL"
+	DbReplicaURLEnvKey      = "DB_REPLICA_URL"
+	DbUserEnvKey            = "DB_USER"
+	DbPasswordEnvKey        = "DB_PASSWORD"
+	DbHostEnvKey            = "DB_HOST"
+	DbPortEnvKey            = "DB_PORT"
+	DbNameEnvKey            = "DB_NAME"
+	DbSSLModeEnvKey         = "DB_SSLMODE"
+	DbSSLRootCertEnvKey     = "DB_SSLROOTCERT"
+	DbSSLCertEnvKey         = "DB_SSLCERT"
+	DbSSLKeyEnvKey          = "DB_SSLKEY"
+	DbMaxConnsEnvKey        = "DB_MAX_CONNS"
+	DbMinConnsEnvKey        = "DB_MIN_CONNS"
+	DbMaxConnLifetimeEnvKey = "DB_MAX_CONN_LIFETIME"
+	DbMaxConnIdleEnvKey     = "DB_MAX_CONN_IDLE_TIME"
+	DbHealthCheckEnvKey     = "DB_HEALTH_CHECK_PERIOD"
+	DbConnectTimeoutEnvKey  = "DB_CONNECT_TIMEOUT"
+	DbAttemptTimeoutEnvKey  = "DB_CONNECT_ATTEMPT_TIMEOUT"
+	DbSchemaTimeoutEnvKey   = "DB_SCHEMA_TIMEOUT"
+	DbStmtTimeoutEnvKey     = "DB_STATEMENT_TIMEOUT"
+	ShutdownTimeoutEnvKey   = "SHUTDOWN_TIMEOUT"
+	ReadHeaderTimeoutEnvKey = "READ_HEADER_TIMEOUT"
+	ReadTimeoutEnvKey       = "READ_TIMEOUT"
+	WriteTimeoutEnvKey      = "WRITE_TIMEOUT"
+	IdleTimeoutEnvKey       = "IDLE_TIMEOUT"
+	defaultDBConnectTimeout = 30 * time.Second
+	defaultDBAttemptTimeout = 5 * time.Second
+	defaultDBSchemaTimeout  = 30 * time.Second
+	defaultStatementTimeout = 30 * time.Second
+)
+
+const (
+	EnableH2CEnvKey          = "ENABLE_H2C"
+	ListenSocketEnvKey       = "LISTEN_SOCKET"
+	SocketModeEnvKey         = "SOCKET_MODE"
+	RequestTimeoutEnvKey     = "REQUEST_TIMEOUT"
+	AccessLogSkipPathsEnvKey = "ACCESS_LOG_SKIP_PATHS"
+	MaxBodyBytesEnvKey       = "MAX_BODY_BYTES"
+	MaxUploadBytesEnvKey     = "MAX_UPLOAD_BYTES"
+	MaxConcurrentEnvKey      = "MAX_CONCURRENT"
+	ConcurrencyQueueEnvKey   = "CONCURRENCY_QUEUE_TIMEOUT"
+	TrustedProxiesEnvKey     = "TRUSTED_PROXIES"
+	RateLimitEnvKey          = "RATE_LIMIT"
+	RateLimitWindowEnvKey    = "RATE_LIMIT_WINDOW"
+	FormMinFillTimeEnvKey    = "FORM_MIN_FILL_TIME"
+	FormSecretEnvKey         = "FORM_SECRET"
+
+	defaultShutdownTimeout   = 15 * time.Second
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 10 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultRequestTimeout    = 10 * time.Second
+	defaultMaxBodyBytes      = 1 << 20
+	defaultMaxUploadBytes    = 32 << 20
+	defaultConcurrencyQueue  = 250 * time.Millisecond
+	defaultRateLimit         = 20
+	defaultRateLimitWindow   = time.Minute
+	defaultFormMinFillTime   = 2 * time.Second
+
+	defaultSocketMode fs.FileMode = 0o660
+)
+
+const (
+	TLSCertFileEnvKey      = "TLS_CERT_FILE"
+	TLSKeyFileEnvKey       = "TLS_KEY_FILE"
+	HTTPRedirectPortEnvKey = "HTTP_REDIRECT_PORT"
+	ACMEDomainsEnvKey      = "ACME_DOMAINS"
+	ACMECacheDirEnvKey     = "ACME_CACHE_DIR"
+	DevTLSEnvKey           = "DEV_TLS"
+	CanonicalHostEnvKey    = "CANONICAL_HOST"
+
+	defaultACMECacheDir = "acme-cache"
+
+	// ACMEHTTPPort answers HTTP-01 challenges in ACME mode, where the main
+	// listener moves to ACMEHTTPSPort.
+	ACMEHTTPPort  = "80"
+	ACMEHTTPSPort = "443"
+)
+
+const (
+	StoreEnvKey              = "STORE"
+	SQLitePathEnvKey         = "SQLITE_PATH"
+	DbLazyConnectEnvKey      = "DB_LAZY_CONNECT"
+	MigrateOnStartEnvKey     = "MIGRATE_ON_START"
+	DBReadRetriesEnvKey      = "DB_READ_RETRIES"
+	DBCopyThresholdEnvKey    = "DB_COPY_THRESHOLD"
+	DBBreakerThresholdEnvKey = "DB_BREAKER_THRESHOLD"
+	DBBreakerCooldownEnvKey  = "DB_BREAKER_COOLDOWN"
+	HealthCacheTTLEnvKey     = "HEALTH_CACHE_TTL"
+	HealthPingTimeoutEnvKey  = "HEALTH_PING_TIMEOUT"
+	CleanupIntervalEnvKey    = "CLEANUP_INTERVAL"
+	CleanupBatchSizeEnvKey   = "CLEANUP_BATCH_SIZE"
+	RetentionDaysEnvKey      = "RETENTION_DAYS"
+	JobWorkersEnvKey         = "JOB_WORKERS"
+	JobPollIntervalEnvKey    = "JOB_POLL_INTERVAL"
+	JobMaxAttemptsEnvKey     = "JOB_MAX_ATTEMPTS"
+	UsersCacheTTLEnvKey      = "USERS_CACHE_TTL"
+	RedisURLEnvKey           = "REDIS_URL"
+
+	defaultSQLitePath       = "data/exam.db"
+	defaultDBReadRetries    = 2
+	defaultDBCopyThreshold  = 500
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+	defaultHealthCacheTTL   = 5 * time.Second
+	// defaultHealthPingTimeout leaves room for a database in another zone.
+	defaultHealthPingTimeout = 500 * time.Millisecond
+	defaultCleanupInterval   = time.Hour
+	defaultCleanupBatchSize  = 1000
+	defaultRetentionDays     = 30
+	defaultJobWorkers        = 2
+	defaultJobPollInterval   = time.Second
+	defaultJobMaxAttempts    = 5
+	defaultUsersCacheTTL     = 5 * time.Second
+)
+
+const (
+	LogFormatEnvKey           = "LOG_FORMAT"
+	LogLevelEnvKey            = "LOG_LEVEL"
+	SlowQueryEnvKey           = "SLOW_QUERY_THRESHOLD"
+	LatencyBudgetEnvKey       = "LATENCY_BUDGET"
+	RouteLatencyBudgetsEnvKey = "ROUTE_LATENCY_BUDGETS"
+	OtelEndpointEnvKey        = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+	defaultSlowQueryThreshold = 200 * time.Millisecond
+	defaultLatencyBudget      = 300 * time.Millisecond
+)
+
+const (
+	AppTitleEnvKey       = "APP_TITLE"
+	AppAccentColorEnvKey = "APP_ACCENT_COLOR"
+	AppLogoURLEnvKey     = "APP_LOGO_URL"
+
+	defaultAppTitle    = "Go Docker Exam App"
+	defaultAccentColor = "indigo"
+)
+
+const (
+	InternalTokenEnvKey   = "INTERNAL_API_TOKEN"
+	DebugTokenEnvKey      = "DEBUG_TOKEN"
+	DebugPortEnvKey       = "DEBUG_PORT"
+	EnablePprofEnvKey     = "ENABLE_PPROF"
+	MaintenanceModeEnvKey = "MAINTENANCE_MODE"
+	FeatureFlagsEnvKey    = "FEATURE_FLAGS"
+
+	// EnableTestEndpointsEnvKey must never be set in production.
+	EnableTestEndpointsEnvKey = "ENABLE_TEST_ENDPOINTS"
+)
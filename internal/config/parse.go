@@ -0,0 +1,169 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// storeBackends are the values STORE accepts.
+var storeBackends = []string{"postgres", "memory", "sqlite"}
+
+// accentColors are the Tailwind palette's colors, the values
+// APP_ACCENT_COLOR accepts, since it ends up in class names.
+var accentColors = []string{
+	"slate", "gray", "zinc", "neutral", "stone", "red", "orange", "amber", "yellow", "lime", "green",
+	"emerald", "teal", "cyan", "sky", "blue", "indigo", "violet", "purple", "fuchsia", "pink", "rose",
+}
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+const acceptedLogLevels = "debug, info, warn, error"
+
+func ParseLogLevel(s string) (slog.Level, error) {
+	level, ok := logLevels[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return 0, fmt.Errorf("invalid log level %q (accepted: %s)", s, acceptedLogLevels)
+	}
+	return level, nil
+}
+
+// parseSocketMode parses an octal permission such as "0660".
+func parseSocketMode(raw string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(raw, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal file mode", raw)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs. Bare addresses
+// are accepted as single-host prefixes.
+func parseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// parsePathSet turns a comma-separated list of paths into a lookup set.
+func parsePathSet(list string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			set[p] = struct{}{}
+		}
+	}
+	return set
+}
+
+// parseFeatureFlags parses a comma-separated list of name=bool.
+func parseFeatureFlags(list string) (map[string]bool, error) {
+	flags := map[string]bool{}
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(s, "=")
+		name = strings.TrimSpace(name)
+		on, err := strconv.ParseBool(strings.TrimSpace(value))
+		if !ok || name == "" || err != nil {
+			return nil, fmt.Errorf("%q is not name=true or name=false", s)
+		}
+		flags[name] = on
+	}
+	return flags, nil
+}
+
+// checkLogoURL accepts an empty URL, an absolute http(s) one or a path on
+// this site.
+func checkLogoURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		return err
+	case u.Scheme == "http" || u.Scheme == "https":
+		if u.Host == "" {
+			return fmt.Errorf("%q has no host", raw)
+		}
+	case u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/"):
+		return fmt.Errorf("%q is neither an http(s) URL nor a path starting with /", raw)
+	}
+	return nil
+}
+
+// parseDomains splits a comma-separated domain list, dropping blanks.
+func parseDomains(raw string) []string {
+	var domains []string
+	for _, d := range strings.Split(raw, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, strings.ToLower(d))
+		}
+	}
+	return domains
+}
+
+// ValidHost reports whether h is an IP literal or a DNS name made of
+// letters, digits, hyphens and dots.
+func ValidHost(h string) bool {
+	if h == "" || len(h) > 253 {
+		return false
+	}
+	if net.ParseIP(strings.Trim(h, "[]")) != nil {
+		return true
+	}
+	for _, c := range h {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// bindAddr joins host and port into a listen address. An empty host binds
+// all interfaces; IPv6 literals may be given with or without brackets.
+func bindAddr(host, port string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	addr := net.JoinHostPort(host, port)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q in %s", port, addr)
+	}
+	if host != "" && net.ParseIP(host) == nil && strings.ContainsAny(host, ":/ ") {
+		return "", fmt.Errorf("invalid host %q in %s", host, addr)
+	}
+	return addr, nil
+}
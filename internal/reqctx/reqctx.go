@@ -0,0 +1,38 @@
+// Package reqctx carries the per-request values that both the HTTP layer
+// and the store read from a context: the request id and, for requests with
+// debug logging on, their logger.
+package reqctx
+
+import (
+	"context"
+	"log/slog"
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	debugLoggerKey
+)
+
+// WithRequestID returns ctx carrying the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request id stored by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithDebugLogger returns ctx carrying the request's debug logger.
+func WithDebugLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, debugLoggerKey, logger)
+}
+
+// DebugLogger returns the logger of a request with debug logging on.
+func DebugLogger(ctx context.Context) (*slog.Logger, bool) {
+	l, ok := ctx.Value(debugLoggerKey).(*slog.Logger)
+	return l, ok
+}
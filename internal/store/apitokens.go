@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrUnknownToken is returned by APITokenByHash when no token matches.
+var ErrUnknownToken = errors.New("unknown api token")
+
+// APIToken is an API consumer. Only the SHA-256 of its secret is kept.
+type APIToken struct {
+	ID              int64     `json:"id"`
+	Name            string    `json:"name"`
+	RequestsPerHour int       `json:"requests_per_hour"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// TokenUsage is a token's calls in the hour starting at Window.
+type TokenUsage struct {
+	Token    APIToken  `json:"token"`
+	Window   time.Time `json:"window_start"`
+	Requests int       `json:"requests"`
+}
+
+// APITokens is implemented by stores that keep API tokens and their usage.
+type APITokens interface {
+	CreateAPIToken(ctx context.Context, name string, hash []byte, requestsPerHour int) (APIToken, error)
+	// APITokenByHash returns the token whose secret hashes to hash, or
+	// ErrUnknownToken.
+	APITokenByHash(ctx context.Context, hash []byte) (APIToken, error)
+	// AddUsage adds each token's calls to its count for window, in one
+	// statement however many tokens there are.
+	AddUsage(ctx context.Context, window time.Time, requests map[int64]int) error
+	// Usage lists every token with its calls in window, by id.
+	Usage(ctx context.Context, window time.Time) ([]TokenUsage, error)
+}
+
+const apiTokenColumns = "t.id, t.name, t.requests_per_hour, t.created_at"
+
+func (s *Postgres) CreateAPIToken(ctx context.Context, name string, hash []byte, requestsPerHour int) (APIToken, error) {
+	row := s.queryRow(ctx, "create_api_token", "INSERT INTO api_tokens AS t (name, token_hash, requests_per_hour) VALUES ($1, $2, $3) RETURNING "+apiTokenColumns, name, hash, requestsPerHour)
+	t, err := scanAPIToken(row)
+	return t, pgError(err)
+}
+
+func (s *Postgres) APITokenByHash(ctx context.Context, hash []byte) (APIToken, error) {
+	t, err := scanAPIToken(s.queryRow(ctx, "api_token_by_hash", "SELECT "+apiTokenColumns+" FROM api_tokens t WHERE token_hash = $1", hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIToken{}, ErrUnknownToken
+	}
+	return t, pgError(err)
+}
+
+func (s *Postgres) AddUsage(ctx context.Context, window time.Time, requests map[int64]int) error {
+	ids := make([]int64, 0, len(requests))
+	counts := make([]int32, 0, len(requests))
+	for id, n := range requests {
+		ids = append(ids, id)
+		counts = append(counts, int32(n))
+	}
+	_, err := s.exec(ctx, "add_api_usage", `
+		INSERT INTO api_token_usage (token_id, window_start, requests)
+		SELECT u.id, $2, u.n FROM unnest($1::bigint[], $3::int[]) AS u (id, n)
+		WHERE EXISTS (SELECT 1 FROM api_tokens WHERE id = u.id)
+		ON CONFLICT (token_id, window_start) DO UPDATE SET requests = api_token_usage.requests + EXCLUDED.requests`,
+		ids, window, counts)
+	return pgError(err)
+}
+
+func (s *Postgres) Usage(ctx context.Context, window time.Time) ([]TokenUsage, error) {
+	rows, err := s.query(ctx, "api_usage", `
+		SELECT `+apiTokenColumns+`, COALESCE(u.requests, 0) FROM api_tokens t
+		LEFT JOIN api_token_usage u ON u.token_id = t.id AND u.window_start = $1
+		ORDER BY t.id`, window)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	usage, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TokenUsage, error) {
+		u := TokenUsage{Window: window}
+		err := row.Scan(&u.Token.ID, &u.Token.Name, &u.Token.RequestsPerHour, &u.Token.CreatedAt, &u.Requests)
+		return u, err
+	})
+	return usage, pgError(err)
+}
+
+func scanAPIToken(row pgx.Row) (APIToken, error) {
+	var t APIToken
+	err := row.Scan(&t.ID, &t.Name, &t.RequestsPerHour, &t.CreatedAt)
+	return t, err
+}
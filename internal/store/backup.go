@@ -0,0 +1,295 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrRestoreUnconfirmed is returned by Restore when the confirmation
+	// doesn't name the database being restored.
+	ErrRestoreUnconfirmed = errors.New("restore not confirmed with the database name")
+	// ErrBackupVersion is returned by Restore when the backup was taken at
+	// another schema version than the database is at.
+	ErrBackupVersion = errors.New("backup schema version does not match the database")
+	// ErrInvalidBackup is returned by Restore when the document can't be
+	// read as a backup.
+	ErrInvalidBackup = errors.New("invalid backup")
+)
+
+const (
+	// restoreBatchSize is how many rows Restore inserts per statement.
+	restoreBatchSize = 1000
+	// backupStatementTimeout replaces DB_STATEMENT_TIMEOUT for the queries
+	// of a backup, each of which streams a whole table.
+	backupStatementTimeout = time.Hour
+)
+
+// backupTables are the tables a backup holds, in an order that loads
+// without breaking foreign keys. serial is the column whose sequence is
+// moved past the restored rows, if any.
+var backupTables = []struct{ name, order, serial string }{
+	{"users", "id", "id"},
+	{"user_stats_daily", "day", ""},
+	{"jobs", "id", "id"},
+	{"api_tokens", "id", "id"},
+	{"api_token_usage", "token_id, window_start", ""},
+	{"rate_limits", "key", ""},
+	{"user_merges", "id", "id"},
+}
+
+// backupTableNames lists backupTables for a LOCK or TRUNCATE.
+func backupTableNames() string {
+	names := make([]string, len(backupTables))
+	for i, t := range backupTables {
+		names[i] = t.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// BackupHeader is what a backup says about itself ahead of its tables.
+type BackupHeader struct {
+	SchemaVersion int64     `json:"schema_version"`
+	Database      string    `json:"database"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Backups is implemented by stores that can snapshot every table the app
+// owns and load one back.
+type Backups interface {
+	// WriteBackup writes every table to w, row by row from one snapshot,
+	// as {"schema_version": ..., ..., "tables": {"users": [...], ...}}.
+	WriteBackup(ctx context.Context, w io.Writer) error
+	// Restore replaces every table's rows with the backup read from r, in
+	// one transaction, and returns how many it loaded into each. confirm
+	// must be the database's name, and the backup must be of the schema
+	// version the database is at.
+	Restore(ctx context.Context, r io.Reader, confirm string) (map[string]int, error)
+}
+
+func (s *Postgres) WriteBackup(ctx context.Context, w io.Writer) error {
+	err := s.withStatementTimeout(ctx, backupStatementTimeout, func(tx pgx.Tx) error {
+		// SET LOCAL takes no snapshot, so the isolation level can still be
+		// set after it.
+		if _, err := tx.Exec(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY"); err != nil {
+			return err
+		}
+		var h BackupHeader
+		err := tx.QueryRow(ctx, "SELECT (SELECT COALESCE(max(version), 0) FROM schema_migrations), current_database(), now()").
+			Scan(&h.SchemaVersion, &h.Database, &h.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		// The header's fields, then the tables in the same object.
+		bw := bufio.NewWriter(w)
+		header, _ := json.Marshal(h)
+		bw.Write(header[:len(header)-1])
+		bw.WriteString(`,"tables":{`)
+		for i, t := range backupTables {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			fmt.Fprintf(bw, "%q:[", t.name)
+			if err := writeRows(ctx, tx, bw, t.name, t.order); err != nil {
+				return fmt.Errorf("back up %s: %w", t.name, err)
+			}
+			bw.WriteByte(']')
+		}
+		bw.WriteString("}}\n")
+		return bw.Flush()
+	})
+	return pgError(err)
+}
+
+func writeRows(ctx context.Context, tx pgx.Tx, w *bufio.Writer, table, order string) error {
+	rows, err := tx.Query(ctx, fmt.Sprintf("SELECT row_to_json(t)::text FROM %s t ORDER BY %s", table, order))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for n := 0; rows.Next(); n++ {
+		if n > 0 {
+			w.WriteByte(',')
+		}
+		if _, err := w.Write(rows.RawValues()[0]); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *Postgres) Restore(ctx context.Context, r io.Reader, confirm string) (map[string]int, error) {
+	var database string
+	if err := s.db.QueryRow(ctx, "SELECT current_database()").Scan(&database); err != nil {
+		return nil, pgError(err)
+	}
+	if confirm != database {
+		return nil, ErrRestoreUnconfirmed
+	}
+	// Not WithTx: r can't be read again for a retry.
+	var loaded map[string]int
+	err := s.runTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		loaded, err = restore(ctx, tx, json.NewDecoder(r))
+		return err
+	})
+	return loaded, err
+}
+
+// restore reads the backup from dec, checking its schema version before
+// any table is touched, then empties every table and loads the backup's.
+func restore(ctx context.Context, tx pgx.Tx, dec *json.Decoder) (map[string]int, error) {
+	var current int64
+	if err := tx.QueryRow(ctx, "SELECT COALESCE(max(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return nil, pgError(err)
+	}
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	var version int64 = -1
+	var loaded map[string]int
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+		}
+		switch key {
+		case "schema_version":
+			if err := dec.Decode(&version); err != nil {
+				return nil, fmt.Errorf("%w: schema_version: %w", ErrInvalidBackup, err)
+			}
+			if version != current {
+				return nil, fmt.Errorf("%w: backup is at %d, database at %d", ErrBackupVersion, version, current)
+			}
+		case "tables":
+			if version < 0 {
+				return nil, fmt.Errorf("%w: schema_version must come before tables", ErrInvalidBackup)
+			}
+			if loaded, err = loadTables(ctx, tx, dec); err != nil {
+				return nil, err
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	if loaded == nil {
+		return nil, fmt.Errorf("%w: no tables", ErrInvalidBackup)
+	}
+	return loaded, nil
+}
+
+func loadTables(ctx context.Context, tx pgx.Tx, dec *json.Decoder) (map[string]int, error) {
+	if _, err := tx.Exec(ctx, "TRUNCATE "+backupTableNames()); err != nil {
+		return nil, pgError(err)
+	}
+	// A user may be loaded before the one it was merged into.
+	if _, err := tx.Exec(ctx, "SET CONSTRAINTS ALL DEFERRED"); err != nil {
+		return nil, pgError(err)
+	}
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	loaded := map[string]int{}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+		}
+		name, _ := key.(string)
+		known := false
+		for _, t := range backupTables {
+			known = known || t.name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: unknown table %q", ErrInvalidBackup, name)
+		}
+		if _, ok := loaded[name]; ok {
+			return nil, fmt.Errorf("%w: table %q given twice", ErrInvalidBackup, name)
+		}
+		n, err := loadRows(ctx, tx, dec, name)
+		if err != nil {
+			return nil, fmt.Errorf("restore %s: %w", name, err)
+		}
+		loaded[name] = n
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	for _, t := range backupTables {
+		if t.serial == "" {
+			continue
+		}
+		sql := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%[1]s', '%[2]s'), COALESCE(max(%[2]s), 0) + 1, false) FROM %[1]s", t.name, t.serial)
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return nil, pgError(err)
+		}
+	}
+	return loaded, nil
+}
+
+// loadRows inserts the array of rows dec is at into table, restoreBatchSize
+// at a time.
+func loadRows(ctx context.Context, tx pgx.Tx, dec *json.Decoder, table string) (int, error) {
+	if err := expectDelim(dec, '['); err != nil {
+		return 0, err
+	}
+	insert := fmt.Sprintf("INSERT INTO %[1]s SELECT * FROM json_populate_recordset(NULL::%[1]s, $1::json)", table)
+	batch := make([]json.RawMessage, 0, restoreBatchSize)
+	n := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rows, _ := json.Marshal(batch)
+		if _, err := tx.Exec(ctx, insert, rows); err != nil {
+			return pgError(err)
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for dec.More() {
+		var row json.RawMessage
+		if err := dec.Decode(&row); err != nil {
+			return n, fmt.Errorf("%w: row %d: %w", ErrInvalidBackup, n+len(batch)+1, err)
+		}
+		if len(row) == 0 || row[0] != '{' {
+			return n, fmt.Errorf("%w: row %d is not an object", ErrInvalidBackup, n+len(batch)+1)
+		}
+		if batch = append(batch, row); len(batch) == restoreBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	return n, expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+	if tok != want {
+		return fmt.Errorf("%w: expected %s at offset %d", ErrInvalidBackup, want, dec.InputOffset())
+	}
+	return nil
+}
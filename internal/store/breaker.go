@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"exam/internal/clock"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is a circuit breaker in front of the database. It learns about
+// failures from the pgx tracer hooks, so every query and pool acquire
+// counts without call sites having to report. After threshold consecutive
+// failures it opens for cooldown; then one request at a time is let through
+// as a probe until one succeeds.
+type Breaker struct {
+	logger    *slog.Logger
+	metrics   *metrics
+	clock     clock.Clock
+	threshold int
+	cooldown  time.Duration
+
+	mu         sync.Mutex
+	state      breakerState
+	failures   int
+	openedAt   time.Time
+	probeSince time.Time
+}
+
+func newBreaker(logger *slog.Logger, m *metrics, clk clock.Clock, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{logger: logger, metrics: m, clock: clk, threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request may use the database, and if not, how
+// long until the next probe.
+func (b *Breaker) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.cooldown - now.Sub(b.openedAt); wait > 0 {
+			return false, wait
+		}
+		b.setState(breakerHalfOpen)
+	case breakerHalfOpen:
+		// A probe that never reached the database (or hangs) must not
+		// wedge the breaker, so probes expire after one cooldown.
+		if !b.probeSince.IsZero() && now.Sub(b.probeSince) < b.cooldown {
+			return false, b.cooldown - now.Sub(b.probeSince)
+		}
+	default:
+		return true, 0
+	}
+	b.probeSince = now
+	return true, 0
+}
+
+// record feeds the outcome of a database call into the breaker.
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isDBUnavailable(err) {
+		if err == nil && b.state != breakerOpen {
+			b.failures = 0
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.openedAt = b.clock.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *Breaker) IsOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerOpen
+}
+
+func (b *Breaker) setState(s breakerState) {
+	if s == b.state {
+		return
+	}
+	b.logger.Warn("database circuit breaker state changed", "from", b.state.String(), "to", s.String(), "consecutive_failures", b.failures)
+	b.state = s
+	b.probeSince = time.Time{}
+	b.metrics.breakerState.Set(float64(s))
+	b.metrics.breakerTransitions.WithLabelValues(s.String()).Inc()
+}
+
+func (b *Breaker) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (b *Breaker) TraceQueryEnd(_ context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	b.record(data.Err)
+}
+
+func (b *Breaker) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	return ctx
+}
+
+func (b *Breaker) TraceAcquireEnd(_ context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	b.record(data.Err)
+}
+
+// isDBUnavailable reports whether err means the database couldn't be
+// reached or couldn't serve, as opposed to rejecting a particular query.
+func isDBUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Connection exceptions, insufficient resources, operator intervention.
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "53") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) || pgconn.Timeout(err) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,438 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/store"
+	"exam/internal/testutil"
+)
+
+// backends are the UserStore implementations the conformance tests hold to
+// the same behaviour. open is called once per backend; each test starts
+// from a Reset store.
+var backends = []struct {
+	name string
+	open func(t *testing.T) store.UserStore
+}{
+	{"memory", func(t *testing.T) store.UserStore { return store.NewMemoryStore(clock.System) }},
+	{"sqlite", openSQLite},
+	{"postgres", func(t *testing.T) store.UserStore { return testutil.Postgres(t).Store }},
+}
+
+func openSQLite(t *testing.T) store.UserStore {
+	cfg := config.Default()
+	cfg.Store = "sqlite"
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "users.db")
+	users, err := store.Open(slog.New(slog.DiscardHandler), cfg, clock.System)
+	if err != nil {
+		t.Fatalf("opening sqlite store: %v", err)
+	}
+	t.Cleanup(func() { users.(io.Closer).Close() })
+	return users
+}
+
+var conformanceTests = []struct {
+	name string
+	run  func(t *testing.T, ctx context.Context, s store.UserStore)
+}{
+	{"CreateAndGet", testCreateAndGet},
+	{"IDsNotReused", testIDsNotReused},
+	{"NotFound", testNotFound},
+	{"DuplicateNames", testDuplicateNames},
+	{"TrashedNames", testTrashedNames},
+	{"ListAndPages", testListAndPages},
+	{"Version", testVersion},
+	{"CreateMany", testCreateMany},
+	{"CreateManyAllOrNothing", testCreateManyAllOrNothing},
+	{"DryRun", testDryRun},
+}
+
+func TestConformance(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			for _, tt := range conformanceTests {
+				t.Run(tt.name, func(t *testing.T) {
+					ctx := t.Context()
+					if _, err := s.(store.Resetter).Reset(ctx); err != nil {
+						t.Fatalf("Reset: %v", err)
+					}
+					tt.run(t, ctx, s)
+				})
+			}
+		})
+	}
+}
+
+func mustCreate(t *testing.T, ctx context.Context, s store.UserStore, name string) store.User {
+	t.Helper()
+	u, err := s.Create(ctx, name, "")
+	if err != nil {
+		t.Fatalf("Create(%q): %v", name, err)
+	}
+	return u
+}
+
+func testCreateAndGet(t *testing.T, ctx context.Context, s store.UserStore) {
+	u, err := s.Create(ctx, "Ada", "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID <= 0 || u.Name != "Ada" || u.Email != "ada@example.com" || u.CreatedAt.IsZero() {
+		t.Errorf("Create = %+v, want an id, the name, the email and created_at", u)
+	}
+	got, err := s.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != u.ID || got.Name != u.Name || got.Email != u.Email || !got.CreatedAt.Equal(u.CreatedAt) {
+		t.Errorf("Get = %+v, want %+v", got, u)
+	}
+
+	noEmail := mustCreate(t, ctx, s, "Grace")
+	if got, _ := s.Get(ctx, noEmail.ID); got.Email != "" {
+		t.Errorf("email of a user created without one = %q", got.Email)
+	}
+
+	if err := s.Update(ctx, u.ID, "Ada Lovelace"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get(ctx, u.ID); got.Name != "Ada Lovelace" {
+		t.Errorf("name after Update = %q, want Ada Lovelace", got.Name)
+	}
+	if err := s.Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func testIDsNotReused(t *testing.T, ctx context.Context, s store.UserStore) {
+	a := mustCreate(t, ctx, s, "a")
+	b := mustCreate(t, ctx, s, "b")
+	if b.ID <= a.ID {
+		t.Errorf("second id %d, want more than %d", b.ID, a.ID)
+	}
+	if err := s.Delete(ctx, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if c := mustCreate(t, ctx, s, "c"); c.ID <= b.ID {
+		t.Errorf("id after deleting %d = %d, want a new one", b.ID, c.ID)
+	}
+}
+
+func testNotFound(t *testing.T, ctx context.Context, s store.UserStore) {
+	u := mustCreate(t, ctx, s, "a")
+	missing := u.ID + 1000
+	if _, err := s.Get(ctx, missing); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get = %v, want ErrNotFound", err)
+	}
+	if err := s.Update(ctx, missing, "b"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Update = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, missing); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func testDuplicateNames(t *testing.T, ctx context.Context, s store.UserStore) {
+	a := mustCreate(t, ctx, s, "Ada")
+	b := mustCreate(t, ctx, s, "Grace")
+	if _, err := s.Create(ctx, "Ada", "other@example.com"); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Create of a taken name = %v, want ErrConflict", err)
+	}
+	if err := s.Update(ctx, b.ID, "Ada"); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Update to a taken name = %v, want ErrConflict", err)
+	}
+	if err := s.Update(ctx, a.ID, "Ada"); err != nil {
+		t.Errorf("Update to the user's own name = %v", err)
+	}
+	if n, _ := s.Count(ctx); n != 2 {
+		t.Errorf("Count = %d after rejected creates, want 2", n)
+	}
+	if err := s.Delete(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(ctx, "Ada", ""); err != nil {
+		t.Errorf("Create of a deleted user's name = %v", err)
+	}
+}
+
+func testTrashedNames(t *testing.T, ctx context.Context, s store.UserStore) {
+	trash, ok := s.(store.Trash)
+	if !ok {
+		t.Skip("store has no recycle bin")
+	}
+	u := mustCreate(t, ctx, s, "Ada")
+	if _, err := trash.TrashUser(ctx, u.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	reused, err := s.Create(ctx, "Ada", "")
+	if err != nil {
+		t.Fatalf("Create of a trashed user's name = %v", err)
+	}
+	if _, err := trash.RestoreUser(ctx, u.ID, time.Time{}, ""); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("RestoreUser under a name now taken = %v, want ErrConflict", err)
+	}
+	if err := s.Delete(ctx, reused.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trash.RestoreUser(ctx, u.ID, time.Time{}, ""); err != nil {
+		t.Errorf("RestoreUser once the name is free = %v", err)
+	}
+}
+
+func testListAndPages(t *testing.T, ctx context.Context, s store.UserStore) {
+	var ids []int
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		ids = append(ids, mustCreate(t, ctx, s, name).ID)
+	}
+	users, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != len(ids) {
+		t.Fatalf("List returned %d users, want %d", len(users), len(ids))
+	}
+	for i, u := range users {
+		if u.ID != ids[i] {
+			t.Errorf("List[%d].ID = %d, want %d", i, u.ID, ids[i])
+		}
+	}
+	if n, _ := s.Count(ctx); n != len(ids) {
+		t.Errorf("Count = %d, want %d", n, len(ids))
+	}
+
+	page, more, err := s.ListPage(ctx, 0, 2)
+	if err != nil || len(page) != 2 || !more || page[0].ID != ids[0] {
+		t.Errorf("first page = %v, more %v, %v; want ids %v and more", page, more, err, ids[:2])
+	}
+	page, more, err = s.ListPage(ctx, ids[1], 3)
+	if err != nil || len(page) != 3 || more || page[0].ID != ids[2] {
+		t.Errorf("last page = %v, more %v, %v; want ids %v and no more", page, more, err, ids[2:])
+	}
+	page, more, err = s.ListPage(ctx, ids[4], 3)
+	if err != nil || len(page) != 0 || more {
+		t.Errorf("page past the end = %v, more %v, %v; want none", page, more, err)
+	}
+}
+
+func testVersion(t *testing.T, ctx context.Context, s store.UserStore) {
+	version := func() store.ListVersion {
+		t.Helper()
+		v, err := s.Version(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	same := func(a, b store.ListVersion) bool { return a.Count == b.Count && a.Modified.Equal(b.Modified) }
+
+	empty := version()
+	if empty.Count != 0 || !empty.Modified.IsZero() {
+		t.Errorf("Version of no users = %+v, want zero", empty)
+	}
+	u := mustCreate(t, ctx, s, "a")
+	created := version()
+	if created.Count != 1 || same(created, empty) {
+		t.Errorf("Version after Create = %+v, want a new one", created)
+	}
+	if err := s.Update(ctx, u.ID, "b"); err != nil {
+		t.Fatal(err)
+	}
+	renamed := version()
+	if same(renamed, created) {
+		t.Errorf("Version after Update = %+v, unchanged", renamed)
+	}
+	if err := s.Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(); v.Count != 0 {
+		t.Errorf("Version after Delete = %+v, want a count of 0", v)
+	}
+}
+
+func testCreateMany(t *testing.T, ctx context.Context, s store.UserStore) {
+	mustCreate(t, ctx, s, "taken")
+	names := []string{"a", "taken", "b", "a"}
+	results, err := s.CreateMany(ctx, names, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("CreateMany returned %d results, want 4", len(results))
+	}
+	for _, i := range []int{1, 3} {
+		if !errors.Is(results[i].Err, store.ErrConflict) {
+			t.Errorf("result %d = %+v, want ErrConflict", i, results[i])
+		}
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil || results[i].ID <= 0 {
+			t.Errorf("result %d = %+v, want an id", i, results[i])
+		}
+	}
+	if results[2].ID <= results[0].ID {
+		t.Errorf("ids %d, %d are not in the order of the names", results[0].ID, results[2].ID)
+	}
+	for _, i := range []int{0, 2} {
+		if u, err := s.Get(ctx, results[i].ID); err != nil || u.Name != names[i] {
+			t.Errorf("Get(%d) = %+v, %v", results[i].ID, u, err)
+		}
+	}
+	if n, _ := s.Count(ctx); n != 3 {
+		t.Errorf("Count = %d, want 3", n)
+	}
+}
+
+func testCreateManyAllOrNothing(t *testing.T, ctx context.Context, s store.UserStore) {
+	mustCreate(t, ctx, s, "taken")
+	results, err := s.CreateMany(ctx, []string{"a", "taken", "b"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || !errors.Is(results[1].Err, store.ErrConflict) {
+		t.Errorf("results = %+v, want the second rejected", results)
+	}
+	if n, _ := s.Count(ctx); n != 1 {
+		t.Errorf("Count = %d, want 1: nothing kept", n)
+	}
+
+	if _, err := s.CreateMany(ctx, []string{"a", "b"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Count(ctx); n != 3 {
+		t.Errorf("Count = %d, want 3", n)
+	}
+}
+
+func testDryRun(t *testing.T, ctx context.Context, s store.UserStore) {
+	mustCreate(t, ctx, s, "taken")
+	dry := store.DryRun(ctx)
+
+	u, err := s.Create(dry, "new", "new@example.com")
+	if err != nil || u.Name != "new" || u.Email != "new@example.com" {
+		t.Errorf("dry run Create = %+v, %v; want the user it would add", u, err)
+	}
+	if _, err := s.Create(dry, "taken", ""); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("dry run Create of a taken name = %v, want ErrConflict", err)
+	}
+	results, err := s.CreateMany(dry, []string{"x", "taken"}, false)
+	if err != nil || len(results) != 2 || results[0].Err != nil || !errors.Is(results[1].Err, store.ErrConflict) {
+		t.Errorf("dry run CreateMany = %+v, %v; want the second rejected", results, err)
+	}
+	if n, _ := s.Count(ctx); n != 1 {
+		t.Errorf("Count after dry runs = %d, want 1", n)
+	}
+}
+
+// SQLite has no recycle bin, but a row with deleted_at set frees its name
+// as on Postgres. A database from before the name index opens with its
+// duplicates untouched and logged, keeps new ones out, and gets the index
+// once they are gone.
+func TestSQLiteNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, email TEXT, created_at DATETIME NOT NULL)",
+		"INSERT INTO users (name, created_at) VALUES ('Ada', '2024-01-01'), ('Grace', '2024-01-01'), ('Ada', '2024-01-01'), ('Ada', '2024-01-01')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.Store = "sqlite"
+	cfg.SQLitePath = path
+	var logs bytes.Buffer
+	open := func() store.UserStore {
+		t.Helper()
+		s, err := store.Open(slog.New(slog.NewTextHandler(&logs, nil)), cfg, clock.System)
+		if err != nil {
+			t.Fatalf("opening a database with duplicate names: %v", err)
+		}
+		return s
+	}
+	indexed := func() bool {
+		t.Helper()
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = 'users_name_live'").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n > 0
+	}
+
+	s := open()
+	ctx := t.Context()
+	users, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, u := range users {
+		names = append(names, u.Name)
+	}
+	if want := []string{"Ada", "Grace", "Ada", "Ada"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want them as they were", names)
+	}
+	if !strings.Contains(logs.String(), `\"Ada\": [1, 3, 4]`) {
+		t.Errorf("duplicates not logged:\n%s", logs.String())
+	}
+	if indexed() {
+		t.Error("users_name_live built over duplicates")
+	}
+	if _, err := s.Create(ctx, "Ada", ""); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Create of a shared name = %v, want ErrConflict", err)
+	}
+	if err := s.Update(ctx, 2, "Ada"); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("renaming to a shared name = %v, want ErrConflict", err)
+	}
+	if err := s.Update(ctx, 4, "Ada Byron"); err != nil {
+		t.Errorf("renaming a duplicate away = %v", err)
+	}
+
+	if _, err := db.Exec("UPDATE users SET deleted_at = '2024-02-01' WHERE name = 'Grace'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(ctx, "Grace", ""); err != nil {
+		t.Errorf("Create of a deleted user's name = %v", err)
+	}
+
+	// With the last duplicate gone, the next open builds the index.
+	if err := s.Delete(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	s.(io.Closer).Close()
+	s = open()
+	defer s.(io.Closer).Close()
+	if !indexed() {
+		t.Error("users_name_live not built once the duplicates were gone")
+	}
+	if _, err := s.Create(ctx, "Ada", ""); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Create of a taken name after indexing = %v, want ErrConflict", err)
+	}
+}
@@ -0,0 +1,409 @@
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"exam/internal/config"
+)
+
+const (
+	// lazyConnectRetryDelay separates the background connector's rounds of
+	// DB_CONNECT_TIMEOUT-long attempts.
+	lazyConnectRetryDelay = 5 * time.Second
+
+	dbConnectBaseDelay = 250 * time.Millisecond
+	dbConnectMaxDelay  = 5 * time.Second
+)
+
+// ErrDBConnecting is returned by DB while the pool isn't up yet.
+var ErrDBConnecting = errors.New("database connection not established yet")
+
+// InitError is a failed database startup. Stage tells a bad configuration
+// ("config") from a server that can't be reached ("connect") or doesn't
+// answer ("ping"), and from a schema that couldn't be set up ("schema").
+type InitError struct {
+	Stage string
+	Err   error
+}
+
+func (e *InitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
+// DB holds the pool, which is nil until the first connection and schema
+// setup have succeeded. With DB_LAZY_CONNECT that happens in the background
+// while the server already answers; the web package keeps requests that
+// need the database away until then.
+type DB struct {
+	pool atomic.Pointer[pgxpool.Pool]
+	// replica serves ReadQuery and ReadQueryRow when set.
+	replica atomic.Pointer[pgxpool.Pool]
+	// schemaCurrent is set once the schema is known to be up to date;
+	// schemaVersion is the version last read from schema_migrations.
+	schemaCurrent atomic.Bool
+	schemaVersion atomic.Int64
+	// metrics observes Ping, which bypasses the query tracers.
+	metrics *metrics
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// openDB connects to the primary, in the background with DB_LAZY_CONNECT,
+// and opens the replica if there is one.
+func openDB(logger *slog.Logger, cfg config.DBConfig, tracers []pgx.QueryTracer) (*DB, error) {
+	var db *DB
+	if cfg.LazyConnect {
+		logger.Info("connecting to database in the background")
+		db = connectDBInBackground(logger, cfg, multitracer.New(tracers...))
+	} else {
+		pool, err := initDB(context.Background(), logger, cfg, multitracer.New(tracers...))
+		if err != nil {
+			return nil, err
+		}
+		db = connectedDB(pool)
+	}
+	if cfg.ReplicaURL != "" {
+		// tracers[0] is the breaker, which guards the primary; replica
+		// failures fall back to the primary instead of tripping it.
+		replica, err := openReplica(cfg.Replica(), multitracer.New(tracers[1:]...))
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		db.replica.Store(replica)
+		logger.Info("routing reads to replica", "host", replica.Config().ConnConfig.Host)
+	}
+	return db, nil
+}
+
+// initDB connects to Postgres and ensures the schema exists. A non-nil tracer
+// is installed on every pooled connection.
+func initDB(ctx context.Context, logger *slog.Logger, cfg config.DBConfig, tracer pgx.QueryTracer) (*pgxpool.Pool, error) {
+	poolCfg, err := poolConfig(cfg)
+	if err != nil {
+		return nil, &InitError{Stage: "config", Err: fmt.Errorf("parsing database config: %w", err)}
+	}
+	if tracer != nil {
+		poolCfg.ConnConfig.Tracer = tracer
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
+	if err != nil {
+		return nil, &InitError{Stage: "config", Err: fmt.Errorf("creating database pool: %w", err)}
+	}
+	conn, err := connectDB(ctx, logger, pool, cfg)
+	if err != nil {
+		pool.Close()
+		return nil, &InitError{Stage: "connect", Err: err}
+	}
+	if err := pingDB(ctx, conn, cfg); err != nil {
+		conn.Release()
+		pool.Close()
+		return nil, &InitError{Stage: "ping", Err: fmt.Errorf("pinging database at %s:%d: %w", poolCfg.ConnConfig.Host, poolCfg.ConnConfig.Port, err)}
+	}
+	logger.Info("connected to database", append([]any{"host", poolCfg.ConnConfig.Host, "port", poolCfg.ConnConfig.Port}, connTLSAttrs(conn.Conn().PgConn().Conn())...)...)
+	conn.Release()
+	logger.Info("database pool",
+		"max_conns", poolCfg.MaxConns,
+		"min_conns", poolCfg.MinConns,
+		"max_conn_lifetime", poolCfg.MaxConnLifetime,
+		"max_conn_idle_time", poolCfg.MaxConnIdleTime,
+		"health_check_period", poolCfg.HealthCheckPeriod)
+
+	if err := setupSchema(ctx, logger, pool, cfg); err != nil {
+		pool.Close()
+		return nil, &InitError{Stage: "schema", Err: fmt.Errorf("setting up database schema: %w", err)}
+	}
+	return pool, nil
+}
+
+// initStage returns the Stage of an InitError in err's chain, or "".
+func initStage(err error) string {
+	var initErr *InitError
+	if errors.As(err, &initErr) {
+		return initErr.Stage
+	}
+	return ""
+}
+
+// pingDB checks that the server behind a fresh connection answers queries,
+// within one DB_ATTEMPT_TIMEOUT.
+func pingDB(ctx context.Context, conn *pgxpool.Conn, cfg config.DBConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+	defer cancel()
+	return conn.Ping(ctx)
+}
+
+// connectDB waits for the first connection, retrying with jittered
+// exponential backoff for up to DB_CONNECT_TIMEOUT, so that the app doesn't
+// crash-loop when it starts before Postgres does.
+func connectDB(ctx context.Context, logger *slog.Logger, pool *pgxpool.Pool, cfg config.DBConfig) (*pgxpool.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+	host, port := pool.Config().ConnConfig.Host, pool.Config().ConnConfig.Port
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, cfg.AttemptTimeout)
+		conn, err := pool.Acquire(attemptCtx)
+		cancelAttempt()
+		if err == nil {
+			return conn, nil
+		}
+
+		delay := min(dbConnectBaseDelay<<min(attempt-1, 10), dbConnectMaxDelay)
+		delay = delay/2 + rand.N(delay/2)
+		logger.Warn("database connection failed", "host", host, "port", port, "attempt", attempt, "retry_in", delay.String(), "error", err)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, fmt.Errorf("connecting to database at %s:%d: gave up after %d attempts in %s: %w", host, port, attempt, cfg.ConnectTimeout, err)
+		case <-t.C:
+		}
+	}
+}
+
+// connTLSAttrs describes the TLS state of a database connection for the log.
+func connTLSAttrs(c net.Conn) []any {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return []any{"tls", false}
+	}
+	state := tc.ConnectionState()
+	return []any{"tls", true, "tls_version", tls.VersionName(state.Version), "tls_cipher", tls.CipherSuiteName(state.CipherSuite)}
+}
+
+// connectedDB wraps a pool that is already connected.
+func connectedDB(pool *pgxpool.Pool) *DB {
+	c := &DB{cancel: func() {}, done: make(chan struct{})}
+	c.pool.Store(pool)
+	close(c.done)
+	return c
+}
+
+// connectDBInBackground keeps calling initDB until it succeeds, Close is
+// called or the configuration turns out to be invalid.
+func connectDBInBackground(logger *slog.Logger, cfg config.DBConfig, tracer pgx.QueryTracer) *DB {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &DB{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		for {
+			pool, err := initDB(ctx, logger, cfg, tracer)
+			if err == nil {
+				c.pool.Store(pool)
+				logger.Info("database ready")
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			// Retrying can't fix the configuration.
+			stage := initStage(err)
+			if stage == "config" {
+				logger.Error("invalid database configuration, giving up", "error", err)
+				return
+			}
+			logger.Error("database still unavailable, will keep trying", "stage", stage, "retry_in", lazyConnectRetryDelay.String(), "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(lazyConnectRetryDelay):
+			}
+		}
+	}()
+	return c
+}
+
+// Ready reports whether the pool is up.
+func (c *DB) Ready() bool {
+	return c.pool.Load() != nil
+}
+
+// Pool returns the pool, or nil while still connecting or, on a nil
+// DB, without a database at all.
+func (c *DB) Pool() *pgxpool.Pool {
+	if c == nil {
+		return nil
+	}
+	return c.pool.Load()
+}
+
+func (c *DB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	pool := c.pool.Load()
+	if pool == nil {
+		return nil, ErrDBConnecting
+	}
+	return pool.Query(ctx, sql, args...)
+}
+
+func (c *DB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	pool := c.pool.Load()
+	if pool == nil {
+		return errRow{ErrDBConnecting}
+	}
+	return pool.QueryRow(ctx, sql, args...)
+}
+
+func (c *DB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	pool := c.pool.Load()
+	if pool == nil {
+		return pgconn.CommandTag{}, ErrDBConnecting
+	}
+	return pool.Exec(ctx, sql, args...)
+}
+
+func (c *DB) Begin(ctx context.Context) (pgx.Tx, error) {
+	pool := c.pool.Load()
+	if pool == nil {
+		return nil, ErrDBConnecting
+	}
+	return pool.Begin(ctx)
+}
+
+func (c *DB) Ping(ctx context.Context) error {
+	pool := c.pool.Load()
+	if pool == nil {
+		return ErrDBConnecting
+	}
+	start := time.Now()
+	err := pool.Ping(ctx)
+	if c.metrics != nil {
+		c.metrics.observeQuery("ping", time.Since(start), 0, err)
+	}
+	return err
+}
+
+// SchemaVersion is the version last read from schema_migrations.
+func (c *DB) SchemaVersion() int64 {
+	return c.schemaVersion.Load()
+}
+
+// ReadQuery runs a read-only query on the replica, falling back to the
+// primary when the replica can't be reached.
+func (c *DB) ReadQuery(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if replica := c.replica.Load(); replica != nil {
+		rows, err := replica.Query(ctx, sql, args...)
+		if !isDBUnavailable(err) {
+			return rows, err
+		}
+	}
+	return c.Query(ctx, sql, args...)
+}
+
+// ReadQueryRow is ReadQuery for a single row. Its errors only show at Scan,
+// which is where the fallback happens.
+func (c *DB) ReadQueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	replica := c.replica.Load()
+	if replica == nil {
+		return c.QueryRow(ctx, sql, args...)
+	}
+	return fallbackRow{row: replica.QueryRow(ctx, sql, args...), fallback: func() pgx.Row {
+		return c.QueryRow(ctx, sql, args...)
+	}}
+}
+
+type fallbackRow struct {
+	row      pgx.Row
+	fallback func() pgx.Row
+}
+
+func (r fallbackRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	if isDBUnavailable(err) {
+		return r.fallback().Scan(dest...)
+	}
+	return err
+}
+
+// Close stops a background connector and closes the pools.
+func (c *DB) Close() {
+	c.cancel()
+	<-c.done
+	if pool := c.pool.Load(); pool != nil {
+		pool.Close()
+	}
+	if replica := c.replica.Load(); replica != nil {
+		replica.Close()
+	}
+}
+
+// openReplica creates the replica pool. It connects on first use, so an
+// unreachable replica neither delays nor fails startup.
+func openReplica(cfg config.DBConfig, tracer pgx.QueryTracer) (*pgxpool.Pool, error) {
+	poolCfg, err := poolConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parsing replica config: %w", err)
+	}
+	poolCfg.ConnConfig.Tracer = tracer
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating replica pool: %w", err)
+	}
+	return pool, nil
+}
+
+// IsStatementTimeout reports whether Postgres cancelled a statement because
+// it ran past statement_timeout. The same query_canceled code also answers
+// the cancel pgx sends when the request's context ends, for example when the
+// client disconnects; only the message tells the two apart.
+func IsStatementTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "57014" && // query_canceled
+		strings.Contains(pgErr.Message, "statement timeout")
+}
+
+// withStatementTimeout runs fn in a transaction whose statements may each
+// run for up to d instead of DB_STATEMENT_TIMEOUT, for the few queries that
+// legitimately need longer, such as exports. Unlike WithTx it runs fn only
+// once, so fn may stream what it reads.
+func (s *Postgres) withStatementTimeout(ctx context.Context, d time.Duration, fn func(pgx.Tx) error) error {
+	return s.runTx(ctx, func(tx pgx.Tx) error {
+		// SET takes no bind parameters; d is formatted as an integer.
+		if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", d.Milliseconds())); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// FailureReason tells apart the failures that need different fixes: a slow
+// or unreachable host, nothing listening, or bad credentials.
+func FailureReason(err error) string {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "28"):
+		// invalid_authorization_specification, invalid_password
+		return "auth"
+	default:
+		return "error"
+	}
+}
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type ctxKey int
+
+const (
+	queryStartKey ctxKey = iota
+	queryNameKey
+	queryMetricsKey
+)
+
+// withQueryName tags the queries run with ctx with a logical name for the
+// db_query_* metrics. Untagged queries are labeled by their leading SQL
+// keyword.
+func withQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey, name)
+}
+
+func queryNameFrom(ctx context.Context, sql string) string {
+	if name, ok := ctx.Value(queryNameKey).(string); ok {
+		return name
+	}
+	return strings.ToLower(statementName(sql))
+}
+
+// read and readRow run a read-only statement tagged with a logical name on
+// the replica, if there is one.
+func (s *Postgres) read(ctx context.Context, name, sql string, args ...any) (pgx.Rows, error) {
+	return s.db.ReadQuery(withQueryName(ctx, name), sql, args...)
+}
+
+func (s *Postgres) readRow(ctx context.Context, name, sql string, args ...any) pgx.Row {
+	return s.db.ReadQueryRow(withQueryName(ctx, name), sql, args...)
+}
+
+// query, queryRow and exec run a statement tagged with a logical name on the
+// primary.
+func (s *Postgres) query(ctx context.Context, name, sql string, args ...any) (pgx.Rows, error) {
+	return s.db.Query(withQueryName(ctx, name), sql, args...)
+}
+
+func (s *Postgres) queryRow(ctx context.Context, name, sql string, args ...any) pgx.Row {
+	return s.db.QueryRow(withQueryName(ctx, name), sql, args...)
+}
+
+func (s *Postgres) exec(ctx context.Context, name, sql string, args ...any) (pgconn.CommandTag, error) {
+	return s.db.Exec(withQueryName(ctx, name), sql, args...)
+}
+
+// dbMetricsTracer is a pgx.QueryTracer feeding the db_query_* metrics.
+type dbMetricsTracer struct {
+	metrics *metrics
+}
+
+type dbMetricsStart struct {
+	at   time.Time
+	name string
+}
+
+func (t *dbMetricsTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryMetricsKey, dbMetricsStart{at: time.Now(), name: queryNameFrom(ctx, data.SQL)})
+}
+
+func (t *dbMetricsTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryMetricsKey).(dbMetricsStart)
+	if !ok {
+		return
+	}
+	t.metrics.observeQuery(start.name, time.Since(start.at), data.CommandTag.RowsAffected(), data.Err)
+}
+
+func (m *metrics) observeQuery(name string, elapsed time.Duration, rows int64, err error) {
+	m.dbQueryDuration.WithLabelValues(name).Observe(elapsed.Seconds())
+	if rows > 0 {
+		m.dbQueryRows.WithLabelValues(name).Add(float64(rows))
+	}
+	if err != nil {
+		m.dbQueryErrors.WithLabelValues(name, pgErrorClass(err)).Inc()
+	}
+}
+
+// pgErrorClass returns the two-character SQLSTATE class of err ("23" for
+// integrity violations, "57" for operator intervention, ...), or a word for
+// failures that never reached Postgres.
+func pgErrorClass(err error) string {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr) && len(pgErr.Code) >= 2:
+		return pgErr.Code[:2]
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err):
+		return "timeout"
+	case isDBUnavailable(err):
+		return "connection"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,18 @@
+package store
+
+import "context"
+
+type dryRunKey struct{}
+
+// DryRun returns a context under which Create and CreateMany check and
+// insert exactly as usual, the database's constraints included, and then
+// roll back, so they answer as a real create would without keeping
+// anything. Postgres still uses up the ids it draws.
+func DryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func isDryRun(ctx context.Context) bool {
+	dry, _ := ctx.Value(dryRunKey{}).(bool)
+	return dry
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// sweep removes one kind of expired row. Its query deletes at most $2 rows
+// that expired before $1 and is run until a batch comes back short, so no
+// single statement locks a large range.
+type sweep struct {
+	name string
+	// retained rows are kept for the retention period after they expire;
+	// others go as soon as they do.
+	retained bool
+	query    string
+}
+
+// sweeps is every kind of row the janitor removes.
+var sweeps = []sweep{
+	{name: "rate_limits", query: "DELETE FROM rate_limits WHERE key IN (SELECT key FROM rate_limits WHERE expires_at < $1 LIMIT $2)"},
+	// Past hours' API usage is kept for reporting until the retention ends.
+	{name: "api_token_usage", retained: true, query: "DELETE FROM api_token_usage WHERE (token_id, window_start) IN (SELECT token_id, window_start FROM api_token_usage WHERE window_start < $1 LIMIT $2)"},
+	// The recycle bin keeps deleted users restorable until the retention
+	// ends. Users merged into another stay, so their ids keep resolving.
+	{name: "trashed_users", retained: true, query: "DELETE FROM users WHERE id IN (SELECT id FROM users WHERE deleted_at < $1 AND merged_into IS NULL LIMIT $2)"},
+}
+
+// Sweeper is implemented by stores with rows for the janitor to expire.
+type Sweeper interface {
+	// Sweep deletes rows that expired before now, or before now minus
+	// retention for the kinds that are retained, limit at a time. It
+	// returns how many rows of each kind it removed.
+	Sweep(ctx context.Context, now time.Time, retention time.Duration, limit int) (map[string]int64, error)
+}
+
+func (s *Postgres) Sweep(ctx context.Context, now time.Time, retention time.Duration, limit int) (map[string]int64, error) {
+	removed := make(map[string]int64, len(sweeps))
+	for _, sw := range sweeps {
+		cutoff := now
+		if sw.retained {
+			cutoff = now.Add(-retention)
+		}
+		for {
+			tag, err := s.exec(ctx, "sweep_"+sw.name, sw.query, cutoff, limit)
+			if err != nil {
+				return removed, fmt.Errorf("sweeping %s: %w", sw.name, pgError(err))
+			}
+			removed[sw.name] += tag.RowsAffected()
+			if tag.RowsAffected() < int64(limit) {
+				break
+			}
+		}
+	}
+	return removed, nil
+}
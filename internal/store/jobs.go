@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNoJobs is returned by ClaimJob when no job is due.
+var ErrNoJobs = errors.New("no jobs due")
+
+// Job is one unit of background work. Payload is the handler's own JSON.
+type Job struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	RunAt     time.Time       `json:"run_at"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	DeadAt    *time.Time      `json:"dead_at,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// JobQueue is implemented by stores that can keep background jobs across
+// restarts.
+type JobQueue interface {
+	// Enqueue adds a job of type typ that runs no earlier than runAt.
+	Enqueue(ctx context.Context, typ string, payload any, runAt time.Time) (int64, error)
+	// ClaimJob takes the oldest due job and hides it from other workers
+	// for lease, after which a job its worker never finished runs again.
+	// It returns ErrNoJobs when nothing is due.
+	ClaimJob(ctx context.Context, lease time.Duration) (Job, error)
+	// CompleteJob removes a finished job.
+	CompleteJob(ctx context.Context, id int64) error
+	// RetryJob records a failed attempt and schedules the next at retryAt.
+	RetryJob(ctx context.Context, id int64, retryAt time.Time, reason string) error
+	// BuryJob records a failed attempt and stops retrying the job.
+	BuryJob(ctx context.Context, id int64, reason string) error
+	// DeadJobs lists up to limit buried jobs, most recent first.
+	DeadJobs(ctx context.Context, limit int) ([]Job, error)
+}
+
+const jobColumns = "id, type, payload, run_at, attempts, COALESCE(last_error, ''), dead_at, created_at"
+
+func (s *Postgres) Enqueue(ctx context.Context, typ string, payload any, runAt time.Time) (int64, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("encoding %s payload: %w", typ, err)
+	}
+	var id int64
+	err = s.queryRow(ctx, "enqueue_job", "INSERT INTO jobs (type, payload, run_at) VALUES ($1, $2, $3) RETURNING id", typ, body, runAt).Scan(&id)
+	return id, pgError(err)
+}
+
+// ClaimJob pushes the claimed job's run_at out by lease and counts the
+// attempt up front, so a worker that dies mid-job still uses one up. SKIP
+// LOCKED lets concurrent workers each take a different job.
+func (s *Postgres) ClaimJob(ctx context.Context, lease time.Duration) (Job, error) {
+	row := s.queryRow(ctx, "claim_job", `UPDATE jobs SET attempts = attempts + 1, run_at = now() + make_interval(secs => $1)
+		WHERE id = (
+			SELECT id FROM jobs WHERE dead_at IS NULL AND run_at <= now()
+			ORDER BY run_at LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns, lease.Seconds())
+	j, err := scanJob(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, ErrNoJobs
+	}
+	return j, pgError(err)
+}
+
+func (s *Postgres) CompleteJob(ctx context.Context, id int64) error {
+	_, err := s.exec(ctx, "complete_job", "DELETE FROM jobs WHERE id = $1", id)
+	return pgError(err)
+}
+
+func (s *Postgres) RetryJob(ctx context.Context, id int64, retryAt time.Time, reason string) error {
+	_, err := s.exec(ctx, "retry_job", "UPDATE jobs SET run_at = $2, last_error = $3 WHERE id = $1", id, retryAt, reason)
+	return pgError(err)
+}
+
+func (s *Postgres) BuryJob(ctx context.Context, id int64, reason string) error {
+	_, err := s.exec(ctx, "bury_job", "UPDATE jobs SET dead_at = now(), last_error = $2 WHERE id = $1", id, reason)
+	return pgError(err)
+}
+
+func (s *Postgres) DeadJobs(ctx context.Context, limit int) ([]Job, error) {
+	rows, err := s.query(ctx, "dead_jobs", "SELECT "+jobColumns+" FROM jobs WHERE dead_at IS NOT NULL ORDER BY dead_at DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	jobs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Job, error) { return scanJob(row) })
+	return jobs, pgError(err)
+}
+
+func scanJob(row pgx.Row) (Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.Type, &j.Payload, &j.RunAt, &j.Attempts, &j.LastError, &j.DeadAt, &j.CreatedAt)
+	return j, err
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrLocked is returned by RunExclusive when another instance holds the
+// lock.
+var ErrLocked = errors.New("held by another instance")
+
+// Exclusive is implemented by stores shared between instances, for work
+// that only one of them should do at a time.
+type Exclusive interface {
+	// RunExclusive runs fn while holding the lock called name, or returns
+	// ErrLocked straight away when another instance holds it.
+	RunExclusive(ctx context.Context, name string, fn func(ctx context.Context) error) error
+}
+
+// RunExclusive holds a session advisory lock keyed by name's hash on a
+// connection of its own for as long as fn runs.
+func (s *Postgres) RunExclusive(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	pool := s.db.Pool()
+	if pool == nil {
+		return ErrDBConnecting
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return pgError(err)
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", name).Scan(&locked); err != nil {
+		return fmt.Errorf("taking %s lock: %w", name, pgError(err))
+	}
+	if !locked {
+		return ErrLocked
+	}
+	defer func() {
+		// As in migrate, a cancelled ctx must not leave the lock held on a
+		// pooled connection.
+		if _, err := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtext($1))", name); err != nil {
+			s.logger.Error("failed to release lock", "lock", name, "error", err)
+			conn.Conn().Close(context.WithoutCancel(ctx))
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"exam/internal/clock"
+)
+
+// memoryStore is a UserStore held in process memory, for demos without
+// Postgres (STORE=memory). Everything is lost on restart. It mirrors the
+// users table: ids count up from 1 and are never reused, and no two users
+// share a name.
+type memoryStore struct {
+	clock  clock.Clock
+	mu     sync.RWMutex
+	users  []User // ordered by id
+	nextID int
+	// renamed is when a user was last renamed, standing in for the
+	// updated_at column.
+	renamed time.Time
+}
+
+// NewMemoryStore returns an empty in-memory UserStore whose users are
+// created at clk's time, for tests and embedding without a database.
+func NewMemoryStore(clk clock.Clock) UserStore {
+	return newMemoryStore(clk)
+}
+
+func newMemoryStore(clk clock.Clock) *memoryStore {
+	return &memoryStore{clock: clk, nextID: 1}
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]User{}, s.users...), nil
+}
+
+func (s *memoryStore) ListPage(ctx context.Context, after, limit int) ([]User, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, _ := s.index(after + 1)
+	rest := s.users[i:]
+	if len(rest) > limit {
+		return slices.Clone(rest[:limit]), true, nil
+	}
+	return append([]User{}, rest...), false, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id int) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i, ok := s.index(id); ok {
+		return s.users[i], nil
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memoryStore) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users), nil
+}
+
+func (s *memoryStore) Version(ctx context.Context) (ListVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v := ListVersion{Count: len(s.users)}
+	if len(s.users) > 0 {
+		v.Modified = s.renamed
+		for _, u := range s.users {
+			if u.CreatedAt.After(v.Modified) {
+				v.Modified = u.CreatedAt
+			}
+		}
+	}
+	return v, nil
+}
+
+func (s *memoryStore) DailySignups(ctx context.Context, since time.Time) ([]DayCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	since = utcDay(since)
+	var created []time.Time
+	for _, u := range s.users {
+		if !u.CreatedAt.Before(since) {
+			created = append(created, u.CreatedAt)
+		}
+	}
+	return countDays(created), nil
+}
+
+// Under DryRun the memory store's creates check names as usual and then
+// only say what they would have added.
+func (s *memoryStore) Create(ctx context.Context, name, email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.taken(name, 0) {
+		return User{}, nameTaken(name)
+	}
+	if isDryRun(ctx) {
+		return User{Name: name, Email: email, CreatedAt: s.clock.Now()}, nil
+	}
+	return s.insert(name, email), nil
+}
+
+// CreateMany rejects a name taken by an existing user or earlier in names,
+// as the unique index does in the other stores.
+func (s *memoryStore) CreateMany(ctx context.Context, names []string, allOrNothing bool) ([]CreateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]CreateResult, len(names))
+	seen := make(map[string]bool, len(names))
+	rejected := false
+	for i, name := range names {
+		if seen[name] || s.taken(name, 0) {
+			results[i].Err = nameTaken(name)
+			rejected = true
+		}
+		seen[name] = true
+	}
+	if rejected && allOrNothing || isDryRun(ctx) {
+		return results, nil
+	}
+	for i, name := range names {
+		if results[i].Err == nil {
+			results[i].ID = s.insert(name, "").ID
+		}
+	}
+	return results, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, id int, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.index(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if s.taken(name, id) {
+		return nameTaken(name)
+	}
+	s.users[i].Name = name
+	s.renamed = s.clock.Now()
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.index(id)
+	if !ok {
+		return ErrNotFound
+	}
+	s.users = slices.Delete(s.users, i, i+1)
+	return nil
+}
+
+// insert must be called with mu held.
+func (s *memoryStore) insert(name, email string) User {
+	u := User{ID: s.nextID, Name: name, Email: email, CreatedAt: s.clock.Now()}
+	s.nextID++
+	s.users = append(s.users, u)
+	return u
+}
+
+// taken reports whether a user other than id is named name. It must be
+// called with mu held.
+func (s *memoryStore) taken(name string, id int) bool {
+	return slices.ContainsFunc(s.users, func(u User) bool { return u.Name == name && u.ID != id })
+}
+
+func nameTaken(name string) error {
+	return fmt.Errorf("%w: a user named %q exists", ErrConflict, name)
+}
+
+// index finds id, or where it would go, in s.users. It must be called with
+// mu held.
+func (s *memoryStore) index(id int) (int, bool) {
+	return slices.BinarySearchFunc(s.users, id, func(u User, id int) int { return u.ID - id })
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Merger is implemented by stores that can merge duplicate users.
+type Merger interface {
+	// Merge folds the users mergeIDs into keepID in one transaction and
+	// returns the kept user and the ids merged, sorted and without repeats.
+	// The merged users are soft-deleted with merged_into set, so Get of
+	// their ids returns ErrMerged, and users merged into them before now
+	// lead to keepID. The merge is recorded in the user_merges audit log as
+	// done by by. A keepID that doesn't exist is ErrNotFound; a deleted one,
+	// or mergeIDs that include keepID or anything but live users, is an
+	// ErrValidation.
+	//
+	// No table refers to users yet, so there are no rows to repoint; tags,
+	// group memberships and the like must be moved to keepID here, in the
+	// same transaction, once they exist.
+	Merge(ctx context.Context, keepID int, mergeIDs []int, by string) (User, []int, error)
+}
+
+func (s *Postgres) Merge(ctx context.Context, keepID int, mergeIDs []int, by string) (User, []int, error) {
+	mergeIDs = slices.Compact(slices.Sorted(slices.Values(mergeIDs)))
+	switch {
+	case len(mergeIDs) == 0:
+		return User{}, nil, &ErrValidation{Field: "merge_ids", Reason: "must not be empty"}
+	case slices.Contains(mergeIDs, keepID):
+		return User{}, nil, &ErrValidation{Field: "merge_ids", Reason: "must not include the user merged into"}
+	}
+
+	now := s.clock.Now()
+	var keep User
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		// Locking the kept user holds off its deletion, or its own merge,
+		// until this one is done.
+		var deleted bool
+		err := tx.QueryRow(ctx, "SELECT id, name, COALESCE(email, ''), created_at, deleted_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE", keepID).
+			Scan(&keep.ID, &keep.Name, &keep.Email, &keep.CreatedAt, &deleted)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrNotFound
+		case err != nil:
+			return err
+		case deleted:
+			return &ErrValidation{Reason: "Can't merge into a deleted user."}
+		}
+		tag, err := tx.Exec(ctx, "UPDATE users SET deleted_at = $3, merged_into = $1 WHERE id = ANY($2) AND deleted_at IS NULL", keepID, mergeIDs, now)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() != int64(len(mergeIDs)) {
+			return &ErrValidation{Field: "merge_ids", Reason: "must all be users that exist and aren't deleted"}
+		}
+		if _, err := tx.Exec(ctx, "UPDATE users SET merged_into = $1 WHERE merged_into = ANY($2)", keepID, mergeIDs); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO user_merges (kept_id, merged_ids, merged_by, merged_at) VALUES ($1, $2, NULLIF($3, ''), $4)", keepID, mergeIDs, by, now)
+		return err
+	})
+	if err != nil {
+		return User{}, nil, pgError(err)
+	}
+	return keep, mergeIDs, nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics holds the database collectors fed by the Postgres store's query
+// tracers. The web package registers them next to its own; see
+// Postgres.Collectors.
+type metrics struct {
+	breakerState       prometheus.Gauge
+	breakerTransitions *prometheus.CounterVec
+	dbRetries          *prometheus.CounterVec
+	dbQueryDuration    *prometheus.HistogramVec
+	dbQueryErrors      *prometheus.CounterVec
+	dbQueryRows        *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		breakerState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "db_circuit_state",
+			Help: "Database circuit breaker state: 0 closed, 1 half-open, 2 open.",
+		}),
+		breakerTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_circuit_transitions_total",
+			Help: "Database circuit breaker state changes, by new state.",
+		}, []string{"state"}),
+		dbRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_retries_total",
+			Help: "Read queries retried after a transient database error, by operation.",
+		}, []string{"op"}),
+		dbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Database query latency, by logical query name.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"query"}),
+		dbQueryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "Failed database queries, by logical query name and SQLSTATE class.",
+		}, []string{"query", "class"}),
+		dbQueryRows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_rows_total",
+			Help: "Rows returned or affected by database queries, by logical query name.",
+		}, []string{"query"}),
+	}
+}
+
+func (m *metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.breakerState, m.breakerTransitions, m.dbRetries, m.dbQueryDuration, m.dbQueryErrors, m.dbQueryRows}
+}
+
+// poolCollectors export the pool's key numbers, read at scrape time. They
+// are zero while a lazy connect is still running.
+func poolCollectors(db *DB) []prometheus.Collector {
+	stat := func(f func(*pgxpool.Stat) float64) func() float64 {
+		return func() float64 {
+			if pool := db.Pool(); pool != nil {
+				return f(pool.Stat())
+			}
+			return 0
+		}
+	}
+	return []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_pool_acquired_conns",
+			Help: "Connections currently checked out of the pool.",
+		}, stat(func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_pool_idle_conns",
+			Help: "Idle connections in the pool.",
+		}, stat(func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_pool_max_conns",
+			Help: "Configured pool size limit.",
+		}, stat(func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "db_pool_acquire_wait_seconds_total",
+			Help: "Total time spent acquiring connections from the pool.",
+		}, stat(func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "db_pool_empty_acquires_total",
+			Help: "Acquires that had to wait because the pool had no idle connection.",
+		}, stat(func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) })),
+	}
+}
@@ -0,0 +1,289 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"exam/internal/config"
+)
+
+const (
+	// migrationLockID is the pg_advisory_lock key serializing migrations
+	// across instances that start at the same time.
+	migrationLockID = 7_355_608
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migration is one file of migrations/, named NNNN_description.sql. Files
+// are applied in version order, each in its own transaction, and never
+// edited once released: schema changes go in a new file.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations is every embedded migration, sorted by version.
+var migrations = mustLoadMigrations(migrationFS)
+
+func mustLoadMigrations(fsys fs.FS) []migration {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		panic(err)
+	}
+	var ms []migration
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			panic(fmt.Sprintf("migration %s: name must start with a positive version number", file))
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			panic(err)
+		}
+		ms = append(ms, migration{version: version, name: name, sql: string(data)})
+	}
+	slices.SortFunc(ms, func(a, b migration) int { return a.version - b.version })
+	for i := 1; i < len(ms); i++ {
+		if ms[i].version == ms[i-1].version {
+			panic(fmt.Sprintf("migrations %s and %s share version %d", ms[i-1].name, ms[i].name, ms[i].version))
+		}
+	}
+	return ms
+}
+
+// LatestSchemaVersion is the version the code expects the database at.
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+const createMigrationsTableSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// migrate applies the pending migrations. It holds an advisory lock for the
+// duration, so a second instance starting alongside waits and then finds
+// nothing left to do.
+func migrate(ctx context.Context, logger *slog.Logger, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection for migrations: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("taking migration lock: %w", err)
+	}
+	defer func() {
+		// A cancelled ctx must not leave the lock held on a pooled connection.
+		if _, err := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			logger.Error("failed to release migration lock", "error", err)
+			conn.Conn().Close(context.WithoutCancel(ctx))
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, createMigrationsTableSQL); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("reading applied migrations: %w", err)
+	}
+	applied, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return fmt.Errorf("reading applied migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		if slices.Contains(applied, m.version) {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		logger.Info("applied migration", "version", m.version, "name", m.name)
+	}
+	if err := ensureNameIndex(ctx, logger, conn); err != nil {
+		return fmt.Errorf("indexing user names: %w", err)
+	}
+	return nil
+}
+
+// maxLoggedDuplicates caps how many shared names ensureNameIndex lists.
+const maxLoggedDuplicates = 20
+
+// ensureNameIndex builds users_name_live, the unique index on live users'
+// names, in place of the trigger migration 0011 enforces them with, once
+// no two live users share a name. Until then it names the duplicates for
+// an operator to merge and leaves the trigger in place; nothing is renamed.
+// It runs under the migration lock.
+func ensureNameIndex(ctx context.Context, logger *slog.Logger, conn *pgxpool.Conn) error {
+	var indexed bool
+	if err := conn.QueryRow(ctx, "SELECT to_regclass('users_name_live') IS NOT NULL").Scan(&indexed); err != nil {
+		return err
+	}
+	if !indexed {
+		rows, err := conn.Query(ctx, `SELECT name, array_agg(id ORDER BY id), count(*) OVER ()
+FROM users WHERE deleted_at IS NULL GROUP BY name HAVING count(*) > 1 ORDER BY name LIMIT $1`, maxLoggedDuplicates)
+		if err != nil {
+			return err
+		}
+		var (
+			duplicates []string
+			total      int
+		)
+		for rows.Next() {
+			var name string
+			var ids []int
+			if err := rows.Scan(&name, &ids, &total); err != nil {
+				rows.Close()
+				return err
+			}
+			duplicates = append(duplicates, fmt.Sprintf("%q: %v", name, ids))
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if total > 0 {
+			logger.Warn("live users share names; merge them so user names can be indexed as unique, until then a trigger keeps new duplicates out",
+				"shared_names", total, "duplicates", duplicates)
+			return nil
+		}
+	}
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS users_name_live ON users (name) WHERE deleted_at IS NULL"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "DROP TRIGGER IF EXISTS users_name_live ON users; DROP FUNCTION IF EXISTS users_name_live()")
+		return err
+	})
+}
+
+// setupSchema migrates the database under DB_SCHEMA_TIMEOUT. With
+// MIGRATE_ON_START=false it leaves the schema alone; readiness then fails
+// while the database is behind (see CheckSchema).
+func setupSchema(ctx context.Context, logger *slog.Logger, pool *pgxpool.Pool, cfg config.DBConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.SchemaTimeout)
+	defer cancel()
+	if cfg.MigrateOnStart {
+		return migrate(ctx, logger, pool)
+	}
+	_, problem, err := inspectSchema(ctx, pool)
+	if err != nil {
+		return fmt.Errorf("checking schema: %w", err)
+	}
+	if problem != "" {
+		logger.Error("database schema is behind and MIGRATE_ON_START is false; not becoming ready", "problem", problem)
+	}
+	return nil
+}
+
+// CheckSchema returns what is wrong with the schema, or "" when it matches
+// the code. Once the database has caught up it stays caught up, so the
+// queries stop there.
+func (c *DB) CheckSchema(ctx context.Context) (problem string, err error) {
+	if c.schemaCurrent.Load() {
+		return "", nil
+	}
+	version, problem, err := inspectSchema(ctx, c.Pool())
+	if err != nil {
+		return "", err
+	}
+	c.schemaVersion.Store(int64(version))
+	if problem == "" {
+		c.schemaCurrent.Store(true)
+	}
+	return problem, nil
+}
+
+// requiredColumns are the columns the code queries, with the migration that
+// adds each. They are checked when a database has no schema_migrations, for
+// example one whose schema is managed outside the app.
+var requiredColumns = []struct{ table, column, migration string }{
+	{"users", "id", "0001_create_users"},
+	{"users", "name", "0001_create_users"},
+	{"users", "email", "0002_users_email"},
+	{"users", "created_at", "0003_users_created_at"},
+	{"users", "updated_at", "0006_users_updated_at"},
+	{"users", "deleted_at", "0009_users_merged"},
+	{"users", "merged_into", "0009_users_merged"},
+	{"users", "deleted_by", "0010_users_deleted_by"},
+	{"user_merges", "merged_ids", "0012_user_merges"},
+}
+
+// inspectSchema returns the applied schema version and, if the database
+// doesn't match the code, what's wrong in terms of which migration to run.
+// Without schema_migrations the version is 0 and the verdict comes from
+// requiredColumns.
+func inspectSchema(ctx context.Context, pool *pgxpool.Pool) (version int, problem string, err error) {
+	err = pool.QueryRow(ctx, "SELECT COALESCE(max(version), 0) FROM schema_migrations").Scan(&version)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" { // undefined_table
+		problem, err := missingColumns(ctx, pool)
+		return 0, problem, err
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("reading schema version: %w", err)
+	}
+	var pending []string
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, m.name)
+		}
+	}
+	if len(pending) > 0 {
+		return version, fmt.Sprintf("schema at version %d, want %d; run migration %s", version, LatestSchemaVersion(), strings.Join(pending, ", ")), nil
+	}
+	return version, "", nil
+}
+
+// querier is what missingColumns needs of a pool or transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func missingColumns(ctx context.Context, q querier) (string, error) {
+	rows, err := q.Query(ctx, `SELECT table_name || '.' || column_name FROM information_schema.columns WHERE table_schema = current_schema()`)
+	if err != nil {
+		return "", fmt.Errorf("listing columns: %w", err)
+	}
+	have, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", fmt.Errorf("listing columns: %w", err)
+	}
+	var problems []string
+	for _, c := range requiredColumns {
+		if col := c.table + "." + c.column; !slices.Contains(have, col) {
+			problems = append(problems, fmt.Sprintf("%s missing; run migration %s", col, c.migration))
+		}
+	}
+	return strings.Join(problems, "; "), nil
+}
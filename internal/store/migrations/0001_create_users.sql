@@ -0,0 +1,5 @@
+-- IF NOT EXISTS lets databases created before migrations existed adopt them.
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id         BIGSERIAL PRIMARY KEY,
+    type       TEXT NOT NULL,
+    payload    JSONB NOT NULL DEFAULT '{}',
+    run_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    attempts   INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    dead_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS jobs_due ON jobs (run_at) WHERE dead_at IS NULL;
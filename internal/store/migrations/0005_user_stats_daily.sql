@@ -0,0 +1,6 @@
+-- Daily signup counts for completed UTC days, rolled up from users by the
+-- scheduler so stats don't scan the whole table.
+CREATE TABLE IF NOT EXISTS user_stats_daily (
+    day     DATE PRIMARY KEY,
+    signups INT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- When a user was last renamed, NULL if never; the home page's
+-- Last-Modified is the newest of this and created_at.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
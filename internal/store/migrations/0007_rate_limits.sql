@@ -0,0 +1,11 @@
+-- Hits per rate limit key in the current fixed window and the one before,
+-- which the sliding window is estimated from. Rows past expires_at no
+-- longer count and are swept by the janitor.
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key          TEXT PRIMARY KEY,
+    window_start TIMESTAMPTZ NOT NULL,
+    hits         INT NOT NULL,
+    prev_hits    INT NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS rate_limits_expires ON rate_limits (expires_at);
@@ -0,0 +1,18 @@
+-- API consumers, identified by the SHA-256 of their bearer token, each
+-- allowed requests_per_hour calls per clock hour.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id                BIGSERIAL PRIMARY KEY,
+    name              TEXT NOT NULL,
+    token_hash        BYTEA NOT NULL UNIQUE,
+    requests_per_hour INT NOT NULL CHECK (requests_per_hour > 0),
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Calls per token and hour, added to in batches by each instance.
+CREATE TABLE IF NOT EXISTS api_token_usage (
+    token_id     BIGINT NOT NULL REFERENCES api_tokens ON DELETE CASCADE,
+    window_start TIMESTAMPTZ NOT NULL,
+    requests     INT NOT NULL,
+    PRIMARY KEY (token_id, window_start)
+);
+CREATE INDEX IF NOT EXISTS api_token_usage_window ON api_token_usage (window_start);
@@ -0,0 +1,6 @@
+-- Soft deletion: a user with deleted_at set is hidden everywhere but keeps
+-- its row, and merged_into names the user it was merged into so its old
+-- id still leads somewhere. Deferrable so a restore can load a user before
+-- the one it was merged into.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_into INT REFERENCES users (id) ON DELETE CASCADE DEFERRABLE;
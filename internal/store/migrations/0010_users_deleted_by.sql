@@ -0,0 +1,4 @@
+-- Who moved a user to the recycle bin, and an index for listing the bin
+-- and purging it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_by TEXT;
+CREATE INDEX IF NOT EXISTS users_trashed ON users (deleted_at) WHERE deleted_at IS NOT NULL AND merged_into IS NULL;
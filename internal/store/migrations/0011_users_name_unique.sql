@@ -0,0 +1,24 @@
+-- A live user's name is unique, as in the other stores; deleted and merged
+-- users don't hold on to theirs. Names live users already share are left
+-- as they are for an operator to merge (POST /api/users/{id}/merge). Until
+-- none are left this trigger keeps new, renamed and restored users from
+-- taking a live user's name, and once none are, ensureNameIndex replaces it
+-- with the unique index users_name_live.
+CREATE OR REPLACE FUNCTION users_name_live() RETURNS trigger AS $$
+BEGIN
+	IF NEW.deleted_at IS NOT NULL OR (TG_OP = 'UPDATE' AND NEW.name = OLD.name AND OLD.deleted_at IS NULL) THEN
+		RETURN NEW;
+	END IF;
+	-- Writers of the same name queue here, so two of them can't both find
+	-- it free.
+	PERFORM pg_advisory_xact_lock(hashtext('users_name_live'), hashtext(NEW.name));
+	IF EXISTS (SELECT 1 FROM users WHERE name = NEW.name AND deleted_at IS NULL AND id <> NEW.id) THEN
+		RAISE EXCEPTION 'user name "%" is taken', NEW.name USING ERRCODE = 'unique_violation';
+	END IF;
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_name_live ON users;
+CREATE TRIGGER users_name_live BEFORE INSERT OR UPDATE OF name, deleted_at ON users
+	FOR EACH ROW EXECUTE FUNCTION users_name_live();
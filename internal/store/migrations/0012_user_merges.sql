@@ -0,0 +1,10 @@
+-- The audit log of merges: who folded which users into which, and when.
+-- No foreign keys, so the entries outlive the users they name.
+CREATE TABLE IF NOT EXISTS user_merges (
+    id         BIGSERIAL PRIMARY KEY,
+    kept_id    INT NOT NULL,
+    merged_ids INT[] NOT NULL,
+    merged_by  TEXT,
+    merged_at  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_merges_kept ON user_merges (kept_id);
@@ -0,0 +1,372 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/reqctx"
+)
+
+const (
+	selectUserSQL      = "SELECT id, name, COALESCE(email, ''), created_at FROM users WHERE id = $1 AND deleted_at IS NULL;"
+	selectUsersSQL     = "SELECT id, name, COALESCE(email, ''), created_at FROM users WHERE deleted_at IS NULL ORDER BY id;"
+	selectUsersPageSQL = "SELECT id, name, COALESCE(email, ''), created_at FROM users WHERE id > $1 AND deleted_at IS NULL ORDER BY id LIMIT $2;"
+	insertUserSQL      = "INSERT INTO users (name, email) VALUES ($1, NULLIF($2, '')) RETURNING id, name, COALESCE(email, ''), created_at"
+)
+
+// Postgres is the UserStore backed by the pgx pool. Reads go to the
+// replica when there is one and are retried on transient errors. Its query
+// tracers feed metrics and breaker, which the web App takes over.
+type Postgres struct {
+	db          *DB
+	logger      *slog.Logger
+	metrics     *metrics
+	breaker     *Breaker
+	clock       clock.Clock
+	readRetries int
+	// copyThreshold is the batch size above which CreateMany uses COPY.
+	copyThreshold int
+}
+
+// openPostgres connects to the database, or starts connecting with
+// DB_LAZY_CONNECT, with the query tracers in place.
+func openPostgres(logger *slog.Logger, cfg config.Config, clk clock.Clock) (*Postgres, error) {
+	m := newMetrics()
+	breaker := newBreaker(logger, m, clk, cfg.BreakerThreshold, cfg.BreakerCooldown)
+	tracers := []pgx.QueryTracer{breaker, &dbMetricsTracer{metrics: m}, &slowQueryTracer{logger: logger, threshold: cfg.SlowQuery}}
+	if cfg.OtelEndpoint != "" {
+		tracers = append(tracers, newQueryTracer())
+	}
+	db, err := openDB(logger, cfg.DB, tracers)
+	if err != nil {
+		return nil, err
+	}
+	db.metrics = m
+	return &Postgres{db: db, logger: logger, metrics: m, breaker: breaker, clock: clk, readRetries: cfg.DBReadRetries, copyThreshold: cfg.DBCopyThreshold}, nil
+}
+
+// DB is the connection to the primary and replica.
+func (s *Postgres) DB() *DB {
+	return s.db
+}
+
+// Breaker is the circuit breaker the store's queries feed.
+func (s *Postgres) Breaker() *Breaker {
+	return s.breaker
+}
+
+// Collectors are the store's db_* metrics, for the caller to register.
+func (s *Postgres) Collectors() []prometheus.Collector {
+	return append(s.metrics.collectors(), poolCollectors(s.db)...)
+}
+
+func (s *Postgres) List(ctx context.Context) ([]User, error) {
+	var users []User
+	err := s.retryRead(ctx, "list_users", func(ctx context.Context) error {
+		rows, err := s.read(ctx, "list_users", selectUsersSQL)
+		if err != nil {
+			return err
+		}
+		users, err = collectUsers(rows)
+		return err
+	})
+	return users, pgError(err)
+}
+
+// ListPage fetches one extra row to tell whether more follow.
+func (s *Postgres) ListPage(ctx context.Context, after, limit int) ([]User, bool, error) {
+	var users []User
+	err := s.retryRead(ctx, "list_users_page", func(ctx context.Context) error {
+		rows, err := s.read(ctx, "list_users_page", selectUsersPageSQL, after, limit+1)
+		if err != nil {
+			return err
+		}
+		users, err = collectUsers(rows)
+		return err
+	})
+	if err != nil {
+		return nil, false, pgError(err)
+	}
+	if len(users) > limit {
+		return users[:limit], true, nil
+	}
+	return users, false, nil
+}
+
+func (s *Postgres) Get(ctx context.Context, id int) (User, error) {
+	var u User
+	err := s.retryRead(ctx, "get_user", func(ctx context.Context) error {
+		var err error
+		u, err = scanUser(s.readRow(ctx, "get_user", selectUserSQL, id))
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, s.whereMerged(ctx, id)
+	}
+	return u, pgError(err)
+}
+
+// whereMerged is Get's error for an id that isn't a live user: ErrMerged
+// if it was merged into another, else ErrNotFound.
+func (s *Postgres) whereMerged(ctx context.Context, id int) error {
+	var into int
+	err := s.readRow(ctx, "get_user_merged", "SELECT merged_into FROM users WHERE id = $1 AND merged_into IS NOT NULL;", id).Scan(&into)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return ErrNotFound
+	case err != nil:
+		return pgError(err)
+	}
+	return &ErrMerged{Into: into}
+}
+
+func (s *Postgres) Count(ctx context.Context) (int, error) {
+	var n int
+	err := s.retryRead(ctx, "count_users", func(ctx context.Context) error {
+		return s.readRow(ctx, "count_users", "SELECT count(*) FROM users WHERE deleted_at IS NULL;").Scan(&n)
+	})
+	return n, pgError(err)
+}
+
+func (s *Postgres) Version(ctx context.Context) (ListVersion, error) {
+	var (
+		v        ListVersion
+		modified *time.Time
+	)
+	err := s.retryRead(ctx, "users_version", func(ctx context.Context) error {
+		return s.readRow(ctx, "users_version", "SELECT count(*), max(COALESCE(updated_at, created_at)) FROM users WHERE deleted_at IS NULL;").Scan(&v.Count, &modified)
+	})
+	if modified != nil {
+		v.Modified = *modified
+	}
+	return v, pgError(err)
+}
+
+func (s *Postgres) Create(ctx context.Context, name, email string) (User, error) {
+	if isDryRun(ctx) {
+		var u User
+		err := s.WithTx(ctx, func(tx pgx.Tx) error {
+			var err error
+			u, err = scanUser(tx.QueryRow(ctx, insertUserSQL, name, email))
+			return err
+		})
+		return u, pgError(err)
+	}
+	u, err := scanUser(s.queryRow(ctx, "insert_user", insertUserSQL, name, email))
+	if err != nil {
+		return User{}, pgError(err)
+	}
+	return u, nil
+}
+
+// errRejected rolls back an all-or-nothing batch in which a name was
+// rejected.
+var errRejected = errors.New("batch rejected")
+
+// CreateMany inserts the names in one transaction and one round trip: a
+// pgx batch of INSERTs, or COPY for more than DB_COPY_THRESHOLD names. If
+// the database rejects a name, it starts over one savepoint per name, so
+// only that name is kept out and its result says why.
+func (s *Postgres) CreateMany(ctx context.Context, names []string, allOrNothing bool) ([]CreateResult, error) {
+	ctx = withQueryName(ctx, "insert_users_bulk")
+	insert := insertBatch
+	if len(names) > s.copyThreshold {
+		insert = insertCopy
+	}
+	var ids []int
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		ids, err = insert(ctx, tx, names)
+		return err
+	})
+	if isConstraintViolation(err) {
+		return s.createEach(ctx, names, allOrNothing)
+	}
+	if err != nil {
+		return nil, pgError(err)
+	}
+	results := make([]CreateResult, len(names))
+	for i, id := range ids {
+		results[i].ID = id
+	}
+	return results, nil
+}
+
+// insertBatch queues one INSERT per name and sends them together.
+func insertBatch(ctx context.Context, tx pgx.Tx, names []string) ([]int, error) {
+	batch := &pgx.Batch{}
+	for _, name := range names {
+		batch.Queue("INSERT INTO users (name) VALUES ($1) RETURNING id", name)
+	}
+	br := tx.SendBatch(ctx, batch)
+	defer br.Close()
+
+	ids := make([]int, len(names))
+	for i := range names {
+		if err := br.QueryRow().Scan(&ids[i]); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+	}
+	return ids, br.Close()
+}
+
+// insertCopy streams the names into a temporary table with COPY and moves
+// them into users with one INSERT. Serial ids are drawn in the order the
+// rows are inserted, so sorted they line up with names.
+func insertCopy(ctx context.Context, tx pgx.Tx, names []string) ([]int, error) {
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE bulk_users (ord int, name text) ON COMMIT DROP"); err != nil {
+		return nil, err
+	}
+	src := make([][]any, len(names))
+	for i, name := range names {
+		src[i] = []any{i, name}
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"bulk_users"}, []string{"ord", "name"}, pgx.CopyFromRows(src)); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, "INSERT INTO users (name) SELECT name FROM bulk_users ORDER BY ord RETURNING id")
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// createEach inserts the names one savepoint each, so a rejected name is
+// rolled back alone and reported in its own result.
+func (s *Postgres) createEach(ctx context.Context, names []string, allOrNothing bool) ([]CreateResult, error) {
+	var results []CreateResult
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		results = make([]CreateResult, len(names))
+		rejected := false
+		for i, name := range names {
+			sp, err := tx.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			if err := sp.QueryRow(ctx, "INSERT INTO users (name) VALUES ($1) RETURNING id", name).Scan(&results[i].ID); err != nil {
+				if !isConstraintViolation(err) {
+					return fmt.Errorf("row %d: %w", i+1, err)
+				}
+				if err := sp.Rollback(ctx); err != nil {
+					return err
+				}
+				results[i] = CreateResult{Err: pgError(err)}
+				rejected = true
+				continue
+			}
+			if err := sp.Commit(ctx); err != nil {
+				return err
+			}
+		}
+		if rejected && allOrNothing {
+			return errRejected
+		}
+		return nil
+	})
+	if errors.Is(err, errRejected) {
+		return results, nil
+	}
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return results, nil
+}
+
+func (s *Postgres) Update(ctx context.Context, id int, name string) error {
+	tag, err := s.exec(ctx, "update_user", "UPDATE users SET name = $1, updated_at = now() WHERE id = $2 AND deleted_at IS NULL", name, id)
+	if err != nil {
+		return pgError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete moves the user to the recycle bin, as TrashUser does, recording
+// the request that deleted it when there is one. The janitor purges it for
+// good once the retention period has passed.
+func (s *Postgres) Delete(ctx context.Context, id int) error {
+	var by string
+	if rid := reqctx.RequestID(ctx); rid != "" {
+		by = "request " + rid
+	}
+	_, err := s.TrashUser(ctx, id, by)
+	return err
+}
+
+func scanUser(row pgx.Row) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt)
+	return u, err
+}
+
+// collectUsers scans every row, reporting a connection lost half-way
+// through as an error rather than a short list. The error says how many
+// rows arrived first, so a truncated read is recognizable in the logs.
+func collectUsers(rows pgx.Rows) ([]User, error) {
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, truncated(len(users), err)
+	}
+	return users, nil
+}
+
+// pgError maps err onto the store's errors by SQLSTATE, wrapping the
+// original for the logs. Errors it doesn't recognize are returned as they
+// are.
+func pgError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23502": // not_null_violation
+			return &ErrValidation{Field: pgErr.ColumnName, Reason: "is required", err: err}
+		case "23514": // check_violation
+			return &ErrValidation{Field: pgErr.ColumnName, Reason: "is not allowed", err: err}
+		case "22001": // string_data_right_truncation
+			return &ErrValidation{Field: pgErr.ColumnName, Reason: "is too long", err: err}
+		}
+		if isConstraintViolation(err) {
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		}
+	}
+	if isDBUnavailable(err) || IsStatementTimeout(err) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// isConstraintViolation reports whether err is a Postgres integrity constraint
+// violation (SQLSTATE class 23), as opposed to a connectivity or server error.
+func isConstraintViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23")
+}
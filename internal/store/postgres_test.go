@@ -0,0 +1,353 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/reqctx"
+	"exam/internal/store"
+	"exam/internal/testutil"
+)
+
+// These tests cover what only the Postgres store does. Like the rest of
+// its tests they are skipped where Docker isn't available.
+
+// openPostgres returns the store of a fresh testutil.Postgres and a
+// connection of its own to the same database, for looking at it from the
+// outside.
+func openPostgres(t *testing.T) (*store.Postgres, *pgx.Conn) {
+	t.Helper()
+	env := testutil.Postgres(t)
+	conn, err := pgx.Connect(t.Context(), env.DSN)
+	if err != nil {
+		t.Fatalf("connecting to postgres: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(context.Background()) })
+	return env.Store.(*store.Postgres), conn
+}
+
+func TestApplicationName(t *testing.T) {
+	s, observer := openPostgres(t)
+	appName := func(pid uint32) string {
+		t.Helper()
+		var name string
+		err := observer.QueryRow(t.Context(), "SELECT application_name FROM pg_stat_activity WHERE pid = $1", pid).Scan(&name)
+		if err != nil {
+			t.Fatalf("pg_stat_activity of %d: %v", pid, err)
+		}
+		return name
+	}
+
+	for _, tc := range []struct{ id, want string }{
+		{"req-1", "exam req=req-1"},
+		// Postgres keeps 63 bytes of it.
+		{strings.Repeat("x", 80), ("exam req=" + strings.Repeat("x", 80))[:63]},
+	} {
+		var pid uint32
+		ctx := reqctx.WithRequestID(t.Context(), tc.id)
+		err := s.WithTx(ctx, func(tx pgx.Tx) error {
+			pid = tx.Conn().PgConn().PID()
+			// The connection is held open here, as by a long query.
+			if got := appName(pid); got != tc.want {
+				t.Errorf("application_name during request %.10s... = %q, want %q", tc.id, got, tc.want)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := appName(pid); got != "exam" {
+			t.Errorf("application_name after release = %q, want exam", got)
+		}
+	}
+
+	// Outside a request the session keeps the plain name.
+	err := s.WithTx(t.Context(), func(tx pgx.Tx) error {
+		if got := appName(tx.Conn().PgConn().PID()); got != "exam" {
+			t.Errorf("application_name outside a request = %q, want exam", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	s, _ := openPostgres(t)
+	ctx := t.Context()
+	count := func() int {
+		t.Helper()
+		n, err := s.Count(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	insert := func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "INSERT INTO users (name) VALUES ('Ada')")
+		return err
+	}
+
+	// A failing second statement takes the first with it.
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := insert(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "INSERT INTO no_such_table DEFAULT VALUES")
+		return err
+	})
+	if err == nil {
+		t.Fatal("WithTx with a failing statement succeeded")
+	}
+	if n := count(); n != 0 {
+		t.Errorf("%d users after a failed transaction, want 0", n)
+	}
+
+	// So does a panic, which carries on to the caller.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("WithTx swallowed the panic")
+			}
+		}()
+		s.WithTx(ctx, func(tx pgx.Tx) error {
+			insert(tx)
+			panic("boom")
+		})
+	}()
+	if n := count(); n != 0 {
+		t.Errorf("%d users after a panicking transaction, want 0", n)
+	}
+
+	// A serialization failure is retried once from the start, and only once.
+	calls := 0
+	err = s.WithTx(ctx, func(tx pgx.Tx) error {
+		calls++
+		if err := insert(tx); err != nil {
+			return err
+		}
+		if calls == 1 {
+			return &pgconn.PgError{Code: "40001"}
+		}
+		return nil
+	})
+	if err != nil || calls != 2 || count() != 1 {
+		t.Errorf("WithTx after one serialization failure = %v in %d calls with %d users, want nil in 2 with 1", err, calls, count())
+	}
+	calls = 0
+	err = s.WithTx(ctx, func(tx pgx.Tx) error {
+		calls++
+		return &pgconn.PgError{Code: "40P01"}
+	})
+	if err == nil || calls != 2 {
+		t.Errorf("WithTx always deadlocking = %v in %d calls, want the error after 2", err, calls)
+	}
+
+	// Under DryRun even a successful transaction leaves nothing behind.
+	err = s.WithTx(store.DryRun(ctx), func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "INSERT INTO users (name) VALUES ('Grace')")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("%d users after a dry run, want it rolled back", n)
+	}
+}
+
+// names returns n distinct user names starting with prefix.
+func names(prefix string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("%s %d", prefix, i)
+	}
+	return out
+}
+
+// CreateMany batches up to DB_COPY_THRESHOLD names and copies more; either
+// way the ids come back in the order of the names.
+func TestCreateManyPaths(t *testing.T) {
+	s, _ := openPostgres(t)
+	ctx := t.Context()
+	threshold := config.Default().DBCopyThreshold
+
+	for _, n := range []int{3, threshold + 1} {
+		batch := names(fmt.Sprintf("n%d", n), n)
+		results, err := s.CreateMany(ctx, batch, false)
+		if err != nil {
+			t.Fatalf("CreateMany of %d: %v", n, err)
+		}
+		for i, r := range results {
+			if r.Err != nil {
+				t.Fatalf("CreateMany of %d: row %d: %v", n, i+1, r.Err)
+			}
+			u, err := s.Get(ctx, r.ID)
+			if err != nil || u.Name != batch[i] {
+				t.Fatalf("CreateMany of %d: row %d got id %d, which is %q (%v), want %q", n, i+1, r.ID, u.Name, err, batch[i])
+			}
+		}
+	}
+
+	// An error that fails the whole batch names its row.
+	_, err := s.CreateMany(ctx, []string{"fine", "also fine", "nul\x00byte"}, false)
+	if err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Errorf("CreateMany with a bad third name = %v, want an error about row 3", err)
+	}
+}
+
+// BenchmarkCreateMany compares inserting rows one statement at a time with
+// CreateMany's batch, below DB_COPY_THRESHOLD, and COPY, above it.
+func BenchmarkCreateMany(b *testing.B) {
+	s := testutil.Postgres(b).Store.(*store.Postgres)
+	ctx := context.Background()
+	threshold := config.Default().DBCopyThreshold
+	run := 0
+	prefix := func() string {
+		run++
+		return fmt.Sprintf("run %d", run)
+	}
+
+	for _, n := range []int{threshold / 2, threshold * 2} {
+		b.Run(fmt.Sprintf("loop/%d", n), func(b *testing.B) {
+			for b.Loop() {
+				for _, name := range names(prefix(), n) {
+					if _, err := s.Create(ctx, name, ""); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("CreateMany/%d", n), func(b *testing.B) {
+			for b.Loop() {
+				if _, err := s.CreateMany(ctx, names(prefix(), n), false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Opening the store says which step of the startup failed. Neither case
+// needs a server.
+func TestInitErrorStage(t *testing.T) {
+	for _, tc := range []struct{ stage, url string }{
+		{"config", "postgres://app@localhost:notaport/app"},
+		{"connect", "postgres://app@127.0.0.1:1/app?sslmode=disable"},
+	} {
+		cfg := config.Default()
+		cfg.DB.URL = tc.url
+		cfg.DB.ConnectTimeout = 300 * time.Millisecond
+		cfg.DB.AttemptTimeout = 100 * time.Millisecond
+		_, err := store.Open(slog.New(slog.DiscardHandler), cfg, clock.System)
+		var initErr *store.InitError
+		if !errors.As(err, &initErr) || initErr.Stage != tc.stage {
+			t.Errorf("Open(%s) = %v, want an InitError at stage %s", tc.url, err, tc.stage)
+		}
+	}
+}
+
+// Delete is the recycle bin's soft delete, so a user deleted through the
+// plain UserStore interface can be restored like one trashed by the web.
+func TestDeleteTrashes(t *testing.T) {
+	s, _ := openPostgres(t)
+	ctx := reqctx.WithRequestID(t.Context(), "req-7")
+	u, err := s.Create(ctx, "Ada", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+	trashed, err := s.Trashed(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 1 || trashed[0].ID != u.ID || trashed[0].DeletedBy != "request req-7" || trashed[0].DeletedAt.IsZero() {
+		t.Fatalf("recycle bin after Delete = %+v, want %d deleted by request req-7", trashed, u.ID)
+	}
+	if _, err := s.RestoreUser(ctx, u.ID, time.Time{}, ""); err != nil {
+		t.Errorf("RestoreUser after Delete = %v", err)
+	}
+}
+
+// A merge soft-deletes the merged users at the store's clock and leaves an
+// entry in the audit log, written in the same transaction.
+func TestMergeAudit(t *testing.T) {
+	env := testutil.Postgres(t)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	users, err := store.Open(slog.New(slog.DiscardHandler), env.Config, clock.NewFixed(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := users.(*store.Postgres)
+	t.Cleanup(s.DB().Close)
+	ctx := t.Context()
+	var ids []int
+	for _, name := range []string{"Ada", "Ada L.", "A. Lovelace"} {
+		u, err := s.Create(ctx, name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, u.ID)
+	}
+
+	keep, merged, err := s.Merge(ctx, ids[0], []int{ids[2], ids[1], ids[2]}, "token ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keep.ID != ids[0] || !slices.Equal(merged, ids[1:]) {
+		t.Fatalf("Merge = %d, %v, want %d, %v", keep.ID, merged, ids[0], ids[1:])
+	}
+
+	var deletedAt time.Time
+	if err := s.DB().QueryRow(ctx, "SELECT deleted_at FROM users WHERE id = $1", ids[1]).Scan(&deletedAt); err != nil {
+		t.Fatal(err)
+	}
+	if !deletedAt.Equal(at) {
+		t.Errorf("merged user deleted_at = %v, want the clock's %v", deletedAt, at)
+	}
+	var (
+		keptID    int
+		mergedIDs []int
+		by        string
+		mergedAt  time.Time
+	)
+	err = s.DB().QueryRow(ctx, "SELECT kept_id, merged_ids, merged_by, merged_at FROM user_merges").Scan(&keptID, &mergedIDs, &by, &mergedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keptID != ids[0] || !slices.Equal(mergedIDs, ids[1:]) || by != "token ops" || !mergedAt.Equal(at) {
+		t.Errorf("audit entry = %d %v %q %v, want %d %v %q %v", keptID, mergedIDs, by, mergedAt, ids[0], ids[1:], "token ops", at)
+	}
+
+	// A rejected merge leaves no entry.
+	if _, _, err := s.Merge(ctx, ids[0], []int{ids[1]}, ""); !errors.As(err, new(*store.ErrValidation)) {
+		t.Fatalf("merging a merged user = %v, want ErrValidation", err)
+	}
+	var entries int
+	if err := s.DB().QueryRow(ctx, "SELECT count(*) FROM user_merges").Scan(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if entries != 1 {
+		t.Errorf("%d audit entries, want 1", entries)
+	}
+}
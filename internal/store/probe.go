@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ProbeStep is one step of a write probe: what it did, how long it took
+// and what went wrong, if anything.
+type ProbeStep struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// WriteProber is implemented by stores that can exercise their write path
+// without leaving a trace, for deep health checks.
+type WriteProber interface {
+	// ProbeWrites runs the probe's steps in order, stopping at the first
+	// that fails, and returns those it ran.
+	ProbeWrites(ctx context.Context) []ProbeStep
+}
+
+// ProbeWrites checks the columns the code needs, then inserts and deletes
+// a canary user in a transaction on the primary that is always rolled
+// back. Only the id the insert drew from the sequence is used up.
+func (s *Postgres) ProbeWrites(ctx context.Context) []ProbeStep {
+	var steps []ProbeStep
+	run := func(name string, fn func() error) bool {
+		start := time.Now()
+		err := fn()
+		steps = append(steps, ProbeStep{Name: name, Duration: time.Since(start), Err: err})
+		return err == nil
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return []ProbeStep{{Name: "begin", Err: pgError(err)}}
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	var id int
+	_ = run("schema", func() error {
+		problem, err := missingColumns(ctx, tx)
+		if err == nil && problem != "" {
+			err = errors.New(problem)
+		}
+		return err
+	}) && run("insert", func() error {
+		return pgError(tx.QueryRow(ctx, "INSERT INTO users (name) VALUES ('health check canary') RETURNING id").Scan(&id))
+	}) && run("delete", func() error {
+		tag, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", id)
+		if err == nil && tag.RowsAffected() != 1 {
+			err = fmt.Errorf("deleted %d rows, want 1", tag.RowsAffected())
+		}
+		return pgError(err)
+	})
+	return steps
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// RateWindow is a key's hits in the fixed window that began at Start and
+// in the one just before it, as of Now. A sliding window is estimated from
+// the two, as if the previous window's hits had been spread evenly over it.
+type RateWindow struct {
+	Start    time.Time
+	Now      time.Time
+	Hits     int
+	PrevHits int
+}
+
+// Allow reports whether the estimated hits in the window ending at Now are
+// within limit and, when they aren't, how long until one more would be.
+func (w RateWindow) Allow(limit int, window time.Duration) (ok bool, retryAfter time.Duration) {
+	size := window.Seconds()
+	elapsed := min(w.Now.Sub(w.Start).Seconds(), size)
+	if float64(w.PrevHits)*(1-elapsed/size)+float64(w.Hits) <= float64(limit) {
+		return true, 0
+	}
+	// at is when, from Start, the previous hits have slid far enough out of
+	// the window for another, or else those of this window have once it is
+	// the previous one.
+	var at float64
+	if w.Hits < limit {
+		at = size * (1 - float64(limit-w.Hits-1)/float64(w.PrevHits))
+	} else {
+		at = size + size*max(0, 1-float64(limit-1)/float64(w.Hits))
+	}
+	return false, time.Duration((at - elapsed) * float64(time.Second))
+}
+
+// RateCounter is implemented by stores that count rate limited hits for
+// every instance.
+type RateCounter interface {
+	// CountHit records a hit on key in windows of the given size and
+	// returns the key's counts including it.
+	CountHit(ctx context.Context, key string, window time.Duration) (RateWindow, error)
+}
+
+// countHitSQL moves the key's row on to the window now falls in, carrying
+// the hits over as the previous window's when it directly follows, and
+// counts the hit, all in the database's time so instances' clocks don't
+// matter.
+const countHitSQL = `
+	INSERT INTO rate_limits AS r (key, window_start, hits, prev_hits, expires_at)
+	SELECT $1, w.start, 1, 0, w.start + 2 * $2::interval
+	FROM (SELECT date_bin($2::interval, now(), 'epoch') AS start) w
+	ON CONFLICT (key) DO UPDATE SET
+		prev_hits = CASE r.window_start
+			WHEN EXCLUDED.window_start THEN r.prev_hits
+			WHEN EXCLUDED.window_start - $2::interval THEN r.hits
+			ELSE 0
+		END,
+		hits = CASE WHEN r.window_start = EXCLUDED.window_start THEN r.hits + 1 ELSE 1 END,
+		window_start = EXCLUDED.window_start,
+		expires_at = EXCLUDED.expires_at
+	RETURNING window_start, hits, prev_hits, now()`
+
+func (s *Postgres) CountHit(ctx context.Context, key string, window time.Duration) (RateWindow, error) {
+	var w RateWindow
+	err := s.queryRow(ctx, "count_hit", countHitSQL, key, window).Scan(&w.Start, &w.Hits, &w.PrevHits, &w.Now)
+	return w, pgError(err)
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Resetter is implemented by stores that can be emptied back to their
+// initial state, for test environments.
+type Resetter interface {
+	// Reset removes every row of every table the app owns and restarts
+	// their ids, returning how many rows it removed from each.
+	Reset(ctx context.Context) (map[string]int, error)
+}
+
+func (s *Postgres) Reset(ctx context.Context) (map[string]int, error) {
+	var removed map[string]int
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		removed = map[string]int{}
+		if _, err := tx.Exec(ctx, "LOCK TABLE "+backupTableNames()+" IN ACCESS EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+		for _, t := range backupTables {
+			var n int
+			if err := tx.QueryRow(ctx, "SELECT count(*) FROM "+t.name).Scan(&n); err != nil {
+				return err
+			}
+			removed[t.name] = n
+		}
+		_, err := tx.Exec(ctx, "TRUNCATE "+backupTableNames()+" RESTART IDENTITY")
+		return err
+	})
+	return removed, pgError(err)
+}
+
+func (s *memoryStore) Reset(ctx context.Context) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.users)
+	s.users, s.nextID, s.renamed = nil, 1, time.Time{}
+	return map[string]int{"users": n}, nil
+}
+
+func (s *sqliteStore) Reset(ctx context.Context) (map[string]int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, "DELETE FROM users")
+	if err != nil {
+		return nil, err
+	}
+	// AUTOINCREMENT's high-water mark; without it ids would carry on.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM sqlite_sequence WHERE name = 'users'"); err != nil {
+		return nil, err
+	}
+	n, _ := res.RowsAffected()
+	return map[string]int{"users": int(n)}, tx.Commit()
+}
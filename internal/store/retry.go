@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const retryBaseDelay = 50 * time.Millisecond
+
+// isTransientDBError reports whether retrying the same statement may
+// succeed: the connection failed, or Postgres aborted it over a
+// serialization failure or deadlock.
+func isTransientDBError(err error) bool {
+	return isSerializationFailure(err) || isDBUnavailable(err)
+}
+
+// isSerializationFailure reports whether Postgres aborted a transaction over
+// a serialization failure or deadlock, which running it again may avoid.
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// retryRead runs fn and retries transient failures with jittered
+// exponential backoff, up to DB_READ_RETRIES times. fn must be a read:
+// writes are not idempotent and must never go through here. Callers run it
+// before writing anything to the response.
+func (s *Postgres) retryRead(ctx context.Context, op string, fn func(context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= s.readRetries || !isTransientDBError(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := retryBaseDelay << attempt
+		delay = delay/2 + rand.N(delay/2)
+		s.metrics.dbRetries.WithLabelValues(op).Inc()
+		s.logger.Warn("retrying transient database error", "op", op, "attempt", attempt+1, "delay", delay.String(), "error", err)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"exam/internal/reqctx"
+)
+
+const (
+	// maxLoggedSQL keeps a long statement from flooding the log line.
+	maxLoggedSQL = 500
+)
+
+// slowQueryTracer is a pgx.QueryTracer that logs every query slower than
+// threshold. Only the SQL text is logged: arguments travel separately as $n
+// parameters and may hold personal data, so just their count is.
+type slowQueryTracer struct {
+	logger    *slog.Logger
+	threshold time.Duration
+}
+
+func (t *slowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey, slowQueryStart{at: time.Now(), sql: data.SQL, args: len(data.Args)})
+}
+
+func (t *slowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey).(slowQueryStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.at)
+	if dl, ok := reqctx.DebugLogger(ctx); ok {
+		dl.Debug("query",
+			"request_id", reqctx.RequestID(ctx),
+			"query", queryNameFrom(ctx, start.sql),
+			"statement", truncateSQL(start.sql),
+			"args", start.args,
+			"rows_affected", data.CommandTag.RowsAffected(),
+			"duration_ms", float64(elapsed.Microseconds())/1000,
+			"error", data.Err,
+		)
+	}
+	if elapsed < t.threshold {
+		return
+	}
+	attrs := []any{
+		"duration_ms", float64(elapsed.Microseconds()) / 1000,
+		"threshold_ms", t.threshold.Milliseconds(),
+		"statement", truncateSQL(start.sql),
+		"args", start.args,
+		"rows_affected", data.CommandTag.RowsAffected(),
+	}
+	if id := reqctx.RequestID(ctx); id != "" {
+		attrs = append(attrs, "request_id", id)
+	}
+	if data.Err != nil {
+		attrs = append(attrs, "error", data.Err)
+	}
+	t.logger.Warn("slow query", attrs...)
+}
+
+type slowQueryStart struct {
+	at   time.Time
+	sql  string
+	args int
+}
+
+// truncateSQL collapses whitespace and cuts the statement to maxLoggedSQL
+// bytes.
+func truncateSQL(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > maxLoggedSQL {
+		sql = sql[:maxLoggedSQL] + "..."
+	}
+	return sql
+}
@@ -0,0 +1,377 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"exam/internal/clock"
+)
+
+const (
+	// sqliteTimeLayout is how the driver writes times with
+	// _time_format=sqlite.
+	sqliteTimeLayout = "2006-01-02 15:04:05.999999999-07:00"
+	// sqliteBusyTimeoutMs is how long a statement waits on another
+	// connection's write lock before failing with SQLITE_BUSY.
+	sqliteBusyTimeoutMs = 5000
+)
+
+// sqliteSchema mirrors the Postgres migrations. AUTOINCREMENT keeps ids
+// from being reused after a delete, as with SERIAL.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS users (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	name       TEXT NOT NULL,
+	email      TEXT,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME,
+	deleted_at DATETIME
+)`
+
+// sqliteNameIndex keeps live users' names unique, leaving out rows with
+// deleted_at set like users_name_live on Postgres. This store has no
+// recycle bin and never sets deleted_at itself; the column is there so its
+// schema and name conflicts match Postgres'. It replaces users_name, which
+// covered every row, and sqliteNameTriggers.
+var sqliteNameIndex = []string{
+	"DROP INDEX IF EXISTS users_name",
+	"CREATE UNIQUE INDEX IF NOT EXISTS users_name_live ON users (name) WHERE deleted_at IS NULL",
+	"DROP TRIGGER IF EXISTS users_name_live_insert",
+	"DROP TRIGGER IF EXISTS users_name_live_update",
+}
+
+// sqliteNameTriggers hold new, renamed and restored users to the rule
+// sqliteNameIndex enforces while live users still share names, as the
+// trigger of migration 0011 does on Postgres.
+var sqliteNameTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS users_name_live_insert BEFORE INSERT ON users
+WHEN NEW.deleted_at IS NULL AND EXISTS (SELECT 1 FROM users WHERE name = NEW.name AND deleted_at IS NULL)
+BEGIN SELECT RAISE(ABORT, 'user name is taken'); END`,
+	`CREATE TRIGGER IF NOT EXISTS users_name_live_update BEFORE UPDATE OF name, deleted_at ON users
+WHEN NEW.deleted_at IS NULL AND (NEW.name <> OLD.name OR OLD.deleted_at IS NOT NULL)
+	AND EXISTS (SELECT 1 FROM users WHERE name = NEW.name AND deleted_at IS NULL AND id <> NEW.id)
+BEGIN SELECT RAISE(ABORT, 'user name is taken'); END`,
+}
+
+// sqliteStore is the UserStore for single-binary deployments (STORE=sqlite),
+// using the pure Go modernc.org/sqlite driver so builds stay cgo-free.
+type sqliteStore struct {
+	db    *sql.DB
+	clock clock.Clock
+}
+
+// openSQLiteStore opens or creates the database at path and its schema.
+// WAL lets reads proceed during a write, and writers queue on the busy
+// timeout instead of failing right away; transactions take the write lock
+// up front so two of them can't deadlock upgrading their read locks.
+func openSQLiteStore(ctx context.Context, logger *slog.Logger, path string, clk clock.Clock) (*sqliteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("creating directory for sqlite database %s: %w", path, err)
+	}
+	q := url.Values{}
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", sqliteBusyTimeoutMs))
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	q.Set("_txlock", "immediate")
+	q.Set("_time_format", "sqlite")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("opening sqlite database %s: %w", path, err)
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating sqlite schema in %s: %w", path, err)
+	}
+	for _, column := range []string{"updated_at DATETIME", "deleted_at DATETIME"} {
+		if err := addSQLiteColumn(ctx, db, "users", column); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("upgrading sqlite schema in %s: %w", path, err)
+		}
+	}
+	if err := ensureSQLiteNameIndex(ctx, logger, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("indexing user names in %s: %w", path, err)
+	}
+	return &sqliteStore{db: db, clock: clk}, nil
+}
+
+// ensureSQLiteNameIndex builds sqliteNameIndex once no two live users
+// share a name, as ensureNameIndex does on Postgres. Until then it names
+// the duplicates for an operator to merge and enforces the rule for new
+// names with sqliteNameTriggers; nothing is renamed.
+func ensureSQLiteNameIndex(ctx context.Context, logger *slog.Logger, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `SELECT name, group_concat(id, ', '), count(*) OVER ()
+FROM (SELECT name, id FROM users WHERE deleted_at IS NULL ORDER BY id)
+GROUP BY name HAVING count(*) > 1 ORDER BY name LIMIT ?`, maxLoggedDuplicates)
+	if err != nil {
+		return err
+	}
+	var (
+		duplicates []string
+		total      int
+	)
+	for rows.Next() {
+		var name, ids string
+		if err := rows.Scan(&name, &ids, &total); err != nil {
+			rows.Close()
+			return err
+		}
+		duplicates = append(duplicates, fmt.Sprintf("%q: [%s]", name, ids))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmts := sqliteNameIndex
+	if total > 0 {
+		logger.Warn("live users share names; merge them so user names can be indexed as unique, until then a trigger keeps new duplicates out",
+			"shared_names", total, "duplicates", duplicates)
+		stmts = sqliteNameTriggers
+	}
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSQLiteColumn adds a column that sqliteSchema gained after databases
+// were created with it, as SQLite has no ADD COLUMN IF NOT EXISTS.
+func addSQLiteColumn(ctx context.Context, db *sql.DB, table, column string) error {
+	name, _, _ := strings.Cut(column, " ")
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, name).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "ALTER TABLE "+table+" ADD COLUMN "+column)
+	return err
+}
+
+const sqliteUserColumns = "id, name, COALESCE(email, ''), created_at"
+
+func (s *sqliteStore) List(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqliteUserColumns+" FROM users ORDER BY id")
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	users, err := collectSQLUsers(rows)
+	return users, sqliteError(err)
+}
+
+func (s *sqliteStore) ListPage(ctx context.Context, after, limit int) ([]User, bool, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqliteUserColumns+" FROM users WHERE id > ? ORDER BY id LIMIT ?", after, limit+1)
+	if err != nil {
+		return nil, false, sqliteError(err)
+	}
+	users, err := collectSQLUsers(rows)
+	if err != nil {
+		return nil, false, sqliteError(err)
+	}
+	if len(users) > limit {
+		return users[:limit], true, nil
+	}
+	return users, false, nil
+}
+
+func (s *sqliteStore) Get(ctx context.Context, id int) (User, error) {
+	var u User
+	err := s.db.QueryRowContext(ctx, "SELECT "+sqliteUserColumns+" FROM users WHERE id = ?", id).
+		Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	return u, sqliteError(err)
+}
+
+func (s *sqliteStore) Count(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&n)
+	return n, sqliteError(err)
+}
+
+func (s *sqliteStore) Version(ctx context.Context) (ListVersion, error) {
+	var (
+		v        ListVersion
+		modified sql.NullString
+	)
+	// max() loses the column type, so the time comes back as the text the
+	// driver stored.
+	err := s.db.QueryRowContext(ctx, "SELECT count(*), max(COALESCE(updated_at, created_at)) FROM users").Scan(&v.Count, &modified)
+	if err != nil {
+		return ListVersion{}, sqliteError(err)
+	}
+	if modified.Valid {
+		if v.Modified, err = time.Parse(sqliteTimeLayout, modified.String); err != nil {
+			return ListVersion{}, fmt.Errorf("parsing users version: %w", err)
+		}
+	}
+	return v, nil
+}
+
+func (s *sqliteStore) DailySignups(ctx context.Context, since time.Time) ([]DayCount, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT created_at FROM users WHERE created_at >= ? ORDER BY id", utcDay(since))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var created []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		created = append(created, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(truncated(len(created), err))
+	}
+	return countDays(created), nil
+}
+
+func (s *sqliteStore) Create(ctx context.Context, name, email string) (User, error) {
+	var q interface {
+		QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	} = s.db
+	if isDryRun(ctx) {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return User{}, err
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+	var u User
+	err := q.QueryRowContext(ctx,
+		"INSERT INTO users (name, email, created_at) VALUES (?, NULLIF(?, ''), ?) RETURNING "+sqliteUserColumns,
+		name, email, s.now()).Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt)
+	return u, sqliteError(err)
+}
+
+// CreateMany mirrors the Postgres store: one transaction, one savepoint per
+// name.
+func (s *sqliteStore) CreateMany(ctx context.Context, names []string, allOrNothing bool) ([]CreateResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]CreateResult, len(names))
+	rejected := false
+	created := s.now()
+	for i, name := range names {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT insert_user"); err != nil {
+			return nil, err
+		}
+		err := tx.QueryRowContext(ctx, "INSERT INTO users (name, created_at) VALUES (?, ?) RETURNING id", name, created).Scan(&results[i].ID)
+		if err = sqliteError(err); err != nil {
+			if !isRejection(err) {
+				return nil, fmt.Errorf("row %d: %w", i+1, err)
+			}
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO insert_user"); err != nil {
+				return nil, err
+			}
+			results[i] = CreateResult{Err: err}
+			rejected = true
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE insert_user"); err != nil {
+			return nil, err
+		}
+	}
+	if rejected && allOrNothing || isDryRun(ctx) {
+		return results, nil
+	}
+	return results, tx.Commit()
+}
+
+func (s *sqliteStore) Update(ctx context.Context, id int, name string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET name = ?, updated_at = ? WHERE id = ?", name, s.now(), id)
+	return sqliteAffected(res, err)
+}
+
+func (s *sqliteStore) Delete(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+	return sqliteAffected(res, err)
+}
+
+// Ping checks that the database file can be read and has its schema.
+func (s *sqliteStore) Ping(ctx context.Context) error {
+	err := s.db.QueryRowContext(ctx, "SELECT 1 FROM users LIMIT 1").Scan(new(int))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// collectSQLUsers is collectUsers for database/sql.
+func collectSQLUsers(rows *sql.Rows) ([]User, error) {
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, truncated(len(users), err)
+	}
+	return users, nil
+}
+
+// sqliteError maps err onto the store's errors: NOT NULL violations to
+// ErrValidation, other constraint violations to ErrConflict, and a
+// database still locked after the busy timeout to ErrUnavailable.
+func sqliteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	switch code := sqliteErr.Code(); {
+	case code == sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+		return &ErrValidation{Reason: "is required", err: err}
+	case code&0xff == sqlite3.SQLITE_CONSTRAINT:
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	case code&0xff == sqlite3.SQLITE_BUSY || code&0xff == sqlite3.SQLITE_LOCKED:
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+func sqliteAffected(res sql.Result, err error) error {
+	if err = sqliteError(err); err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// now is the created_at of new rows, which Postgres fills in itself.
+func (s *sqliteStore) now() time.Time {
+	return s.clock.Now().UTC()
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DayCount is how many users signed up on one UTC day.
+type DayCount struct {
+	Day     time.Time
+	Signups int
+}
+
+// StatsRollup is implemented by stores that keep daily counts precomputed
+// for DailySignups, to be refreshed periodically.
+type StatsRollup interface {
+	// RollupSignups counts the signups of every completed day before now
+	// not yet rolled up, recounting the last one rolled up in case rows
+	// arrived late.
+	RollupSignups(ctx context.Context, now time.Time) error
+}
+
+// utcDay is the start of t's UTC day.
+func utcDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func (s *Postgres) RollupSignups(ctx context.Context, now time.Time) error {
+	_, err := s.exec(ctx, "rollup_signups", `INSERT INTO user_stats_daily (day, signups)
+		SELECT (created_at AT TIME ZONE 'UTC')::date, count(*) FROM users
+		WHERE created_at >= COALESCE((SELECT max(day) FROM user_stats_daily), '-infinity'::date)::timestamp AT TIME ZONE 'UTC'
+		  AND created_at < $1
+		GROUP BY 1
+		ON CONFLICT (day) DO UPDATE SET signups = EXCLUDED.signups`, utcDay(now))
+	if err != nil {
+		return fmt.Errorf("rolling up signups: %w", pgError(err))
+	}
+	return nil
+}
+
+// DailySignups reads completed days from the rollup and counts the days
+// after it, normally just today, live.
+func (s *Postgres) DailySignups(ctx context.Context, since time.Time) ([]DayCount, error) {
+	var days []DayCount
+	err := s.retryRead(ctx, "daily_signups", func(ctx context.Context) error {
+		rows, err := s.read(ctx, "daily_signups", `WITH live AS (
+				SELECT GREATEST(COALESCE((SELECT max(day) + 1 FROM user_stats_daily), $1::date), $1::date) AS day
+			)
+			SELECT day, signups FROM user_stats_daily WHERE day >= $1::date AND day < (SELECT day FROM live)
+			UNION ALL
+			SELECT (created_at AT TIME ZONE 'UTC')::date, count(*)::int FROM users
+			WHERE created_at >= (SELECT day FROM live)::timestamp AT TIME ZONE 'UTC'
+			GROUP BY 1
+			ORDER BY 1`, utcDay(since))
+		if err != nil {
+			return err
+		}
+		days, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (DayCount, error) {
+			var d DayCount
+			err := row.Scan(&d.Day, &d.Signups)
+			return d, err
+		})
+		return err
+	})
+	return days, pgError(err)
+}
+
+// countDays groups created into DayCounts by UTC day, for the stores
+// that count live.
+func countDays(created []time.Time) []DayCount {
+	var days []DayCount
+	for _, t := range created {
+		day := utcDay(t)
+		if n := len(days); n > 0 && days[n-1].Day.Equal(day) {
+			days[n-1].Signups++
+			continue
+		}
+		days = append(days, DayCount{Day: day, Signups: 1})
+	}
+	return days
+}
@@ -0,0 +1,144 @@
+// Package store keeps the users: the UserStore interface and its Postgres,
+// SQLite and in-memory backends, along with the Postgres connection pool,
+// migrations and the query instrumentation behind it.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+)
+
+type User struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListVersion tells whether the users changed without reading them: any
+// create or rename moves Modified forward, and a delete lowers Count, so
+// no two states of the users since a version share it.
+type ListVersion struct {
+	Count int
+	// Modified is when the newest user was created or last renamed, zero
+	// when there are no users.
+	Modified time.Time
+}
+
+// Errors returned by UserStore implementations. Handlers branch on them with
+// errors.Is; the backend's own error is wrapped alongside for the logs.
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrConflict = errors.New("user conflicts with an existing one")
+	// ErrUnavailable means the database could not serve the call right
+	// now: it is unreachable or overloaded, or the statement timed out.
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// ErrMerged is Get's error for a user that was merged into another. It
+// counts as ErrNotFound for callers that don't follow it.
+type ErrMerged struct {
+	Into int
+}
+
+func (e *ErrMerged) Error() string {
+	return fmt.Sprintf("user was merged into %d", e.Into)
+}
+
+func (e *ErrMerged) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ErrValidation is a value the database rejected, such as a missing
+// required column. Match it with errors.As.
+type ErrValidation struct {
+	Field  string
+	Reason string
+	err    error
+}
+
+func (e *ErrValidation) Error() string {
+	if e.Field == "" {
+		return e.Reason
+	}
+	return e.Field + " " + e.Reason
+}
+
+func (e *ErrValidation) Unwrap() error {
+	return e.err
+}
+
+// CreateResult is the outcome of one name given to CreateMany: the new
+// user's id, or the error that kept it out.
+type CreateResult struct {
+	ID  int
+	Err error
+}
+
+// isRejection reports whether err keeps only its own row out of a bulk
+// insert rather than failing the whole batch.
+func isRejection(err error) bool {
+	var invalid *ErrValidation
+	return errors.Is(err, ErrConflict) || errors.As(err, &invalid)
+}
+
+// truncated wraps the error that ended a result set after n rows.
+func truncated(n int, err error) error {
+	return fmt.Errorf("result set cut short after %d rows: %w", n, err)
+}
+
+// UserStore is where users live. Handlers only talk to it, so they deal in
+// HTTP concerns alone and don't depend on a particular database.
+type UserStore interface {
+	// List returns every user by id.
+	List(ctx context.Context) ([]User, error)
+	// ListPage returns up to limit users with an id greater than after, and
+	// whether more follow.
+	ListPage(ctx context.Context, after, limit int) ([]User, bool, error)
+	Get(ctx context.Context, id int) (User, error)
+	Count(ctx context.Context) (int, error)
+	// Version is the users' ListVersion.
+	Version(ctx context.Context) (ListVersion, error)
+	// Create adds a user; an empty email is stored as none. Names are
+	// unique: one another user has is ErrConflict, in Update too.
+	Create(ctx context.Context, name, email string) (User, error)
+	// CreateMany adds users by name atomically, except that a name the
+	// database rejects only keeps itself out: its result's Err is
+	// ErrConflict or an ErrValidation. With allOrNothing, any rejection
+	// keeps every name out. Results are in the order of names.
+	CreateMany(ctx context.Context, names []string, allOrNothing bool) ([]CreateResult, error)
+	Update(ctx context.Context, id int, name string) error
+	// Delete removes a live user. Stores with a recycle bin (Trash) move it
+	// there, where it can be restored until it is purged.
+	Delete(ctx context.Context, id int) error
+	// DailySignups counts the users created on each UTC day from since's
+	// onwards, in order, leaving out days without any.
+	DailySignups(ctx context.Context, since time.Time) ([]DayCount, error)
+}
+
+// Open opens the backend STORE selects. clk stamps the created_at of new
+// users in the stores that set it themselves and times the circuit breaker.
+func Open(logger *slog.Logger, cfg config.Config, clk clock.Clock) (UserStore, error) {
+	switch cfg.Store {
+	case "memory":
+		logger.Warn("using the in-memory store; users are lost on restart")
+		return newMemoryStore(clk), nil
+	case "sqlite":
+		logger.Info("using sqlite store", "path", cfg.SQLitePath)
+		return openSQLiteStore(context.Background(), logger, cfg.SQLitePath, clk)
+	default:
+		return openPostgres(logger, cfg, clk)
+	}
+}
+
+// Pinger is implemented by stores other than Postgres that have a
+// connection for readiness to check; the Postgres pool is checked directly.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TrashedUser is a user in the recycle bin: deleted, not merged into
+// another, and not yet purged.
+type TrashedUser struct {
+	User
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+}
+
+// Trash is implemented by stores whose deletes can be undone. A trashed
+// user is hidden like a merged one until it is restored, or purged by the
+// janitor once the retention period has passed.
+type Trash interface {
+	// TrashUser soft-deletes a live user, recording by as who did it, and
+	// returns when.
+	TrashUser(ctx context.Context, id int, by string) (time.Time, error)
+	// Trashed lists up to limit users in the recycle bin, most recently
+	// deleted first.
+	Trashed(ctx context.Context, limit int) ([]TrashedUser, error)
+	// RestoreUser takes id out of the recycle bin, renamed to name unless
+	// that is empty. With a non-zero deletedAt it only undoes the deletion
+	// made then. A user not in the bin is ErrNotFound, and one whose name
+	// a live user now has is ErrConflict.
+	RestoreUser(ctx context.Context, id int, deletedAt time.Time, name string) (User, error)
+}
+
+func (s *Postgres) TrashUser(ctx context.Context, id int, by string) (time.Time, error) {
+	var at time.Time
+	err := s.queryRow(ctx, "trash_user", "UPDATE users SET deleted_at = now(), deleted_by = $2 WHERE id = $1 AND deleted_at IS NULL RETURNING deleted_at", id, by).Scan(&at)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	return at, pgError(err)
+}
+
+// Trashed reads the primary, so a user deleted a moment ago is listed.
+func (s *Postgres) Trashed(ctx context.Context, limit int) ([]TrashedUser, error) {
+	rows, err := s.query(ctx, "list_trashed_users", `
+		SELECT id, name, COALESCE(email, ''), created_at, deleted_at, COALESCE(deleted_by, '')
+		FROM users WHERE deleted_at IS NOT NULL AND merged_into IS NULL
+		ORDER BY deleted_at DESC, id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	users, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TrashedUser, error) {
+		var u TrashedUser
+		err := row.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt, &u.DeletedAt, &u.DeletedBy)
+		return u, err
+	})
+	return users, pgError(err)
+}
+
+func (s *Postgres) RestoreUser(ctx context.Context, id int, deletedAt time.Time, name string) (User, error) {
+	var at *time.Time
+	if !deletedAt.IsZero() {
+		at = &deletedAt
+	}
+	var u User
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		var current string
+		err := tx.QueryRow(ctx, `
+			SELECT name FROM users
+			WHERE id = $1 AND deleted_at IS NOT NULL AND merged_into IS NULL AND ($2::timestamptz IS NULL OR deleted_at = $2)
+			FOR UPDATE`, id, at).Scan(&current)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			name = current
+		}
+		var taken bool
+		if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE name = $1 AND deleted_at IS NULL)", name).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("%w: a user named %q exists", ErrConflict, name)
+		}
+		u, err = scanUser(tx.QueryRow(ctx, `
+			UPDATE users SET deleted_at = NULL, deleted_by = NULL, name = $2,
+				updated_at = CASE WHEN name = $2 THEN updated_at ELSE now() END
+			WHERE id = $1
+			RETURNING id, name, COALESCE(email, ''), created_at`, id, name))
+		return err
+	})
+	return u, pgError(err)
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// WithTx runs fn in a transaction on the primary, committing if fn returns
+// nil and rolling back if it returns an error or panics. A transaction
+// aborted by a serialization failure or deadlock is run once more from the
+// start, so fn must not have effects outside tx. Under DryRun it is rolled
+// back even when fn succeeds.
+func (s *Postgres) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	err := s.runTx(ctx, fn)
+	if isSerializationFailure(err) && ctx.Err() == nil {
+		s.logger.Warn("retrying transaction after serialization failure", "error", err)
+		err = s.runTx(ctx, fn)
+	}
+	return err
+}
+
+func (s *Postgres) runTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Roll back even when ctx is what ended the transaction; with ctx
+	// itself pgx would give up and close the connection instead.
+	rollback := func() { tx.Rollback(context.WithoutCancel(ctx)) }
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil || isDryRun(ctx) {
+		rollback()
+		return err
+	}
+	return tx.Commit(ctx)
+}
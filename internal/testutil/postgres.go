@@ -0,0 +1,112 @@
+// Package testutil runs the app against a disposable Postgres, for
+// integration tests of the handlers and store together.
+package testutil
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/store"
+	"exam/internal/web"
+)
+
+const (
+	// postgresImage matches the version docker-compose.yml runs.
+	postgresImage = "postgres:15"
+	// InternalToken is the INTERNAL_API_TOKEN the app is built with, for
+	// the admin endpoints.
+	InternalToken = "test-internal-token"
+)
+
+// Env is a running app backed by its own Postgres. Everything in it is torn
+// down when the test that asked for it ends.
+type Env struct {
+	// DSN reaches the container's database, for checking on what the app
+	// wrote behind its back.
+	DSN     string
+	Config  config.Config
+	Store   store.UserStore
+	App     *web.App
+	Handler http.Handler
+}
+
+// Postgres starts a Postgres container, opens the store against it, which
+// applies the migrations, and builds the App around that store with opts.
+// The test is skipped when Docker isn't available.
+func Postgres(t testing.TB, opts ...web.Option) *Env {
+	t.Helper()
+	skipWithoutDocker(t)
+
+	ctx := context.Background()
+	ctr, err := postgres.Run(ctx, postgresImage,
+		postgres.WithDatabase("app"),
+		postgres.WithUsername("app"),
+		postgres.WithPassword("app"),
+		postgres.BasicWaitStrategies(),
+	)
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("starting postgres: %v", err)
+	}
+	dsn, err := ctr.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("postgres connection string: %v", err)
+	}
+
+	cfg := config.Default()
+	cfg.DB.URL = dsn
+	cfg.InternalToken = InternalToken
+	logger := slog.New(slog.NewTextHandler(testWriter{t}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	users, err := store.Open(logger, cfg, clock.System)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	app, err := web.NewApp(users, append([]web.Option{web.WithConfig(cfg), web.WithLogger(logger)}, opts...)...)
+	if err != nil {
+		t.Fatalf("building app: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := app.Shutdown(ctx); err != nil {
+			t.Errorf("shutting down app: %v", err)
+		}
+	})
+	return &Env{DSN: dsn, Config: cfg, Store: users, App: app, Handler: app.Handler()}
+}
+
+// skipWithoutDocker is testcontainers.SkipIfProviderIsNotHealthy for
+// benchmarks too.
+func skipWithoutDocker(t testing.TB) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skipf("Docker is not available: %v", r)
+		}
+	}()
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err == nil {
+		err = provider.Health(context.Background())
+	}
+	if err != nil {
+		t.Skipf("Docker is not available: %v", err)
+	}
+}
+
+// testWriter sends log lines to the test log, so they only show for
+// failing or verbose tests.
+type testWriter struct{ t testing.TB }
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(string(p))
+	return len(p), nil
+}
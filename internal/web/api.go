@@ -0,0 +1,184 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"exam/internal/reqctx"
+	"exam/internal/store"
+)
+
+// errorEnvelope is the body of every JSON error response:
+//
+//	{"error": {"code": "validation_failed", "message": "Name must not be empty.", "request_id": "..."}}
+type errorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type createUserRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// isDryRun reports whether r asks, with ?validate=only or X-Dry-Run: true,
+// for its creates to be checked exactly as usual but not kept; see
+// store.DryRun.
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("validate") == "only" || r.Header.Get("X-Dry-Run") == "true"
+}
+
+// writeJSON encodes v before writing anything, so a value the encoder
+// rejects turns into a clean 500 instead of a 200 with a cut-off body.
+func (app *App) writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		app.requestLogger(r).Error("failed to encode response", "type", fmt.Sprintf("%T", v), "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "internal", "Failed to encode the response.")
+		return
+	}
+	writeJSONBody(w, status, append(body, '\n'))
+}
+
+func writeJSONBody(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	// Errors are never cached, whatever the route's policy.
+	w.Header().Set("Cache-Control", noStore)
+	// An envelope of strings always encodes.
+	body, _ := json.Marshal(errorEnvelope{Error: apiError{Code: code, Message: message, RequestID: reqctx.RequestID(r.Context())}})
+	writeJSONBody(w, status, append(body, '\n'))
+}
+
+// decodeJSON decodes the request body into v, answering 413 when the body
+// exceeds the size limit and 400 with badRequest for anything else.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any, badRequest string) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	switch {
+	case err == nil:
+		return true
+	case isBodyTooLarge(err):
+		writeJSONError(w, r, http.StatusRequestEntityTooLarge, "body_too_large", "Request body is too large.")
+	default:
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_json", badRequest)
+	}
+	return false
+}
+
+// handleCreateUser creates a user from a JSON body and responds with the full
+// stored record, including id and created_at, so clients need no second fetch.
+// A dry run (see isDryRun) answers the same, with id 0.
+func (app *App) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if !decodeJSON(w, r, &req, "Request body must be a JSON object.") {
+		return
+	}
+
+	name, err := validateName(req.Name)
+	if err != nil {
+		writeJSONError(w, r, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+		return
+	}
+	email := normalizeEmail(req.Email)
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			writeJSONError(w, r, http.StatusUnprocessableEntity, "validation_failed", "Email address is not valid.")
+			return
+		}
+	}
+
+	if isDryRun(r) {
+		user, err := app.users.Create(store.DryRun(r.Context()), name, email)
+		if err != nil {
+			app.respondError(w, r, fmt.Errorf("dry run add user: %w", err))
+			return
+		}
+		// Answered as a real create is, but with id 0: the user the id was
+		// drawn for was never kept.
+		user.ID = 0
+		app.writeJSON(w, r, http.StatusCreated, user)
+		return
+	}
+	user, err := app.users.Create(r.Context(), name, email)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("add user: %w", err))
+		return
+	}
+	app.publish(r, UserCreated, user)
+	app.writeJSON(w, r, http.StatusCreated, user)
+}
+
+// handleGetUser returns one user as JSON.
+func (app *App) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		app.notFound(w, r)
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), id)
+	var merged *store.ErrMerged
+	if errors.As(err, &merged) {
+		http.Redirect(w, r, "/api/users/"+strconv.Itoa(merged.Into), http.StatusMovedPermanently)
+		return
+	}
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("load user %d: %w", id, err))
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, user)
+}
+
+type mergeRequest struct {
+	MergeIDs []int `json:"merge_ids"`
+}
+
+type mergeResponse struct {
+	User   store.User `json:"user"`
+	Merged []int      `json:"merged_ids"`
+}
+
+// handleMergeUsers merges the users in merge_ids into the one in the path;
+// see store.Merger. The merged ids answer 301 to the kept user from then
+// on.
+func (app *App) handleMergeUsers(w http.ResponseWriter, r *http.Request) {
+	m, ok := app.users.(store.Merger)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotImplemented, "unsupported", "Merging users needs the Postgres store.")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		app.notFound(w, r)
+		return
+	}
+	var req mergeRequest
+	if !decodeJSON(w, r, &req, `Request body must be {"merge_ids": [...]}.`) {
+		return
+	}
+	user, ids, err := m.Merge(r.Context(), id, req.MergeIDs, app.actor(r))
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("merge users into %d: %w", id, err))
+		return
+	}
+	app.requestLogger(r).Info("users merged", "into", id, "merged", ids)
+	for _, merged := range ids {
+		app.publish(r, UserDeleted, store.User{ID: merged})
+	}
+	app.publish(r, UserUpdated, user)
+	app.writeJSON(w, r, http.StatusOK, mergeResponse{User: user, Merged: ids})
+}
@@ -0,0 +1,668 @@
+// Package web is the HTTP side of the app: handlers, templates, middleware,
+// health and metrics endpoints, and the listeners they are served on.
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/store"
+)
+
+type App struct {
+	cfg       config.Config
+	logger    *slog.Logger
+	logLevel  *slog.LevelVar
+	db        *store.DB
+	users     store.UserStore
+	templates *templateSet
+	assets    *assetManifest
+	startedAt time.Time
+	// clock and ids stand in for time.Now and crypto/rand wherever a
+	// timestamp or id is handed out.
+	clock clock.Clock
+	ids   clock.IDGenerator
+
+	metrics    *metrics
+	bodyLimits config.BodyLimits
+	// requestTimeout bounds each request; see withTimeout.
+	requestTimeout time.Duration
+	concurrency    concurrencyLimit
+	// rateLimit is nil when rate limiting is off; see rateLimited.
+	rateLimit *rateLimiter
+	forms     *formGuard
+	// quotas is nil for stores without API tokens; see withAPIQuota.
+	quotas      *quotas
+	breaker     *store.Breaker
+	health      *healthCache
+	pingTimeout time.Duration
+	// flags are the feature flags; see Flag.
+	flags    featureFlags
+	branding Branding
+	// maintenance is toggled at runtime; see withMaintenance.
+	maintenance   atomic.Bool
+	internalToken string
+	debugToken    string
+	accessLogSkip map[string]struct{}
+	// trustedProxies may set X-Forwarded-For / X-Real-IP; see clientIP.
+	trustedProxies []netip.Prefix
+
+	tracing         bool
+	shutdownTracing func(context.Context) error
+	background      *background
+	// events carries user changes to the notifiers; see publish.
+	events eventBus
+	// usersCache holds GET /api/users bodies, nil when caching is off; see
+	// handleGetUsers. redis is set when it is shared through Redis.
+	usersCache bodyCache
+	redis      *redisCache
+	// jobs is nil for stores that can't keep jobs; see Enqueue.
+	jobs        store.JobQueue
+	jobHandlers map[string]JobHandler
+	latency     *latencyTracker
+	// mux holds the routes; see handler.
+	mux         *http.ServeMux
+	handlerOnce sync.Once
+	h           http.Handler
+}
+
+type GetUsersResponse struct {
+	Users []store.User `json:"users"`
+}
+
+// homePage is the data rendered by the "home" page. Name and Error are only set when
+// a submitted form is re-rendered after a failed validation or insert.
+type homePage struct {
+	Users     []store.User
+	NextAfter int // cursor for the next page, 0 when this is the last one
+	Name      string
+	Email     string
+	Error     string
+	Bulk      *bulkReport
+	// Stamp is the add-user form's signed render time; see formGuard.
+	Stamp string
+	// Undo is the token that undoes the delete just made, if any.
+	Undo string
+}
+
+// bulkReport describes the outcome of a bulk add, one entry per submitted line.
+type bulkReport struct {
+	Strict bool
+	// DryRun reports what would have been added; see isDryRun.
+	DryRun  bool
+	Input   string
+	Results []bulkResult
+	Added   int
+}
+
+type bulkResult struct {
+	Line   int
+	Name   string
+	Added  bool
+	ID     int // set when Added
+	Reason string
+}
+
+const maxNameLength = 200
+
+// validateName trims a submitted name and applies the rules shared by every
+// create and update path. The returned error is safe to show to the user.
+func validateName(raw string) (string, error) {
+	name := strings.TrimSpace(raw)
+	if name == "" {
+		return "", errors.New("Name must not be empty.")
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return "", fmt.Errorf("Name must be at most %d characters.", maxNameLength)
+	}
+	return name, nil
+}
+
+// userPage is the data rendered by the "user" detail page.
+type userPage struct {
+	User store.User
+}
+
+// relativeTime renders t as a coarse "5 minutes ago" style string as of
+// now for the homepage; the exact timestamp is kept in the cell's title
+// attribute.
+func relativeTime(now, t time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute") + " ago"
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour") + " ago"
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day") + " ago"
+	default:
+		return t.Format("Jan 2, 2006")
+	}
+}
+
+// relativeTimeStep is how often relativeTime changes for a time age old.
+func relativeTimeStep(age time.Duration) time.Duration {
+	switch {
+	case age < time.Hour:
+		return time.Minute
+	case age < 24*time.Hour:
+		return time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// NewServer builds the app around users and returns its handler, with every
+// route and middleware in place but nothing listening, for embedding in
+// another server or serving from httptest. Logging goes to logger, filtered
+// at cfg.LogLevel and adjustable at runtime through /_internal/loglevel.
+func NewServer(cfg config.Config, users store.UserStore, logger *slog.Logger) (http.Handler, error) {
+	app, err := NewApp(users, WithConfig(cfg), WithLogger(logger))
+	if err != nil {
+		return nil, err
+	}
+	return app.Handler(), nil
+}
+
+// NewApp builds the App around users. Everything else has a default that
+// opts may replace, so NewApp(store.NewMemoryStore(clock.System)) is a
+// working App. A Postgres store brings the database connection, breaker and
+// db_* metrics its query tracers feed; other stores have none of them.
+func NewApp(users store.UserStore, opts ...Option) (*App, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(users); err != nil {
+		return nil, fmt.Errorf("invalid app options: %w", err)
+	}
+	cfg, logger := o.cfg, o.logger
+	if o.logLevel == nil {
+		o.logLevel = new(slog.LevelVar)
+		o.logLevel.Set(cfg.LogLevel)
+		logger = slog.New(leveledHandler{logger.Handler(), o.logLevel})
+	}
+
+	assets, err := newAssetManifest(o.clock)
+	if err != nil {
+		return nil, fmt.Errorf("loading static assets: %w", err)
+	}
+	flags, unknownFlags := newFeatureFlags(cfg.FeatureFlags)
+	if len(unknownFlags) > 0 {
+		slices.Sort(unknownFlags)
+		logger.Warn("ignoring unknown feature flags", "flags", unknownFlags)
+	}
+	branding := newBranding(cfg.Branding)
+	templates, err := parseTemplates(o.templates, o.clock, assets, flags, branding)
+	if err != nil {
+		return nil, fmt.Errorf("parsing templates: %w", err)
+	}
+	tracing, shutdownTracing, err := setupTracing(context.Background(), cfg.OtelEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("setting up tracing to %s: %w", cfg.OtelEndpoint, err)
+	}
+	if tracing {
+		logger.Info("tracing enabled", "endpoint", cfg.OtelEndpoint)
+	}
+	app := &App{
+		cfg:             cfg,
+		logger:          logger,
+		logLevel:        o.logLevel,
+		health:          &healthCache{clock: o.clock, ttl: cfg.HealthCacheTTL},
+		pingTimeout:     cfg.HealthPingTimeout,
+		bodyLimits:      cfg.BodyLimits,
+		internalToken:   cfg.InternalToken,
+		debugToken:      cfg.DebugToken,
+		accessLogSkip:   cfg.AccessLogSkip,
+		trustedProxies:  cfg.TrustedProxies,
+		requestTimeout:  cfg.RequestTimeout,
+		concurrency:     newConcurrencyLimit(cfg.MaxConcurrent, cfg.ConcurrencyQueue),
+		forms:           newFormGuard(o.clock, formKey(logger, cfg), cfg.FormMinFillTime),
+		users:           users,
+		metrics:         newMetrics(),
+		templates:       templates,
+		flags:           flags,
+		branding:        branding,
+		assets:          assets,
+		startedAt:       o.clock.Now(),
+		clock:           o.clock,
+		ids:             o.ids,
+		tracing:         tracing,
+		shutdownTracing: shutdownTracing,
+		background:      newBackground(),
+		latency:         newLatencyTracker(o.clock, cfg.LatencyBudgets),
+		mux:             http.NewServeMux(),
+	}
+	if pg, ok := users.(*store.Postgres); ok {
+		app.db, app.breaker = pg.DB(), pg.Breaker()
+		app.metrics.registry.MustRegister(pg.Collectors()...)
+	}
+	app.maintenance.Store(cfg.Maintenance)
+	for _, nn := range o.notifiers {
+		app.subscribe(nn.name, nn.n)
+	}
+	if st, ok := users.(store.APITokens); ok {
+		app.quotas = newQuotas(st, o.clock)
+		app.goBackground("api usage", app.flushQuotas)
+	}
+	if cfg.RateLimitWindow > 0 {
+		app.rateLimit = newRateLimiter(users, o.clock, cfg.RateLimit, cfg.RateLimitWindow)
+	}
+	if cfg.UsersCacheTTL > 0 {
+		local := newResponseCache(o.clock, cfg.UsersCacheTTL)
+		app.usersCache = local
+		if cfg.RedisURL != "" {
+			if app.redis, err = newRedisCache(cfg.RedisURL, "users", cfg.UsersCacheTTL, local, logger); err != nil {
+				return nil, fmt.Errorf("setting up cache: %w", err)
+			}
+			app.usersCache = app.redis
+			app.goBackground("redis cache", app.redis.follow)
+		}
+	}
+	if sw, ok := users.(store.Sweeper); ok && cfg.CleanupInterval > 0 {
+		app.scheduleJanitor(sw, cfg)
+	}
+	if r, ok := users.(store.StatsRollup); ok {
+		app.schedule("stats rollup", statsRollupInterval, func(ctx context.Context) error {
+			return r.RollupSignups(ctx, app.clock.Now())
+		})
+	}
+	if q, ok := users.(store.JobQueue); ok {
+		app.jobs, app.jobHandlers = q, o.jobs
+		app.startJobWorkers(cfg.JobWorkers, cfg.JobPollInterval, cfg.JobMaxAttempts)
+	}
+	return app, nil
+}
+
+// Handler returns the app's routes wrapped in its middleware. Routes are
+// registered on the first call; later calls return the same handler.
+func (app *App) Handler() http.Handler {
+	app.handlerOnce.Do(func() { app.h = app.handler(app.cfg) })
+	return app.h
+}
+
+// usersPageSize is how many rows the homepage and each scroll fragment show.
+const usersPageSize = 25
+
+// renderHome writes the homepage with the given status. It is used both for
+// plain GETs and for re-rendering the form after a rejected POST.
+func (app *App) renderHome(w http.ResponseWriter, r *http.Request, status int, page homePage) {
+	after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+	stopDB := startPhase(r.Context(), "db")
+	users, more, err := app.users.ListPage(r.Context(), after, usersPageSize)
+	stopDB()
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("list users: %w", err))
+		return
+	}
+	page.Users = users
+	page.Stamp = app.forms.stamp()
+	if more {
+		page.NextAfter = users[len(users)-1].ID
+	}
+
+	defer startPhase(r.Context(), "render")()
+	app.renderStatus(w, r, status, "home", page)
+}
+
+// handleHome serves the homepage. A GET's page is the same for everyone,
+// so it is validated by the users' version instead of rendered when the
+// browser already has it. Anything the page ever shows of the request
+// itself, such as a flash message or who is signed in, must skip the
+// conditional path for that request, or one visitor's page would be
+// confirmed as another's.
+func (app *App) handleHome(w http.ResponseWriter, r *http.Request) {
+	// The undo offered after a delete is one such message.
+	undo := r.URL.Query().Get("undo")
+	if undo == "" && app.Flag(flagHomeConditionalGet) && app.homeNotModified(w, r) {
+		return
+	}
+	page := homePage{}
+	if _, _, ok := app.parseUndo(undo); ok {
+		page.Undo = undo
+	}
+	app.renderHome(w, r, http.StatusOK, page)
+}
+
+// homeNotModified sets the homepage's validators and answers 304 when they
+// match the request's. Without the users' version it leaves the request to
+// the render, which reports the error.
+func (app *App) homeNotModified(w http.ResponseWriter, r *http.Request) bool {
+	v, err := app.users.Version(r.Context())
+	if err != nil {
+		app.requestLogger(r).Debug("skipping homepage validation", "error", err)
+		return false
+	}
+	// The page's relative times move on without the users changing, so the
+	// tag does too, as often as the newest of them can.
+	now := app.clock.Now()
+	ages := periodStart(now, relativeTimeStep(now.Sub(v.Modified)))
+	// So does its form's stamp, well before the stamp runs out.
+	stamps := periodStart(now, formStampMaxAge/2)
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d %d %d %d %s %s %v", v.Count, v.Modified.UnixNano(), ages.UnixNano(), stamps.UnixNano(), Build.Commit, Build.BuildDate, app.branding))
+	// Last-Modified is when the newest of those inputs changed, counting a
+	// restart for the build and branding, so a client sending only
+	// If-Modified-Since revalidates whenever the tag would have changed,
+	// deletes aside.
+	modified := v.Modified
+	for _, t := range []time.Time{app.startedAt, ages, stamps} {
+		if t.After(modified) {
+			modified = t
+		}
+	}
+	// Browsers store the page but ask before reusing it.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	return notModified(w, r, `W/"`+hex.EncodeToString(sum[:16])+`"`, modified)
+}
+
+// periodStart is the start of the period of length d, counted from the Unix
+// epoch, that t falls in.
+func periodStart(t time.Time, d time.Duration) time.Time {
+	return time.Unix(0, t.UnixNano()/int64(d)*int64(d))
+}
+
+// handleAddUser adds the user posted by the home page form, unless it looks
+// posted by a bot; see rejectSpam.
+func (app *App) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	if !app.parseForm(w, r) {
+		return
+	}
+	name, err := validateName(r.FormValue("name"))
+	email := normalizeEmail(r.FormValue("email"))
+	if app.rejectSpam(w, r, homePage{Name: strings.TrimSpace(r.FormValue("name")), Email: email}) {
+		return
+	}
+	if err != nil {
+		app.renderHome(w, r, http.StatusUnprocessableEntity, homePage{Email: email, Error: err.Error()})
+		return
+	}
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			app.renderHome(w, r, http.StatusUnprocessableEntity, homePage{Name: name, Email: email, Error: "Email address is not valid."})
+			return
+		}
+	}
+	user, err := app.users.Create(r.Context(), name, email)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			app.renderHome(w, r, http.StatusConflict, homePage{Name: name, Email: email, Error: "This user conflicts with an existing one."})
+			return
+		}
+		app.respondError(w, r, fmt.Errorf("add user: %w", err))
+		return
+	}
+	app.publish(r, UserCreated, user)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleBulkAdd inserts one user per non-blank line of the "names" textarea,
+// or with isDryRun only reports which lines would be.
+// In strict mode any rejected line aborts the whole batch; otherwise valid
+// lines are kept and each failure is reported next to its line number.
+func (app *App) handleBulkAdd(w http.ResponseWriter, r *http.Request) {
+	if !app.parseForm(w, r) {
+		return
+	}
+	report := &bulkReport{Strict: r.FormValue("strict") != "", DryRun: isDryRun(r), Input: r.FormValue("names")}
+
+	for i, line := range strings.Split(report.Input, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		res := bulkResult{Line: i + 1, Name: strings.TrimSpace(line)}
+		if name, err := validateName(line); err != nil {
+			res.Reason = err.Error()
+		} else {
+			res.Name = name
+		}
+		report.Results = append(report.Results, res)
+	}
+	if len(report.Results) == 0 {
+		app.renderHome(w, r, http.StatusUnprocessableEntity, homePage{Bulk: report, Error: "Enter at least one name."})
+		return
+	}
+
+	ctx := r.Context()
+	if report.DryRun {
+		ctx = store.DryRun(ctx)
+	}
+	ok, err := app.addBulk(ctx, report)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("bulk add users: %w", err))
+		return
+	}
+	if !ok {
+		app.renderHome(w, r, http.StatusUnprocessableEntity, homePage{Bulk: report, Error: "Nothing was added because some lines were rejected."})
+		return
+	}
+	if report.DryRun {
+		app.renderHome(w, r, http.StatusOK, homePage{Bulk: report})
+		return
+	}
+	for _, res := range report.Results {
+		if res.Added {
+			app.publish(r, UserCreated, store.User{ID: res.ID, Name: res.Name})
+		}
+	}
+	report.Input = ""
+	app.renderHome(w, r, http.StatusOK, homePage{Bulk: report})
+}
+
+// addBulk adds the valid entries of report, recording per-line outcomes. It
+// returns false when strict mode kept everything out.
+func (app *App) addBulk(ctx context.Context, report *bulkReport) (bool, error) {
+	var names []string
+	var lines []*bulkResult
+	for i := range report.Results {
+		res := &report.Results[i]
+		if res.Reason == "" {
+			names = append(names, res.Name)
+			lines = append(lines, res)
+		}
+	}
+	if report.Strict && len(names) < len(report.Results) {
+		return false, nil
+	}
+
+	results, err := app.users.CreateMany(ctx, names, report.Strict)
+	if err != nil {
+		return false, err
+	}
+	rejected := false
+	for i, res := range lines {
+		var invalid *store.ErrValidation
+		switch {
+		case errors.As(results[i].Err, &invalid):
+			res.Reason = invalid.Error()
+			rejected = true
+		case results[i].Err != nil:
+			res.Reason = "Conflicts with an existing user."
+			rejected = true
+		}
+	}
+	if rejected && report.Strict {
+		return false, nil
+	}
+	for i, res := range lines {
+		if res.Reason == "" {
+			res.Added, res.ID = true, results[i].ID
+			report.Added++
+		}
+	}
+	return true, nil
+}
+
+// usersListParams are the query parameters that select a GET /api/users
+// response, and so make up its cache key.
+var usersListParams = []string{"page", "q", "sort"}
+
+// handleGetUsers answers from usersCache when it can. X-Cache says whether
+// it did.
+func (app *App) handleGetUsers(w http.ResponseWriter, r *http.Request) {
+	key := url.Values{}
+	for _, p := range usersListParams {
+		if v, ok := r.URL.Query()[p]; ok {
+			key[p] = v
+		}
+	}
+	fill := func(ctx context.Context) ([]byte, error) {
+		users, err := app.users.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list users: %w", err)
+		}
+		body, err := json.Marshal(GetUsersResponse{Users: users})
+		if err != nil {
+			return nil, fmt.Errorf("encode users: %w", err)
+		}
+		return append(body, '\n'), nil
+	}
+	var (
+		body []byte
+		hit  bool
+		err  error
+	)
+	if app.usersCache != nil {
+		body, hit, err = app.usersCache.get(r.Context(), key.Encode(), fill)
+	} else {
+		body, err = fill(r.Context())
+	}
+	if err != nil {
+		app.respondError(w, r, err)
+		return
+	}
+	if app.usersCache != nil {
+		result := "miss"
+		if hit {
+			result = "hit"
+		}
+		app.metrics.cacheRequests.WithLabelValues("users", result).Inc()
+		w.Header().Set("X-Cache", strings.ToUpper(result))
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	// Polling clients revalidate with If-None-Match and get a bodyless 304
+	// while nothing changed.
+	writeWithETag(w, r, http.StatusOK, body)
+}
+
+// handleUser serves the detail page for /users/{id}.
+func (app *App) handleUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		app.notFound(w, r)
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), id)
+	var merged *store.ErrMerged
+	if errors.As(err, &merged) {
+		http.Redirect(w, r, "/users/"+strconv.Itoa(merged.Into), http.StatusMovedPermanently)
+		return
+	}
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("load user %d: %w", id, err))
+		return
+	}
+	app.render(w, r, "user", userPage{User: user})
+}
+
+// handleUsersFragment returns the next batch of table rows after the given
+// id for infinite scrolling. X-More tells the client whether to keep going.
+func (app *App) handleUsersFragment(w http.ResponseWriter, r *http.Request) {
+	after, err := strconv.Atoi(r.URL.Query().Get("after"))
+	if err != nil || after < 0 {
+		app.renderError(w, r, http.StatusBadRequest, "The after parameter must be a user id.")
+		return
+	}
+
+	users, more, err := app.users.ListPage(r.Context(), after, usersPageSize)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("list users: %w", err))
+		return
+	}
+	w.Header().Set("X-More", strconv.FormatBool(more))
+	app.renderPartial(w, r, "user_rows", users)
+}
+
+// formUserID parses the hidden "id" field posted by the row action forms.
+func formUserID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	return id, err == nil && id > 0
+}
+
+func (app *App) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	if !app.parseForm(w, r) {
+		return
+	}
+	id, ok := formUserID(r)
+	if !ok {
+		app.renderError(w, r, http.StatusBadRequest, "The form did not name a valid user.")
+		return
+	}
+	name, err := validateName(r.FormValue("name"))
+	if err != nil {
+		app.renderHome(w, r, http.StatusUnprocessableEntity, homePage{Error: err.Error()})
+		return
+	}
+
+	err = app.users.Update(r.Context(), id, name)
+	if errors.Is(err, store.ErrConflict) {
+		app.renderHome(w, r, http.StatusConflict, homePage{Error: "This user conflicts with an existing one."})
+		return
+	}
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("update user %d: %w", id, err))
+		return
+	}
+	app.publish(r, UserUpdated, store.User{ID: id, Name: name})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *App) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !app.parseForm(w, r) {
+		return
+	}
+	id, ok := formUserID(r)
+	if !ok {
+		app.renderError(w, r, http.StatusBadRequest, "The form did not name a valid user.")
+		return
+	}
+
+	undo, err := app.deleteUser(r, id)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("delete user %d: %w", id, err))
+		return
+	}
+	app.publish(r, UserDeleted, store.User{ID: id})
+	if undo != "" {
+		http.Redirect(w, r, "/?undo="+url.QueryEscape(undo), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
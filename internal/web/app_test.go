@@ -0,0 +1,132 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/store"
+)
+
+// Malformed requests from the homepage's own scripts and forms get the error
+// page like any other failure, not plain text.
+func TestBadUserRequests(t *testing.T) {
+	app, _ := newTestApp(t)
+	h := app.Handler()
+	post := func(path string, form url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	for _, tt := range []struct {
+		name    string
+		r       *http.Request
+		message string
+	}{
+		{"fragment", httptest.NewRequest(http.MethodGet, "/users/fragment?after=x", nil), "The after parameter must be a user id."},
+		{"negative fragment", httptest.NewRequest(http.MethodGet, "/users/fragment?after=-1", nil), "The after parameter must be a user id."},
+		{"update", post("/users/update", url.Values{"id": {"x"}, "name": {"Ada"}}), "The form did not name a valid user."},
+		{"delete", post("/users/delete", url.Values{}), "The form did not name a valid user."},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, tt.r)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("Content-Type = %q, want the HTML error page", ct)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, tt.message) || !strings.Contains(body, rec.Header().Get(RequestIDHeader)) {
+				t.Errorf("error page doesn't give %q and the request id:\n%s", tt.message, body)
+			}
+		})
+	}
+}
+
+// A user the JSON encoder rejects, here one created past year 9999, turns
+// into a clean 500 rather than a 200 with a cut-off body.
+func TestEncodeFailure(t *testing.T) {
+	clk := clock.NewFixed(time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC))
+	app, logs := newTestAppOn(t, store.NewMemoryStore(clk))
+	if _, err := app.users.Create(t.Context(), "Ada", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(app.Handler(), httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `"code":"internal"`) || strings.Contains(rec.Body.String(), "Ada") {
+		t.Errorf("GET /api/users = %d %s, want only a 500 JSON error", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Content-Length = %q for %d bytes", got, rec.Body.Len())
+	}
+	failed := logs.find("request failed")
+	if len(failed) != 1 || !strings.Contains(fmt.Sprint(failed[0]["error"]), "encode users") {
+		t.Errorf("logged failures = %v, want the encode error", failed)
+	}
+}
+
+type unencodable struct{}
+
+func (unencodable) MarshalJSON() ([]byte, error) { return nil, errors.New("no") }
+
+func TestWriteJSONFailure(t *testing.T) {
+	app, logs := newTestApp(t)
+	rec := httptest.NewRecorder()
+	app.writeJSON(rec, httptest.NewRequest(http.MethodGet, "/api/users/1", nil), http.StatusOK, unencodable{})
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `"code":"internal"`) {
+		t.Errorf("writeJSON of an unencodable value = %d %s, want a 500 JSON error", rec.Code, rec.Body)
+	}
+	if len(logs.find("failed to encode response")) != 1 {
+		t.Error("encode failure was not logged")
+	}
+}
+
+// So does a template that fails half-way through the page.
+func TestTemplateFailure(t *testing.T) {
+	app, logs := newTestApp(t)
+	rec := httptest.NewRecorder()
+	// The home page can't be rendered from an error page's data.
+	app.render(rec, httptest.NewRequest(http.MethodGet, "/", nil), "home", errorPage{})
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "<html") {
+		t.Errorf("failed render = %d with %d bytes of page, want a bare 500", rec.Code, rec.Body.Len())
+	}
+	if len(logs.find("failed to render template")) != 1 {
+		t.Errorf("render failure was not logged")
+	}
+}
+
+// A dry run answers exactly as the create would, but with id 0, and keeps
+// nothing.
+func TestCreateUserDryRun(t *testing.T) {
+	app, _ := newTestApp(t)
+	h := app.Handler()
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/users?validate=only", strings.NewReader(`{"name": "Ada", "email": "ADA@example.com"}`)),
+		httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"name": "Ada", "email": "ADA@example.com"}`)),
+	} {
+		r.Header.Set("Content-Type", "application/json")
+		if r.URL.RawQuery == "" {
+			r.Header.Set("X-Dry-Run", "true")
+		}
+		rec := serve(h, r)
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusCreated || body["id"] != float64(0) || body["name"] != "Ada" || body["email"] != "ada@example.com" || body["created_at"] == nil {
+			t.Errorf("dry run %s = %d %s, want 201 with the user and id 0", r.URL, rec.Code, rec.Body)
+		}
+	}
+	if n, err := app.users.Count(t.Context()); err != nil || n != 0 {
+		t.Errorf("Count after dry runs = %d, %v; want 0", n, err)
+	}
+}
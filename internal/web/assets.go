@@ -0,0 +1,94 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"exam/internal/clock"
+)
+
+//go:embed static
+var staticFS embed.FS
+
+const (
+	staticPrefix        = "/static/"
+	assetHashLength     = 12
+	hashedAssetMaxAge   = "public, max-age=31536000, immutable"
+	unhashedAssetMaxAge = "public, max-age=300"
+)
+
+// assetManifest maps embedded static files to content-hashed URLs so they can
+// be cached forever and still change on every deploy that touches them.
+type assetManifest struct {
+	hashed  map[string]string // "app.css" -> "app.3f2a9c01d4e5.css"
+	files   map[string]string // "app.3f2a9c01d4e5.css" -> "app.css"
+	content map[string][]byte // "app.css" -> file contents
+	modTime time.Time
+}
+
+func newAssetManifest(clk clock.Clock) (*assetManifest, error) {
+	m := &assetManifest{
+		hashed:  map[string]string{},
+		files:   map[string]string{},
+		content: map[string][]byte{},
+		modTime: clk.Now(),
+	}
+	err := fs.WalkDir(staticFS, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := staticFS.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", p, err)
+		}
+		name := strings.TrimPrefix(p, "static/")
+		sum := sha256.Sum256(data)
+		ext := path.Ext(name)
+		hashedName := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:assetHashLength] + ext
+
+		m.hashed[name] = hashedName
+		m.files[hashedName] = name
+		m.content[name] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// URL returns the hashed URL for the named asset. Unknown names fall back to
+// the plain path so a typo shows up as a 404 rather than a template error.
+func (m *assetManifest) URL(name string) string {
+	if hashedName, ok := m.hashed[name]; ok {
+		return staticPrefix + hashedName
+	}
+	return staticPrefix + name
+}
+
+// ServeHTTP serves hashed names with immutable caching and plain names with a
+// short max-age. A hashed-looking name whose hash doesn't match the current
+// content is a 404 rather than the current file.
+func (m *assetManifest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requested := strings.TrimPrefix(r.URL.Path, staticPrefix)
+
+	name, cacheControl := m.files[requested], hashedAssetMaxAge
+	if name == "" {
+		if _, ok := m.content[requested]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		name, cacheControl = requested, unhashedAssetMaxAge
+	}
+
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, name, m.modTime, bytes.NewReader(m.content[name]))
+}
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireInternalAuth guards operational endpoints with a shared bearer
+// token (INTERNAL_API_TOKEN). When no token is configured the endpoints are
+// disabled entirely and answer 404, so they can't be left open by accident.
+func (app *App) requireInternalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.internalToken == "" {
+			app.notFound(w, r)
+			return
+		}
+		if !app.hasInternalToken(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="internal"`)
+			writeJSONError(w, r, http.StatusUnauthorized, "unauthorized", "A valid internal API token is required.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// hasInternalToken reports whether r carries the internal bearer token.
+func (app *App) hasInternalToken(r *http.Request) bool {
+	if app.internalToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(app.internalToken)) == 1
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"exam/internal/store"
+)
+
+type restoreResponse struct {
+	Tables map[string]int `json:"tables"`
+}
+
+// handleBackup streams a backup of every table the app owns; see
+// store.Backups. The response can take as long as the tables do, so it
+// has no server write deadline. Once the first bytes are out an error
+// can't change the status any more, so the response is aborted instead,
+// and the client sees a failed download rather than a 200 whose document
+// merely ends early.
+func (app *App) handleBackup(w http.ResponseWriter, r *http.Request) {
+	b, ok := app.users.(store.Backups)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotImplemented, "unsupported", "Backups need the Postgres store.")
+		return
+	}
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	name := "backup-" + app.clock.Now().UTC().Format("20060102T150405Z") + ".json"
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	cw := &countingWriter{w: w}
+	if err := b.WriteBackup(r.Context(), cw); err != nil {
+		err = fmt.Errorf("write backup: %w", err)
+		if cw.n == 0 {
+			app.respondError(w, r, err)
+			return
+		}
+		app.requestLogger(r).Error("backup cut short", "error", err, "bytes", cw.n)
+		panic(http.ErrAbortHandler)
+	}
+	app.requestLogger(r).Info("backup written")
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// handleRestore replaces every table the app owns with the backup in the
+// request body, which is read as it is applied, so neither the body limit
+// nor the server deadlines apply. ?confirm= must name the database, so a
+// restore meant for one environment can't land on another. Switching on
+// maintenance mode first keeps writes from landing in between.
+func (app *App) handleRestore(w http.ResponseWriter, r *http.Request) {
+	b, ok := app.users.(store.Backups)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotImplemented, "unsupported", "Restores need the Postgres store.")
+		return
+	}
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	loaded, err := b.Restore(r.Context(), r.Body, r.URL.Query().Get("confirm"))
+	switch {
+	case errors.Is(err, store.ErrRestoreUnconfirmed):
+		writeJSONError(w, r, http.StatusBadRequest, "confirmation_required", "Pass the target database's name as ?confirm= to restore over it.")
+		return
+	case errors.Is(err, store.ErrBackupVersion), errors.Is(err, store.ErrInvalidBackup):
+		code := "invalid_backup"
+		if errors.Is(err, store.ErrBackupVersion) {
+			code = "schema_mismatch"
+		}
+		writeJSONError(w, r, http.StatusUnprocessableEntity, code, err.Error())
+		return
+	case err != nil:
+		app.respondError(w, r, fmt.Errorf("restore backup: %w", err))
+		return
+	}
+	if app.usersCache != nil {
+		app.usersCache.invalidate(r.Context())
+	}
+	app.requestLogger(r).Warn("backup restored", "tables", loaded)
+	app.writeJSON(w, r, http.StatusOK, restoreResponse{Tables: loaded})
+}
@@ -0,0 +1,57 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"exam/internal/clock"
+	"exam/internal/store"
+)
+
+// failingBackups writes prefix of a backup, then fails.
+type failingBackups struct {
+	store.UserStore
+	prefix string
+}
+
+func (b failingBackups) WriteBackup(ctx context.Context, w io.Writer) error {
+	if b.prefix != "" {
+		io.WriteString(w, b.prefix)
+	}
+	return store.ErrUnavailable
+}
+
+func (failingBackups) Restore(context.Context, io.Reader, string) (map[string]int, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// A backup that fails before anything is written gets an error response;
+// one that fails partway is aborted, since its 200 is already out.
+func TestBackupFailure(t *testing.T) {
+	users := store.NewMemoryStore(clock.System)
+
+	app, _ := newTestAppOn(t, failingBackups{UserStore: users})
+	rec := httptest.NewRecorder()
+	app.handleBackup(rec, httptest.NewRequest(http.MethodGet, "/_internal/backup", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("backup failing up front = %d, want 503", rec.Code)
+	}
+
+	app, logs := newTestAppOn(t, failingBackups{UserStore: users, prefix: `{"schema_version":12,`})
+	rec = httptest.NewRecorder()
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("backup failing partway panicked with %v, want http.ErrAbortHandler", v)
+			}
+		}()
+		app.handleBackup(rec, httptest.NewRequest(http.MethodGet, "/_internal/backup", nil))
+	}()
+	if cut := logs.find("backup cut short"); len(cut) != 1 || cut[0]["bytes"] != float64(21) {
+		t.Errorf("logged %v, want one backup cut short after 21 bytes", cut)
+	}
+}
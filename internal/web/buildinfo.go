@@ -0,0 +1,84 @@
+package web
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"exam/internal/config"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X exam/internal/web.version=1.2.3 -X exam/internal/web.commit=$(git rev-parse HEAD) -X exam/internal/web.buildDate=$(date -u +%FT%TZ)"
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildDate = "dev"
+)
+
+// BuildInfo identifies the running binary. It is served at /version, embedded
+// in the health document, and available to templates.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Module    string `json:"module"`
+}
+
+// Build identifies this binary.
+var Build = readBuildInfo()
+
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   orDev(version),
+		Commit:    orDev(commit),
+		BuildDate: orDev(buildDate),
+		GoVersion: runtime.Version(),
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Module = bi.Main.Path
+	// Fall back to the VCS stamp the go command embeds when ldflags weren't
+	// used, e.g. for a plain `go build` in a checkout.
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "dev":
+			info.Commit = s.Value
+		case s.Key == "vcs.time" && info.BuildDate == "dev":
+			info.BuildDate = s.Value
+		}
+	}
+	return info
+}
+
+func orDev(s string) string {
+	if s == "" {
+		return "dev"
+	}
+	return s
+}
+
+// Branding is the deployment's config.Branding as /version, the health
+// document and templates see it, so instances can be told apart.
+type Branding struct {
+	Title       string `json:"title"`
+	AccentColor string `json:"accent_color"`
+	LogoURL     string `json:"logo_url,omitempty"`
+}
+
+func newBranding(b config.Branding) Branding {
+	return Branding{Title: b.Title, AccentColor: b.AccentColor, LogoURL: b.LogoURL}
+}
+
+type versionResponse struct {
+	BuildInfo
+	Branding Branding `json:"branding"`
+}
+
+func (app *App) handleVersion(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, r, http.StatusOK, versionResponse{BuildInfo: Build, Branding: app.branding})
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const noStore = "no-store"
+
+// routeCachePolicies is the Cache-Control of each class of route; the first
+// matching prefix wins. An empty policy leaves the header to the handler,
+// as static assets do (immutable when hashed, short-lived otherwise).
+// Handlers can override their route's policy by setting the header
+// themselves. Only GET and HEAD responses are ever cacheable.
+var routeCachePolicies = []struct {
+	prefix string
+	policy string
+}{
+	{staticPrefix, ""},
+	{"/api/users", "private, max-age=5"},
+	// Today's count is live, but a minute stale is fine for a chart.
+	{"/api/stats", "private, max-age=60"},
+	{"/version", "no-cache"},
+	{"/_internal/", noStore},
+	{"/metrics", noStore},
+	// HTML pages carry per-user content and flash messages.
+	{"/", noStore},
+}
+
+func cachePolicyFor(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return noStore
+	}
+	for _, rc := range routeCachePolicies {
+		if strings.HasPrefix(r.URL.Path, rc.prefix) {
+			return rc.policy
+		}
+	}
+	return noStore
+}
+
+// cacheControl sets the route's default Cache-Control before the handler
+// runs, so middleware error responses get it too.
+func (app *App) cacheControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if policy := cachePolicyFor(r); policy != "" {
+			w.Header().Set("Cache-Control", policy)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeWithETag writes body with a strong ETag derived from it, or a bare
+// 304 when the client already has that version.
+func writeWithETag(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if status == http.StatusOK && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// notModified sets etag and, unless zero, modified as the response's
+// validators, and writes a bare 304 when the request's show the client
+// already has this version. As RFC 9110 says, If-Modified-Since only
+// counts without If-None-Match, and only to the second; it can't see
+// deletes, so it is the weaker check of the two.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	var match bool
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		match = etagMatches(inm, etag)
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() {
+		match = !modified.Truncate(time.Second).After(since)
+	}
+	if match {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return match
+}
+
+// etagMatches implements If-None-Match's weak comparison against etag.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
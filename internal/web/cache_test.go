@@ -0,0 +1,111 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/store"
+)
+
+func TestHomeConditionalGet(t *testing.T) {
+	clk := clock.NewFixed(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	app, _ := newTestAppOn(t, store.NewMemoryStore(clk), WithClock(clk))
+	h := app.Handler()
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		return serve(h, r)
+	}
+
+	if _, err := app.users.Create(t.Context(), "Ada", ""); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(10 * time.Second)
+	first := get("/")
+	etag, modified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if first.Code != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("GET / = %d with ETag %q and Last-Modified %q, want 200 with both", first.Code, etag, modified)
+	}
+	if !strings.Contains(first.Body.String(), "just now") {
+		t.Error("the user's age isn't told by the app's clock")
+	}
+	if rec := get("/", "If-None-Match", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidating with the ETag = %d, want a bare 304", rec.Code)
+	}
+	if rec := get("/", "If-Modified-Since", modified); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidating with Last-Modified = %d, want 304", rec.Code)
+	}
+	// The undo link is for the visitor who deleted, never from the cache.
+	if rec := get("/?undo=x", "If-None-Match", etag); rec.Code != http.StatusOK {
+		t.Errorf("GET with an undo = %d, want 200", rec.Code)
+	}
+
+	// A minute on, the user's age reads differently: both validators must
+	// say so, not just the ETag.
+	clk.Advance(time.Minute)
+	if rec := get("/", "If-None-Match", etag); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "1 minute ago") {
+		t.Errorf("revalidating a page whose ages moved on with the ETag = %d, want 200", rec.Code)
+	}
+	if rec := get("/", "If-Modified-Since", modified); rec.Code != http.StatusOK {
+		t.Errorf("revalidating a page whose ages moved on with Last-Modified = %d, want 200", rec.Code)
+	}
+
+	rec := get("/")
+	etag, modified = rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if _, err := app.users.Create(t.Context(), "Grace", ""); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/", "If-None-Match", etag); rec.Code != http.StatusOK {
+		t.Errorf("revalidating after a user was added = %d, want 200", rec.Code)
+	}
+}
+
+// Every class of route ships with a caching policy; a new handler that
+// falls in one of these gets it without doing anything.
+func TestCachePolicies(t *testing.T) {
+	app, _ := newTestApp(t)
+	h := app.Handler()
+	for _, tc := range []struct {
+		method, path string
+		want         string
+	}{
+		{http.MethodGet, "/", "private, no-cache"},
+		{http.MethodGet, "/trash", noStore},
+		{http.MethodGet, "/users/fragment", noStore},
+		{http.MethodGet, "/version", "no-cache"},
+		{http.MethodGet, "/api/users", "private, max-age=5"},
+		{http.MethodGet, "/api/stats", "private, max-age=60"},
+		{http.MethodGet, "/_internal/livez", noStore},
+		{http.MethodGet, "/_internal/readyz", noStore},
+		{http.MethodGet, "/metrics", noStore},
+		{http.MethodGet, app.assets.URL("app.css"), hashedAssetMaxAge},
+		{http.MethodGet, staticPrefix + "app.css", unhashedAssetMaxAge},
+		{http.MethodPost, "/api/users", noStore},
+		{http.MethodPost, "/users/delete", noStore},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}"))
+		r.Header.Set("Content-Type", "application/json")
+		rec := serve(h, r)
+		if got := rec.Header().Get("Cache-Control"); got != tc.want {
+			t.Errorf("%s %s: Cache-Control %q, want %q", tc.method, tc.path, got, tc.want)
+		}
+	}
+
+	// /api/users also revalidates with its ETag.
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET /api/users has no ETag")
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	r.Header.Set("If-None-Match", etag)
+	if rec := serve(h, r); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("GET /api/users with its ETag = %d with %d bytes, want a bare 304", rec.Code, rec.Body.Len())
+	}
+}
@@ -0,0 +1,17 @@
+package web
+
+import "net/http"
+
+// Middleware wraps a handler in behaviour of its own.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes mw into one Middleware, the first outermost:
+// Chain(a, b)(h) is a(b(h)).
+func Chain(mw ...Middleware) Middleware {
+	return func(h http.Handler) http.Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			h = mw[i](h)
+		}
+		return h
+	}
+}
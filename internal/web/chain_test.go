@@ -0,0 +1,95 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" in")
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" out")
+			})
+		}
+	}
+	h := Chain(mw("a"), Chain(mw("b"), mw("c")))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		calls = append(calls, "handler")
+	}))
+	serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	want := []string{"a in", "b in", "c in", "handler", "c out", "b out", "a out"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+type panickingIDs struct{}
+
+func (panickingIDs) NewID() string { panic("no ids today") }
+
+// A panic in the request id middleware can only be caught if recovery is
+// outside it.
+func TestRecoveryIsOutermost(t *testing.T) {
+	app, logs := newTestApp(t, WithIDGenerator(panickingIDs{}))
+	defer func() {
+		if v := recover(); v != nil {
+			t.Fatalf("panic escaped the middleware: %v", v)
+		}
+	}()
+
+	rec := serve(app.Handler(), httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if len(logs.find("panic recovered")) != 1 {
+		t.Error("panic was not logged")
+	}
+}
+
+// A probe request that panics in its handler shows the rest of the order:
+// the request id is assigned inside recovery, and the access log and
+// latency metrics, inside that, still record the 500 it ends in.
+func TestBaseStackOrder(t *testing.T) {
+	app, logs := newTestApp(t)
+	h := app.Handler()
+	app.mux.HandleFunc("GET /api/probe", func(http.ResponseWriter, *http.Request) { panic("probe") })
+
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/api/probe", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if id := rec.Header().Get(RequestIDHeader); id != "req-1" {
+		t.Errorf("%s = %q, want req-1", RequestIDHeader, id)
+	}
+	var body errorEnvelope
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.RequestID != "req-1" {
+		t.Errorf("request id in the error = %q, want req-1", body.Error.RequestID)
+	}
+
+	recovered := logs.find("panic recovered")
+	if len(recovered) != 1 || recovered[0]["request_id"] != "req-1" {
+		t.Errorf("recovery logged %v, want one line with request id req-1", recovered)
+	}
+	access := logs.find("request")
+	if len(access) != 1 || access[0]["status"] != float64(http.StatusInternalServerError) || access[0]["request_id"] != "req-1" {
+		t.Errorf("access log = %v, want one line with status 500 and request id req-1", access)
+	}
+	if n := testutil.CollectAndCount(app.metrics.requestDuration); n != 1 {
+		t.Errorf("request duration has %d series, want 1", n)
+	}
+	if n := testutil.ToFloat64(app.metrics.panics); n != 1 {
+		t.Errorf("panics = %v, want 1", n)
+	}
+}
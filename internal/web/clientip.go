@@ -0,0 +1,55 @@
+package web
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIP returns the address of the client that made the request. The
+// X-Forwarded-For and X-Real-IP headers are only honoured when the immediate
+// peer is a trusted proxy; X-Forwarded-For is then walked from the right,
+// skipping trusted hops, so a client can't spoof its address by prepending
+// entries.
+func (app *App) clientIP(r *http.Request) string {
+	peer := remoteHost(r)
+	if !app.isTrustedProxy(peer) {
+		return peer
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				// Garbage in the chain: stop at the last hop we could trust.
+				return peer
+			}
+			if !app.isTrustedProxy(hop) {
+				return hop
+			}
+			peer = hop
+		}
+		return peer
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+		if _, err := netip.ParseAddr(real); err == nil {
+			return real
+		}
+	}
+	return peer
+}
+
+func (app *App) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range app.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
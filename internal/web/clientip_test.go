@@ -0,0 +1,85 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	cfg := testConfig()
+	cfg.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.1/32")}
+	app, _ := newTestApp(t, WithConfig(cfg))
+
+	for _, tc := range []struct {
+		name   string
+		peer   string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "", "203.0.113.7"},
+		{"untrusted peer spoofing XFF", "203.0.113.7:4000", []string{"1.2.3.4"}, "", "203.0.113.7"},
+		{"untrusted peer spoofing X-Real-IP", "203.0.113.7:4000", nil, "1.2.3.4", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:4000", []string{"198.51.100.9"}, "", "198.51.100.9"},
+		{"chain of trusted proxies", "10.0.0.1:4000", []string{"198.51.100.9, 192.168.1.1, 10.2.3.4"}, "", "198.51.100.9"},
+		{"client prepending a spoofed hop", "10.0.0.1:4000", []string{"1.2.3.4, 198.51.100.9"}, "", "198.51.100.9"},
+		{"chain split over headers", "10.0.0.1:4000", []string{"1.2.3.4", "198.51.100.9, 10.2.3.4"}, "", "198.51.100.9"},
+		{"only trusted hops", "10.0.0.1:4000", []string{"10.9.9.9, 192.168.1.1"}, "", "10.9.9.9"},
+		{"garbage hop", "10.0.0.1:4000", []string{"198.51.100.9, not-an-ip, 10.2.3.4"}, "", "10.2.3.4"},
+		{"X-Real-IP from a trusted proxy", "10.0.0.1:4000", nil, "198.51.100.9", "198.51.100.9"},
+		{"invalid X-Real-IP", "10.0.0.1:4000", nil, "nope", "10.0.0.1"},
+		{"XFF before X-Real-IP", "10.0.0.1:4000", []string{"198.51.100.9"}, "1.2.3.4", "198.51.100.9"},
+		{"IPv4-mapped trusted peer", "[::ffff:10.0.0.1]:4000", []string{"198.51.100.9"}, "", "198.51.100.9"},
+		{"IPv6 client", "10.0.0.1:4000", []string{"2001:db8::1"}, "", "2001:db8::1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.peer
+		for _, v := range tc.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := app.clientIP(r); got != tc.want {
+			t.Errorf("%s: clientIP = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// The access log and the rate limiter see the same client as clientIP.
+func TestClientIPUsers(t *testing.T) {
+	cfg := testConfig()
+	cfg.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	cfg.RateLimit = 1
+	cfg.RateLimitWindow = time.Minute
+	app, logs := newTestApp(t, WithConfig(cfg))
+	h := app.rateLimited("test", func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(client string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/version", nil)
+		r.RemoteAddr = "10.0.0.1:4000"
+		r.Header.Set("X-Forwarded-For", client)
+		return r
+	}
+	if rec := serve(h, request("198.51.100.1")); rec.Code != http.StatusOK {
+		t.Fatalf("first client = %d, want 200", rec.Code)
+	}
+	if rec := serve(h, request("198.51.100.2")); rec.Code != http.StatusOK {
+		t.Errorf("second client behind the same proxy = %d, want its own limit", rec.Code)
+	}
+	if rec := serve(h, request("198.51.100.1")); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("first client again = %d, want 429", rec.Code)
+	}
+
+	serve(app.Handler(), request("198.51.100.3"))
+	var found bool
+	for _, rec := range logs.find("request") {
+		found = found || rec["remote_addr"] == "198.51.100.3"
+	}
+	if !found {
+		t.Error("access log has no request from 198.51.100.3")
+	}
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"exam/internal/reqctx"
+)
+
+// dbFreePaths lists path prefixes that never touch the database and must
+// keep answering under load or during an outage, health probes above all.
+var dbFreePaths = []string{"/_internal/", "/static/", "/metrics", "/version"}
+
+// touchesDB reports whether requests to path may use the database.
+func touchesDB(path string) bool {
+	for _, prefix := range dbFreePaths {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// concurrencyLimit caps in-flight requests so a spike queues briefly in
+// front of the pgx pool instead of piling onto it. A zero limit disables it.
+type concurrencyLimit struct {
+	slots chan struct{}
+	queue time.Duration
+}
+
+func newConcurrencyLimit(n int, queue time.Duration) concurrencyLimit {
+	if n <= 0 {
+		return concurrencyLimit{}
+	}
+	return concurrencyLimit{slots: make(chan struct{}, n), queue: queue}
+}
+
+// limitConcurrency waits up to the queue timeout for a free slot and answers
+// 503 with Retry-After when none frees up in time.
+func (app *App) limitConcurrency(next http.Handler) http.Handler {
+	slots := app.concurrency.slots
+	if slots == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !touchesDB(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), app.concurrency.queue)
+		select {
+		case slots <- struct{}{}:
+			cancel()
+		case <-ctx.Done():
+			cancel()
+			if r.Context().Err() != nil {
+				return // client gave up while queued
+			}
+			app.metrics.concurrencyRejected.Inc()
+			w.Header().Set("Retry-After", "1")
+			if isAPIRequest(r) {
+				writeJSONError(w, r, http.StatusServiceUnavailable, "overloaded", "The server is busy. Please retry shortly.")
+				return
+			}
+			app.renderError(w, r, http.StatusServiceUnavailable, "We're handling a lot of requests right now. Please try again in a moment.")
+			return
+		}
+
+		if dl, ok := reqctx.DebugLogger(r.Context()); ok {
+			dl.Debug("acquired concurrency slot", "request_id", reqctx.RequestID(r.Context()), "in_flight", len(slots), "limit", cap(slots))
+		}
+		app.metrics.inFlight.Inc()
+		defer func() {
+			app.metrics.inFlight.Dec()
+			<-slots
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"exam/internal/store"
+)
+
+// guardDB short-circuits database-bound requests with a 503 while the
+// breaker is open, so an outage isn't amplified by every request burning
+// its full timeout on the pool.
+func (app *App) guardDB(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.db == nil || !touchesDB(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !app.db.Ready() {
+			w.Header().Set("Retry-After", readyRetryAfter)
+			if isAPIRequest(r) {
+				writeJSONError(w, r, http.StatusServiceUnavailable, "db_connecting", "The service is starting up and not connected to its database yet.")
+				return
+			}
+			app.renderError(w, r, http.StatusServiceUnavailable, "We're still starting up. Please try again in a few seconds.")
+			return
+		}
+		ok, wait := app.breaker.Allow()
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		if isAPIRequest(r) {
+			writeJSONError(w, r, http.StatusServiceUnavailable, "db_unavailable", "The database is temporarily unavailable.")
+			return
+		}
+		app.renderError(w, r, http.StatusServiceUnavailable, "We can't reach our database right now. Please try again in a few seconds.")
+	})
+}
+
+// checkSchema is the readiness check for the schema.
+func (app *App) checkSchema(ctx context.Context) checkResult {
+	problem, err := app.db.CheckSchema(ctx)
+	if err != nil {
+		return checkResult{Status: "fail", Error: err.Error(), Reason: store.FailureReason(err)}
+	}
+	if problem != "" {
+		return checkResult{Status: "fail", Error: problem, Reason: "schema_behind"}
+	}
+	return checkResult{Status: "ok"}
+}
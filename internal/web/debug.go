@@ -0,0 +1,81 @@
+package web
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	debugPrefix = "/_internal/debug/"
+)
+
+type debugVars struct {
+	Goroutines   int       `json:"goroutines"`
+	NumCPU       int       `json:"num_cpu"`
+	HeapAlloc    uint64    `json:"heap_alloc_bytes"`
+	HeapInuse    uint64    `json:"heap_inuse_bytes"`
+	HeapObjects  uint64    `json:"heap_objects"`
+	Sys          uint64    `json:"sys_bytes"`
+	NumGC        uint32    `json:"num_gc"`
+	PauseTotalMs float64   `json:"gc_pause_total_ms"`
+	RecentPauses []float64 `json:"gc_recent_pauses_ms"`
+	LastGC       time.Time `json:"last_gc"`
+}
+
+// debugMux builds the internal debug routes: runtime vars always, and the
+// pprof handlers only when ENABLE_PPROF=true. Every route requires the
+// internal token.
+func (app *App) debugMux(enablePprof bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugPrefix+"vars", app.requireInternalAuth(app.handleDebugVars))
+
+	if enablePprof {
+		mux.HandleFunc(debugPrefix+"pprof/", app.requireInternalAuth(pprofIndex))
+		mux.HandleFunc(debugPrefix+"pprof/cmdline", app.requireInternalAuth(pprof.Cmdline))
+		mux.HandleFunc(debugPrefix+"pprof/profile", app.requireInternalAuth(pprof.Profile))
+		mux.HandleFunc(debugPrefix+"pprof/symbol", app.requireInternalAuth(pprof.Symbol))
+		mux.HandleFunc(debugPrefix+"pprof/trace", app.requireInternalAuth(pprof.Trace))
+		app.logger.Warn("pprof endpoints enabled", "prefix", debugPrefix+"pprof/")
+	}
+	return mux
+}
+
+// pprofIndex serves pprof.Index, which only understands paths under
+// /debug/pprof/, for our prefixed location.
+func pprofIndex(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, debugPrefix+"pprof/")
+	if name != "" {
+		pprof.Handler(name).ServeHTTP(w, r)
+		return
+	}
+	pprof.Index(w, r)
+}
+
+func (app *App) handleDebugVars(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	vars := debugVars{
+		Goroutines:   runtime.NumGoroutine(),
+		NumCPU:       runtime.NumCPU(),
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		HeapObjects:  ms.HeapObjects,
+		Sys:          ms.Sys,
+		NumGC:        ms.NumGC,
+		PauseTotalMs: float64(ms.PauseTotalNs) / 1e6,
+		RecentPauses: []float64{},
+	}
+	if ms.LastGC > 0 {
+		vars.LastGC = time.Unix(0, int64(ms.LastGC)).UTC()
+	}
+	// PauseNs is a circular buffer; walk back from the most recent GC.
+	for i := uint32(0); i < ms.NumGC && i < 10; i++ {
+		idx := (ms.NumGC - 1 - i) % uint32(len(ms.PauseNs))
+		vars.RecentPauses = append(vars.RecentPauses, float64(ms.PauseNs[idx])/1e6)
+	}
+	app.writeJSON(w, r, http.StatusOK, vars)
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+
+	"exam/internal/reqctx"
+)
+
+const (
+	// DebugHeader asks for debug logging of one request. It is honored with
+	// the internal bearer token or when its value is DEBUG_TOKEN, and
+	// DebugActiveHeader in the response confirms it took effect.
+	DebugHeader       = "X-Debug"
+	DebugActiveHeader = "X-Debug-Active"
+)
+
+// debugHandler logs every level regardless of the global LOG_LEVEL. It
+// backs the logger of a request that asked for debug logging.
+type debugHandler struct{ slog.Handler }
+
+func (debugHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h debugHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return debugHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h debugHandler) WithGroup(name string) slog.Handler {
+	return debugHandler{h.Handler.WithGroup(name)}
+}
+
+// debugRequests elevates a request's logger to debug level when it carries
+// an authorized X-Debug header, so one request can be traced without
+// turning on debug logging for everyone. Unauthorized headers are ignored.
+func (app *App) debugRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.debugAllowed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger := slog.New(debugHandler{app.logger.Handler()}).With("debug", true)
+		w.Header().Set(DebugActiveHeader, "true")
+		logger.Debug("debug logging enabled for request",
+			"request_id", reqctx.RequestID(r.Context()),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", app.clientIP(r),
+		)
+		next.ServeHTTP(w, r.WithContext(reqctx.WithDebugLogger(r.Context(), logger)))
+	})
+}
+
+func (app *App) debugAllowed(r *http.Request) bool {
+	v := r.Header.Get(DebugHeader)
+	if v == "" {
+		return false
+	}
+	if app.debugToken != "" && subtle.ConstantTimeCompare([]byte(v), []byte(app.debugToken)) == 1 {
+		return true
+	}
+	return app.hasInternalToken(r)
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"exam/internal/store"
+)
+
+// respondError answers a request that failed with err, picking the status
+// from the store's errors: 404, 409, 422, 503 with Retry-After, and 500 for
+// anything else. The client gets a fixed message; the error itself only
+// goes to the logs.
+func (app *App) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	reply := func(status int, code, message string) {
+		if isAPIRequest(r) {
+			writeJSONError(w, r, status, code, message)
+			return
+		}
+		app.renderError(w, r, status, message)
+	}
+
+	var invalid *store.ErrValidation
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		reply(http.StatusNotFound, "not_found", "User not found.")
+	case errors.Is(err, store.ErrConflict):
+		reply(http.StatusConflict, "conflict", "This user conflicts with an existing one.")
+	case errors.As(err, &invalid):
+		reply(http.StatusUnprocessableEntity, "validation_failed", invalid.Error())
+	case store.IsStatementTimeout(err):
+		app.metrics.statementTimeouts.Inc()
+		app.requestLogger(r).Warn("statement timed out", "error", err)
+		w.Header().Set("Retry-After", readyRetryAfter)
+		if isAPIRequest(r) {
+			writeJSONError(w, r, http.StatusServiceUnavailable, "query_timeout", "The query timed out.")
+			return
+		}
+		app.renderError(w, r, http.StatusServiceUnavailable, "This is taking longer than it should. Please try again in a moment.")
+	case errors.Is(err, store.ErrUnavailable):
+		app.requestLogger(r).Warn("database unavailable", "error", err)
+		w.Header().Set("Retry-After", readyRetryAfter)
+		if isAPIRequest(r) {
+			writeJSONError(w, r, http.StatusServiceUnavailable, "db_unavailable", "The database is temporarily unavailable.")
+			return
+		}
+		app.renderError(w, r, http.StatusServiceUnavailable, "We can't reach our database right now. Please try again in a few seconds.")
+	default:
+		app.requestLogger(r).Error("request failed", "error", err)
+		reply(http.StatusInternalServerError, "internal", "Something went wrong on our side.")
+	}
+}
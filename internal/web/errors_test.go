@@ -0,0 +1,48 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"exam/internal/store"
+)
+
+// respondError finds the store's error however deep the wrapping between
+// the driver and the handler.
+func TestRespondError(t *testing.T) {
+	app, _ := newTestApp(t)
+	unique := &pgconn.PgError{Code: "23505", ConstraintName: "users_name_live"}
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("create user: %w", fmt.Errorf("%w: %w", store.ErrConflict, unique)), http.StatusConflict, "conflict"},
+		{fmt.Errorf("get user 3: %w", &store.ErrMerged{Into: 1}), http.StatusNotFound, "not_found"},
+		{fmt.Errorf("create user: %w", &store.ErrValidation{Field: "name", Reason: "is too long"}), http.StatusUnprocessableEntity, "validation_failed"},
+		{fmt.Errorf("list users: %w", &pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"}), http.StatusServiceUnavailable, "query_timeout"},
+		// A client that went away cancels its query with the same code.
+		{fmt.Errorf("list users: %w", &pgconn.PgError{Code: "57014", Message: "canceling statement due to user request"}), http.StatusInternalServerError, "internal"},
+		{fmt.Errorf("list users: %w", fmt.Errorf("%w: dial tcp: refused", store.ErrUnavailable)), http.StatusServiceUnavailable, "db_unavailable"},
+		{fmt.Errorf("list users: %w", unique), http.StatusInternalServerError, "internal"},
+	} {
+		rec := httptest.NewRecorder()
+		app.respondError(rec, httptest.NewRequest(http.MethodGet, "/api/users", nil), tc.err)
+		var body struct {
+			Error struct{ Code string } `json:"error"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != tc.status || body.Error.Code != tc.code {
+			t.Errorf("respondError(%v) = %d %q, want %d %q", tc.err, rec.Code, body.Error.Code, tc.status, tc.code)
+		}
+	}
+	if n := testutil.ToFloat64(app.metrics.statementTimeouts); n != 1 {
+		t.Errorf("statement timeouts counted = %v, want 1", n)
+	}
+}
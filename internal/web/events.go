@@ -0,0 +1,107 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"exam/internal/reqctx"
+	"exam/internal/store"
+)
+
+const (
+	// eventQueueSize is how many events each notifier may fall behind by
+	// before new ones are dropped for it.
+	eventQueueSize = 256
+	// notifyTimeout bounds a single Notify call.
+	notifyTimeout = 10 * time.Second
+)
+
+type EventType string
+
+const (
+	UserCreated EventType = "user.created"
+	UserUpdated EventType = "user.updated"
+	UserDeleted EventType = "user.deleted"
+)
+
+// Event is one change to a user. A deleted user's event carries only its
+// id.
+type Event struct {
+	Type      EventType  `json:"type"`
+	User      store.User `json:"user"`
+	At        time.Time  `json:"at"`
+	RequestID string     `json:"request_id,omitempty"`
+}
+
+// Notifier passes events on to somewhere outside the app, such as a
+// webhook or connected browsers. Notify is called from the notifier's own
+// goroutine, one event at a time, in the order they were published.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// eventBus fans each event out to every notifier. Notifiers each have a
+// queue and a goroutine, so a slow or failing one delays neither the
+// request that published nor the other notifiers.
+type eventBus struct {
+	subscribers []subscriber
+}
+
+type subscriber struct {
+	name  string
+	queue chan Event
+}
+
+// subscribe registers n under name. It must be called before the App
+// serves requests.
+func (app *App) subscribe(name string, n Notifier) {
+	queue := make(chan Event, eventQueueSize)
+	app.events.subscribers = append(app.events.subscribers, subscriber{name: name, queue: queue})
+	logger := app.logger.With("notifier", name)
+	app.goBackground("notifier "+name, func(ctx context.Context) {
+		for {
+			select {
+			case e := <-queue:
+				deliver(ctx, logger, n, e)
+			case <-ctx.Done():
+				// Hand over what was already published before stopping;
+				// Shutdown bounds how long that may take.
+				for {
+					select {
+					case e := <-queue:
+						deliver(context.WithoutCancel(ctx), logger, n, e)
+					default:
+						return
+					}
+				}
+			}
+		}
+	})
+}
+
+func deliver(ctx context.Context, logger *slog.Logger, n Notifier, e Event) {
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	if err := n.Notify(ctx, e); err != nil {
+		logger.Warn("notifier failed", "event", e.Type, "user_id", e.User.ID, "error", err)
+	}
+}
+
+// publish tells every notifier that t happened to u. Handlers call it once
+// per change, after the store has made it, so it is also where cached user
+// lists are dropped.
+func (app *App) publish(r *http.Request, t EventType, u store.User) {
+	if app.usersCache != nil {
+		app.usersCache.invalidate(r.Context())
+	}
+	e := Event{Type: t, User: u, At: app.clock.Now(), RequestID: reqctx.RequestID(r.Context())}
+	for _, s := range app.events.subscribers {
+		select {
+		case s.queue <- e:
+		default:
+			app.requestLogger(r).Warn("notifier queue full, dropping event", "notifier", s.name, "event", t, "user_id", u.ID)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package web
+
+// Serve is serve for the tests in package web_test, which share it rather
+// than keeping a copy.
+var Serve = serve
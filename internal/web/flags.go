@@ -0,0 +1,90 @@
+package web
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// featureDefaults are the feature flags with their values unless
+// FEATURE_FLAGS or PUT /_internal/flags says otherwise. A risky feature
+// ships behind one, off until it has proven itself in each environment.
+var featureDefaults = map[string]bool{
+	flagHomeConditionalGet: true,
+}
+
+// flagHomeConditionalGet answers revalidated homepages with 304; see
+// handleHome.
+const flagHomeConditionalGet = "home_conditional_get"
+
+// featureFlags holds every flag's effective value. The set of names is
+// fixed once built, so only the values need to be atomic.
+type featureFlags map[string]*atomic.Bool
+
+// newFeatureFlags applies overrides to featureDefaults, returning the
+// overridden names that aren't flags.
+func newFeatureFlags(overrides map[string]bool) (featureFlags, []string) {
+	flags := make(featureFlags, len(featureDefaults))
+	for name, on := range featureDefaults {
+		flags[name] = new(atomic.Bool)
+		flags[name].Store(on)
+	}
+	var unknown []string
+	for name, on := range overrides {
+		if f, ok := flags[name]; ok {
+			f.Store(on)
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	return flags, unknown
+}
+
+// enabled reports whether the flag called name is on; unknown flags are
+// off.
+func (f featureFlags) enabled(name string) bool {
+	flag, ok := f[name]
+	return ok && flag.Load()
+}
+
+func (f featureFlags) values() map[string]bool {
+	values := make(map[string]bool, len(f))
+	for name, flag := range f {
+		values[name] = flag.Load()
+	}
+	return values
+}
+
+// Flag reports whether the feature flag called name is on, for handlers;
+// templates have the "flag" function.
+func (app *App) Flag(name string) bool {
+	return app.flags.enabled(name)
+}
+
+type flagsResponse struct {
+	Flags map[string]bool `json:"flags"`
+}
+
+type flagUpdate struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// handleFlags lists the feature flags' effective values (GET) or flips one
+// (PUT {"name": "...", "enabled": true}) until the next restart.
+func (app *App) handleFlags(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var body flagUpdate
+		if !decodeJSON(w, r, &body, `Request body must be {"name": "...", "enabled": true|false}.`) {
+			return
+		}
+		flag, ok := app.flags[body.Name]
+		if !ok {
+			writeJSONError(w, r, http.StatusNotFound, "unknown_flag", "No feature flag is called "+body.Name+".")
+			return
+		}
+		if flag.Swap(body.Enabled) != body.Enabled {
+			app.requestLogger(r).Warn("feature flag changed", "flag", body.Name, "enabled", body.Enabled)
+		}
+	}
+	app.writeJSON(w, r, http.StatusOK, flagsResponse{Flags: app.flags.values()})
+}
@@ -0,0 +1,199 @@
+package web
+
+import (
+	"bufio"
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipMinSize is the smallest body worth compressing; below it the gzip
+// header and checksum eat most of the gain.
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// incompressibleTypes are content type prefixes that are already compressed.
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/octet-stream",
+}
+
+// compress gzips responses for clients that accept it. The body is held
+// back until it reaches gzipMinSize, so small responses go out as they are;
+// a Flush decides right away, so streaming responses are compressed and
+// still flushed through.
+func (app *App) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, i.e.
+// lists gzip or * without q=0.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		if v, err := strconv.ParseFloat(q, 64); err == nil && v > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type gzipWriter struct {
+	http.ResponseWriter
+	status   int
+	buf      []byte
+	decided  bool
+	gz       *gzip.Writer
+	hijacked bool
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	// 1xx responses go straight out and don't commit the final status.
+	if status < 200 {
+		gw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if gw.status == 0 {
+		gw.status = status
+	}
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+	if !gw.decided {
+		gw.buf = append(gw.buf, b...)
+		if len(gw.buf) < gzipMinSize {
+			return len(b), nil
+		}
+		if err := gw.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, compressed or not, and the held-back body.
+func (gw *gzipWriter) decide() error {
+	gw.decided = true
+	h := gw.Header()
+	if h.Get("Content-Type") == "" && len(gw.buf) > 0 {
+		// net/http would sniff the compressed bytes otherwise.
+		h.Set("Content-Type", http.DetectContentType(gw.buf))
+	}
+	if gw.shouldCompress() {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		gw.gz = gzipWriters.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+	buf := gw.buf
+	gw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(buf)
+	} else {
+		_, err = gw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (gw *gzipWriter) shouldCompress() bool {
+	if gw.status < 200 || gw.status == http.StatusNoContent || gw.status == http.StatusNotModified {
+		return false
+	}
+	h := gw.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(ct, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (gw *gzipWriter) Flush() {
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+	if !gw.decided {
+		gw.decide()
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// close finishes the response once the handler has returned.
+func (gw *gzipWriter) close() {
+	if gw.hijacked {
+		return
+	}
+	if !gw.decided {
+		if gw.status == 0 {
+			// The handler wrote nothing; let net/http send its default 200.
+			return
+		}
+		if len(gw.buf) < gzipMinSize {
+			gw.decided = true
+			gw.ResponseWriter.WriteHeader(gw.status)
+			gw.ResponseWriter.Write(gw.buf)
+			return
+		}
+		gw.decide()
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+		gw.gz.Reset(nil)
+		gzipWriters.Put(gw.gz)
+		gw.gz = nil
+	}
+}
+
+func (gw *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(gw.ResponseWriter).Hijack()
+	if err == nil {
+		gw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
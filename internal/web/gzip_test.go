@@ -0,0 +1,134 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=0.5": true,
+		"br, *":               true,
+		"gzip;q=0":            false,
+		"gzip; q=0, br":       false,
+		"identity":            false,
+		"x-gzip":              false,
+	} {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	app, _ := newTestApp(t)
+	big := strings.Repeat("compressible ", gzipMinSize)
+	get := func(method string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		return serve(app.compress(h), r)
+	}
+	gunzip := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("body is not gzip: %v", err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	rec := get(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", "1")
+		io.WriteString(w, big)
+	})
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Length") != "" {
+		t.Fatalf("large page: Content-Encoding %q, Content-Length %q; want gzip and none", rec.Header().Get("Content-Encoding"), rec.Header().Get("Content-Length"))
+	}
+	if got := gunzip(rec); got != big {
+		t.Errorf("large page decompresses to %d bytes, want %d", len(got), len(big))
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", rec.Header().Get("Vary"))
+	}
+
+	// Error responses are compressed like any other, keeping their status.
+	rec = get(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, big)
+	})
+	if rec.Code != http.StatusInternalServerError || gunzip(rec) != big {
+		t.Errorf("large error = %d, want a compressed 500", rec.Code)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		h      http.HandlerFunc
+	}{
+		{"small body", http.MethodGet, func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "short") }},
+		{"HEAD", http.MethodHead, func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, big) }},
+		{"304", http.MethodGet, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotModified) }},
+		{"image", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, big)
+		}},
+		{"already encoded", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, big)
+		}},
+	} {
+		rec := get(tc.method, tc.h)
+		if rec.Header().Get("Content-Encoding") == "gzip" {
+			t.Errorf("%s was gzipped", tc.name)
+		}
+	}
+	if rec := get(http.MethodGet, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotModified) }); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("304 = %d with %d bytes, want no body", rec.Code, rec.Body.Len())
+	}
+}
+
+// A flush reaches the client through the gzip writer, so streamed events
+// arrive as they are written rather than when the handler returns.
+func TestCompressFlush(t *testing.T) {
+	app, _ := newTestApp(t)
+	const event = "data: one\n\n"
+	rec := httptest.NewRecorder()
+	var flushed string
+	h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, event)
+		http.NewResponseController(w).Flush()
+
+		// Still inside the handler: what has reached the recorder so far.
+		zr, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Errorf("flushed body is not gzip: %v", err)
+			return
+		}
+		b := make([]byte, len(event))
+		n, _ := io.ReadFull(zr, b)
+		flushed = string(b[:n])
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rec, r)
+
+	if !rec.Flushed || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("stream: flushed %v, Content-Encoding %q; want a flushed gzip stream", rec.Flushed, rec.Header().Get("Content-Encoding"))
+	}
+	if flushed != event {
+		t.Errorf("flushed before the handler returned: %q, want %q", flushed, event)
+	}
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"context"
+	"syscall"
+	"testing"
+)
+
+func TestCheckPing(t *testing.T) {
+	app, logs := newTestApp(t)
+	refused := func(context.Context) error { return syscall.ECONNREFUSED }
+	ok := func(context.Context) error { return nil }
+
+	res := app.checkPing(t.Context(), "store", refused)
+	if res.Status != "fail" || res.Reason != "connection_refused" {
+		t.Errorf("failed ping = %+v, want fail with reason connection_refused", res)
+	}
+	if res := app.checkPing(t.Context(), "store", ok); res.Status != "ok" || res.Error != "" {
+		t.Errorf("ping = %+v, want ok", res)
+	}
+
+	// Each check counts its own failures: Redis coming back doesn't end the
+	// store's run.
+	for range healthFailureAlert {
+		app.checkPing(t.Context(), "store", refused)
+		app.checkPing(t.Context(), "redis", ok)
+	}
+	failing := logs.find("readiness check failing")
+	if len(failing) != 1 || failing[0]["check"] != "store" {
+		t.Errorf("logged %v, want one alert for the store", failing)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if res := app.checkPing(ctx, "store", func(ctx context.Context) error { return ctx.Err() }); res.Status != "fail" {
+		t.Errorf("ping cancelled by the probe = %+v, want fail", res)
+	}
+	app.checkPing(t.Context(), "store", ok)
+	recovered := logs.find("readiness check recovered")
+	if len(recovered) != 1 || recovered[0]["failures"] != float64(healthFailureAlert) {
+		t.Errorf("logged %v, want the store recovering after %d failures", recovered, healthFailureAlert)
+	}
+}
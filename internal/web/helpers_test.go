@@ -0,0 +1,91 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/store"
+)
+
+// testConfig is config.Default without the checks that get in the way of
+// driving handlers directly: the add-user form's fill time and the rate
+// limit.
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.FormMinFillTime = 0
+	cfg.RateLimitWindow = 0
+	return cfg
+}
+
+// newTestApp builds an App on the memory store with testConfig, request ids
+// from a sequence (req-1, req-2, ...) and its logs in the returned buffer.
+// opts go last, so they can replace any of that.
+func newTestApp(t *testing.T, opts ...Option) (*App, *logBuffer) {
+	t.Helper()
+	return newTestAppOn(t, store.NewMemoryStore(clock.System), opts...)
+}
+
+// newTestAppOn is newTestApp on users.
+func newTestAppOn(t *testing.T, users store.UserStore, opts ...Option) (*App, *logBuffer) {
+	t.Helper()
+	logs := &logBuffer{}
+	defaults := []Option{
+		WithConfig(testConfig()),
+		WithLogger(slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		WithIDGenerator(&clock.Sequence{Prefix: "req-"}),
+	}
+	app, err := NewApp(users, append(defaults, opts...)...)
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		app.Shutdown(ctx)
+	})
+	return app, logs
+}
+
+// serve runs r through h and returns what it answered.
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+// logBuffer collects JSON log lines, which handlers may write from more
+// than one goroutine.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// find returns the logged records whose msg is msg.
+func (b *logBuffer) find(msg string) []map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var found []map[string]any
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		var rec map[string]any
+		if json.Unmarshal([]byte(line), &rec) == nil && rec["msg"] == msg {
+			found = append(found, rec)
+		}
+	}
+	return found
+}
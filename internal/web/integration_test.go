@@ -0,0 +1,319 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/store"
+	"exam/internal/testutil"
+	"exam/internal/web"
+)
+
+// These tests run the handlers against a real Postgres; see
+// testutil.Postgres. They are skipped where Docker isn't available.
+
+var stampPattern = regexp.MustCompile(`name="form_stamp" value="([^"]+)"`)
+
+func postForm(path string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func postJSON(path, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestIntegrationHome(t *testing.T) {
+	clk := clock.NewFixed(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	env := testutil.Postgres(t, web.WithClock(clk))
+
+	rec := web.Serve(env.Handler, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET / = %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "No users yet.") {
+		t.Errorf("GET / on an empty database doesn't say so:\n%s", rec.Body)
+	}
+	m := stampPattern.FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatal("GET / has no form stamp")
+	}
+
+	// Posted as fast as a bot would, the form comes back.
+	form := url.Values{"name": {"Ada Lovelace"}, "email": {"ada@example.com"}, "form_stamp": {m[1]}}
+	rec = web.Serve(env.Handler, postForm("/", form))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST / right after GET = %d, want 422", rec.Code)
+	}
+
+	clk.Advance(5 * time.Second)
+	rec = web.Serve(env.Handler, postForm("/", form))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" {
+		t.Fatalf("POST / = %d to %q, want 303 to /", rec.Code, rec.Header().Get("Location"))
+	}
+	users, err := env.Store.List(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "Ada Lovelace" || users[0].Email != "ada@example.com" {
+		t.Fatalf("users after POST / = %+v, want Ada Lovelace", users)
+	}
+
+	rec = web.Serve(env.Handler, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Ada Lovelace") {
+		t.Errorf("GET / after adding = %d, want 200 listing Ada Lovelace:\n%s", rec.Code, rec.Body)
+	}
+
+	rec = web.Serve(env.Handler, postForm("/", url.Values{"name": {"  "}, "form_stamp": {m[1]}}))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Name must not be empty.") {
+		t.Errorf("POST / with a blank name = %d, want 422 saying why:\n%s", rec.Code, rec.Body)
+	}
+}
+
+func TestIntegrationAPIUsers(t *testing.T) {
+	env := testutil.Postgres(t)
+
+	rec := web.Serve(env.Handler, postJSON("/api/users", `{"name": "Grace Hopper", "email": "GRACE@example.com"}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /api/users = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var created store.User
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 0 || created.Name != "Grace Hopper" || created.CreatedAt.IsZero() {
+		t.Errorf("created user = %+v, want an id, the name and created_at", created)
+	}
+
+	rec = web.Serve(env.Handler, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/users = %d, want 200", rec.Code)
+	}
+	var list web.GetUsersResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Users) != 1 || list.Users[0].ID != created.ID || list.Users[0].Email != "grace@example.com" {
+		t.Errorf("GET /api/users = %+v, want just %d with its email lower-cased", list.Users, created.ID)
+	}
+
+	rec = web.Serve(env.Handler, httptest.NewRequest(http.MethodGet, "/api/users/"+strconv.Itoa(created.ID), nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /api/users/%d = %d, want 200", created.ID, rec.Code)
+	}
+	rec = web.Serve(env.Handler, httptest.NewRequest(http.MethodGet, "/api/users/999999", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing user = %d, want 404", rec.Code)
+	}
+
+	for _, body := range []string{`{"name": ""}`, `{"name": "x", "email": "not an address"}`} {
+		rec = web.Serve(env.Handler, postJSON("/api/users", body))
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("POST /api/users %s = %d, want 422", body, rec.Code)
+		}
+	}
+	rec = web.Serve(env.Handler, postJSON("/api/users", `{"name":`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/users with broken JSON = %d, want 400", rec.Code)
+	}
+
+	// The unique index's violation comes up through the store and reaches
+	// the handlers as ErrConflict.
+	rec = web.Serve(env.Handler, postJSON("/api/users", `{"name": "Grace Hopper"}`))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"code":"conflict"`) {
+		t.Errorf("POST /api/users with a taken name = %d %s, want a 409 conflict", rec.Code, rec.Body)
+	}
+	other, err := env.Store.Create(t.Context(), "Ada Lovelace", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"id": {strconv.Itoa(other.ID)}, "name": {"Grace Hopper"}}
+	if rec = web.Serve(env.Handler, postForm("/users/update", form)); rec.Code != http.StatusConflict {
+		t.Errorf("renaming to a taken name = %d, want 409", rec.Code)
+	}
+}
+
+func TestIntegrationReadyz(t *testing.T) {
+	env := testutil.Postgres(t)
+
+	rec := web.Serve(env.Handler, httptest.NewRequest(http.MethodGet, "/_internal/readyz?fresh=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /_internal/readyz = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Status                string `json:"status"`
+		Checks                map[string]struct{ Status string }
+		SchemaVersion         int64 `json:"schema_version"`
+		ExpectedSchemaVersion int64 `json:"expected_schema_version"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "ok" || resp.Checks["db"].Status != "ok" {
+		t.Errorf("readyz = %+v, want status ok with an ok db check", resp)
+	}
+	if resp.SchemaVersion != resp.ExpectedSchemaVersion {
+		t.Errorf("schema version %d, want %d", resp.SchemaVersion, resp.ExpectedSchemaVersion)
+	}
+
+	rec = web.Serve(env.Handler, httptest.NewRequest(http.MethodGet, "/_internal/readyz?verbose=false", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("terse readyz = %d with %d bytes, want 200 and no body", rec.Code, rec.Body.Len())
+	}
+
+	rec = web.Serve(env.Handler, httptest.NewRequest(http.MethodGet, "/_internal/readyz?deep=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("deep readyz = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func internalRequest(method, path string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, path, body)
+	r.Header.Set("Authorization", "Bearer "+testutil.InternalToken)
+	return r
+}
+
+// A backup restored over changed data brings back what it was taken of,
+// but only when the confirmation names the database and the schema
+// version matches.
+func TestIntegrationBackupRestore(t *testing.T) {
+	env := testutil.Postgres(t)
+	ctx := t.Context()
+	ada, err := env.Store.Create(ctx, "Ada", "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Store.Create(ctx, "Grace", ""); err != nil {
+		t.Fatal(err)
+	}
+	before, err := env.Store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := web.Serve(env.Handler, internalRequest(http.MethodGet, "/_internal/backup", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /_internal/backup = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("backup Content-Type = %q, want JSON with a charset", ct)
+	}
+	backup := rec.Body.Bytes()
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(backup, &doc); err != nil {
+		t.Fatalf("backup is not JSON: %v", err)
+	}
+	var database string
+	if err := json.Unmarshal(doc["database"], &database); err != nil || database == "" {
+		t.Fatalf("backup names database %q, %v", doc["database"], err)
+	}
+
+	if err := env.Store.Delete(ctx, ada.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Store.Create(ctx, "Edsger", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var failure struct {
+		Error struct{ Code string }
+	}
+	for _, confirm := range []string{"", "other"} {
+		rec = web.Serve(env.Handler, internalRequest(http.MethodPost, "/_internal/restore?confirm="+confirm, bytes.NewReader(backup)))
+		json.Unmarshal(rec.Body.Bytes(), &failure)
+		if rec.Code != http.StatusBadRequest || failure.Error.Code != "confirmation_required" {
+			t.Errorf("restore confirmed with %q = %d %s, want 400 confirmation_required", confirm, rec.Code, failure.Error.Code)
+		}
+	}
+
+	var version int64
+	json.Unmarshal(doc["schema_version"], &version)
+	doc["schema_version"] = json.RawMessage(strconv.FormatInt(version+1, 10))
+	newer, _ := json.Marshal(doc)
+	rec = web.Serve(env.Handler, internalRequest(http.MethodPost, "/_internal/restore?confirm="+database, bytes.NewReader(newer)))
+	json.Unmarshal(rec.Body.Bytes(), &failure)
+	if rec.Code != http.StatusUnprocessableEntity || failure.Error.Code != "schema_mismatch" {
+		t.Errorf("restore of another schema version = %d %s, want 422 schema_mismatch", rec.Code, failure.Error.Code)
+	}
+	if n, _ := env.Store.Count(ctx); n != 2 {
+		t.Errorf("Count after refused restores = %d, want 2: Grace and Edsger", n)
+	}
+
+	rec = web.Serve(env.Handler, internalRequest(http.MethodPost, "/_internal/restore?confirm="+database, bytes.NewReader(backup)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /_internal/restore = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var restored struct{ Tables map[string]int }
+	if err := json.Unmarshal(rec.Body.Bytes(), &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Tables["users"] != 2 {
+		t.Errorf("restored tables = %v, want 2 users", restored.Tables)
+	}
+	after, err := env.Store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("users after restore = %+v, want %+v", after, before)
+	}
+	for i := range before {
+		if after[i].ID != before[i].ID || after[i].Name != before[i].Name || after[i].Email != before[i].Email {
+			t.Errorf("user %d after restore = %+v, want %+v", i, after[i], before[i])
+		}
+	}
+	// The id sequence is moved past the restored rows.
+	if u, err := env.Store.Create(ctx, "Barbara", ""); err != nil || u.ID <= before[len(before)-1].ID {
+		t.Errorf("Create after restore = %+v, %v; want an id past the restored ones", u, err)
+	}
+}
+
+// Token secrets come from the app's id generator, so a test knows them.
+func TestIntegrationAPITokens(t *testing.T) {
+	env := testutil.Postgres(t, web.WithIDGenerator(&clock.Sequence{Prefix: "id-"}))
+
+	r := internalRequest(http.MethodPost, "/_internal/tokens", strings.NewReader(`{"name": "ci", "requests_per_hour": 2}`))
+	r.Header.Set("Content-Type", "application/json")
+	rec := web.Serve(env.Handler, r)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /_internal/tokens = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var token struct{ Secret string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	// id-1 is the request's id.
+	if token.Secret != "id-2" {
+		t.Fatalf("secret = %q, want id-2", token.Secret)
+	}
+
+	get := func(secret string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		return web.Serve(env.Handler, r).Code
+	}
+	if code := get("id-3"); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/users with an unknown token = %d, want 401", code)
+	}
+	for i := range 2 {
+		if code := get(token.Secret); code != http.StatusOK {
+			t.Errorf("call %d with the token = %d, want 200", i+1, code)
+		}
+	}
+	if code := get(token.Secret); code != http.StatusTooManyRequests {
+		t.Errorf("call over the quota = %d, want 429", code)
+	}
+}
@@ -0,0 +1,22 @@
+package web
+
+import (
+	"context"
+
+	"exam/internal/config"
+	"exam/internal/store"
+)
+
+// scheduleJanitor sweeps expired rows from sw every cfg.CleanupInterval.
+func (app *App) scheduleJanitor(sw store.Sweeper, cfg config.Config) {
+	app.schedule("janitor", cfg.CleanupInterval, func(ctx context.Context) error {
+		removed, err := sw.Sweep(ctx, app.clock.Now(), cfg.Retention, cfg.CleanupBatchSize)
+		for kind, n := range removed {
+			app.metrics.janitorRemoved.WithLabelValues(kind).Add(float64(n))
+			if n > 0 {
+				app.logger.Info("removed expired rows", "task", "janitor", "kind", kind, "rows", n)
+			}
+		}
+		return err
+	})
+}
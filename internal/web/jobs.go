@@ -0,0 +1,133 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"exam/internal/store"
+)
+
+const (
+	// jobTimeout bounds one run of a job handler.
+	jobTimeout = time.Minute
+	// jobLease is how long a claimed job stays hidden from other workers.
+	// A job whose worker died is picked up again once it runs out.
+	jobLease = 2 * jobTimeout
+	// Failed jobs are retried after jobBackoff, doubling per attempt up to
+	// jobBackoffMax.
+	jobBackoff    = 10 * time.Second
+	jobBackoffMax = time.Hour
+	// deadJobsLimit is how many dead letters /_internal/jobs/dead lists.
+	deadJobsLimit = 100
+)
+
+// ErrNoJobQueue is returned by Enqueue when the store can't keep jobs.
+var ErrNoJobQueue = errors.New("store has no job queue")
+
+// JobHandler runs one job of the type it was registered for, given the
+// payload it was enqueued with. An error schedules a retry.
+type JobHandler func(ctx context.Context, payload json.RawMessage) error
+
+// Enqueue schedules a job of type typ, to be run by a worker on any
+// instance with the handler registered through WithJobHandler. payload is
+// stored as JSON.
+func (app *App) Enqueue(ctx context.Context, typ string, payload any) error {
+	if app.jobs == nil {
+		return ErrNoJobQueue
+	}
+	_, err := app.jobs.Enqueue(ctx, typ, payload, app.clock.Now())
+	return err
+}
+
+// startJobWorkers starts n workers, each claiming and running one due job
+// at a time and sleeping for poll when there is none. On Shutdown they stop
+// claiming and finish the job in hand.
+func (app *App) startJobWorkers(n int, poll time.Duration, maxAttempts int) {
+	for i := range n {
+		name := fmt.Sprintf("job worker %d", i+1)
+		logger := app.logger.With("task", name)
+		app.goBackground(name, func(ctx context.Context) {
+			for {
+				job, err := app.jobs.ClaimJob(ctx, jobLease)
+				switch {
+				case err == nil:
+					app.runJob(context.WithoutCancel(ctx), job, maxAttempts)
+					continue
+				case ctx.Err() != nil:
+					return
+				case errors.Is(err, store.ErrNoJobs):
+				case errors.Is(err, store.ErrDBConnecting), errors.Is(err, store.ErrUnavailable):
+					logger.Debug("claiming job failed", "error", err)
+				default:
+					logger.Warn("claiming job failed", "error", err)
+				}
+				select {
+				case <-time.After(poll):
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+}
+
+// runJob runs job's handler and records the outcome: done, retried after a
+// backoff, or after maxAttempts moved to the dead letters. A job of a type
+// without a handler goes straight to the dead letters.
+func (app *App) runJob(ctx context.Context, job store.Job, maxAttempts int) {
+	logger := app.logger.With("job_id", job.ID, "job_type", job.Type, "attempt", job.Attempts)
+	runCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	h, ok := app.jobHandlers[job.Type]
+	var err error
+	if ok {
+		err = h(runCtx, job.Payload)
+	} else {
+		err = fmt.Errorf("no handler for job type %q", job.Type)
+	}
+
+	switch {
+	case err == nil:
+		app.metrics.jobsProcessed.WithLabelValues(job.Type, "ok").Inc()
+		err = app.jobs.CompleteJob(ctx, job.ID)
+	case !ok || job.Attempts >= maxAttempts:
+		app.metrics.jobsProcessed.WithLabelValues(job.Type, "dead").Inc()
+		logger.Error("job failed, giving up", "error", err)
+		err = app.jobs.BuryJob(ctx, job.ID, err.Error())
+	default:
+		backoff := jobBackoffMax
+		if job.Attempts <= 10 {
+			backoff = min(jobBackoff<<(job.Attempts-1), jobBackoffMax)
+		}
+		app.metrics.jobsProcessed.WithLabelValues(job.Type, "retry").Inc()
+		logger.Warn("job failed, will retry", "retry_in", backoff.String(), "error", err)
+		err = app.jobs.RetryJob(ctx, job.ID, app.clock.Now().Add(backoff), err.Error())
+	}
+	if err != nil {
+		// The lease runs out and the job is claimed again.
+		logger.Error("failed to record job outcome", "error", err)
+	}
+}
+
+type deadJobsResponse struct {
+	Jobs []store.Job `json:"jobs"`
+}
+
+// handleDeadJobs lists the most recent jobs that ran out of attempts.
+func (app *App) handleDeadJobs(w http.ResponseWriter, r *http.Request) {
+	if app.jobs == nil {
+		app.writeJSON(w, r, http.StatusOK, deadJobsResponse{Jobs: []store.Job{}})
+		return
+	}
+	jobs, err := app.jobs.DeadJobs(r.Context(), deadJobsLimit)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("list dead jobs: %w", err))
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, deadJobsResponse{Jobs: jobs})
+}
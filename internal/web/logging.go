@@ -0,0 +1,80 @@
+package web
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"exam/internal/config"
+	"exam/internal/reqctx"
+)
+
+// NewLogger returns a JSON logger, or a human-readable text logger when
+// LOG_FORMAT=text, whose level is controlled by the returned LevelVar
+// (initially LOG_LEVEL, default info). Every component logs through the
+// logger held on App rather than the slog default.
+func NewLogger(w io.Writer, cfg config.Config) (*slog.Logger, *slog.LevelVar) {
+	level := new(slog.LevelVar)
+	level.Set(cfg.LogLevel)
+
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.LogFormat == "text" {
+		return slog.New(slog.NewTextHandler(w, opts)), level
+	}
+	return slog.New(slog.NewJSONHandler(w, opts)), level
+}
+
+// leveledHandler filters records below level before passing them on, so
+// the level of a logger built elsewhere can still be changed at runtime.
+type leveledHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h leveledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return leveledHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h leveledHandler) WithGroup(name string) slog.Handler {
+	return leveledHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// requestLogger returns app.logger annotated with the request's id, method
+// and path, for log lines emitted while handling r.
+func (app *App) requestLogger(r *http.Request) *slog.Logger {
+	logger := app.logger
+	if dl, ok := reqctx.DebugLogger(r.Context()); ok {
+		logger = dl
+	}
+	return logger.With("request_id", reqctx.RequestID(r.Context()), "method", r.Method, "path", r.URL.Path)
+}
+
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+// handleLogLevel reports (GET) or changes (PUT {"level": "debug"}) the log
+// level at runtime. The change is not persisted across restarts.
+func (app *App) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var body logLevelBody
+		if !decodeJSON(w, r, &body, `Request body must be {"level": "<level>"}.`) {
+			return
+		}
+		level, err := config.ParseLogLevel(body.Level)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, "invalid_level", err.Error())
+			return
+		}
+		previous := app.logLevel.Level()
+		app.logLevel.Set(level)
+		app.logger.Warn("log level changed", "from", previous.String(), "to", level.String())
+	}
+	app.writeJSON(w, r, http.StatusOK, logLevelBody{Level: strings.ToLower(app.logLevel.Level().String())})
+}
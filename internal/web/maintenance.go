@@ -0,0 +1,56 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	maintenanceRetryAfter = "120"
+)
+
+// maintenanceExempt keeps probes, metrics, the admin endpoints (including
+// the toggle itself) and the assets the maintenance page needs reachable.
+var maintenanceExempt = []string{"/_internal/", "/static/", "/metrics"}
+
+type maintenanceBody struct {
+	Enabled bool `json:"enabled"`
+}
+
+// withMaintenance answers every other request with 503 while maintenance
+// mode is on. The flag is read per request so it can be toggled at runtime.
+func (app *App) withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.maintenance.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range maintenanceExempt {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		if isAPIRequest(r) {
+			writeJSONError(w, r, http.StatusServiceUnavailable, "maintenance", "The service is down for maintenance.")
+			return
+		}
+		app.renderStatus(w, r, http.StatusServiceUnavailable, "maintenance", nil)
+	})
+}
+
+// handleMaintenance reports (GET) or switches (PUT {"enabled": true})
+// maintenance mode.
+func (app *App) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var body maintenanceBody
+		if !decodeJSON(w, r, &body, `Request body must be {"enabled": true|false}.`) {
+			return
+		}
+		if app.maintenance.Swap(body.Enabled) != body.Enabled {
+			app.requestLogger(r).Warn("maintenance mode changed", "enabled", body.Enabled)
+		}
+	}
+	app.writeJSON(w, r, http.StatusOK, maintenanceBody{Enabled: app.maintenance.Load()})
+}
@@ -0,0 +1,91 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the app's Prometheus collectors. They are registered on a
+// private registry (not the global default) exposed at /metrics.
+type metrics struct {
+	registry *prometheus.Registry
+
+	panics              prometheus.Counter
+	inFlight            prometheus.Gauge
+	concurrencyRejected prometheus.Counter
+	statementTimeouts   prometheus.Counter
+	requestDuration     *prometheus.HistogramVec
+	scheduledRuns       *prometheus.CounterVec
+	janitorRemoved      *prometheus.CounterVec
+	jobsProcessed       *prometheus.CounterVec
+	cacheRequests       *prometheus.CounterVec
+	rateLimited         *prometheus.CounterVec
+	spamRejected        *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	m := &metrics{
+		registry: reg,
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "http_panics_recovered_total",
+			Help: "Handler panics caught by the recovery middleware.",
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Requests currently holding a concurrency slot.",
+		}),
+		concurrencyRejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "http_requests_rejected_overload_total",
+			Help: "Requests answered 503 because no concurrency slot freed up in time.",
+		}),
+		statementTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "db_statement_timeouts_total",
+			Help: "Requests answered 503 because a statement ran past statement_timeout.",
+		}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Request latency on the main listener, by route pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+		scheduledRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scheduled_task_runs_total",
+			Help: "Scheduled task rounds, by task and result: ok, busy (another instance had the lock) or error.",
+		}, []string{"task", "result"}),
+		janitorRemoved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "janitor_rows_removed_total",
+			Help: "Expired rows deleted by the janitor, by kind.",
+		}, []string{"kind"}),
+		jobsProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jobs_processed_total",
+			Help: "Background job attempts, by job type and result: ok, retry or dead.",
+		}, []string{"type", "result"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_response_cache_requests_total",
+			Help: "Lookups in the response cache, by cache and result: hit or miss.",
+		}, []string{"cache", "result"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_rate_limited_total",
+			Help: "Requests answered 429 by a rate limit, by limit.",
+		}, []string{"limit"}),
+		spamRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_form_spam_rejected_total",
+			Help: "Add-user form submissions rejected as automated, by reason: honeypot, too_fast or stale.",
+		}, []string{"reason"}),
+	}
+	reg.MustRegister(m.panics, m.inFlight, m.concurrencyRejected, m.statementTimeouts, m.requestDuration,
+		m.scheduledRuns, m.janitorRemoved, m.jobsProcessed, m.cacheRequests, m.rateLimited, m.spamRejected)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,231 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"exam/internal/reqctx"
+)
+
+const (
+	RequestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 64
+)
+
+type ctxKey int
+
+const (
+	phasesKey ctxKey = iota
+	// apiTokenKey holds the store.APIToken a call was made with; see
+	// withAPIQuota.
+	apiTokenKey
+)
+
+// withRequestID assigns every request an id, reusing the caller's
+// X-Request-ID when it is well-formed so ids can be correlated across
+// services. The id is stored in the context and echoed in the response.
+func (app *App) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := sanitizeRequestID(r.Header.Get(RequestIDHeader))
+		if id == "" {
+			id = app.ids.NewID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(reqctx.WithRequestID(r.Context(), id)))
+	})
+}
+
+// sanitizeRequestID truncates an incoming id and rejects it entirely if it
+// contains anything beyond [A-Za-z0-9._-], so it is safe to log and echo.
+func sanitizeRequestID(id string) string {
+	if len(id) > maxRequestIDLength {
+		id = id[:maxRequestIDLength]
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return ""
+		}
+	}
+	return id
+}
+
+// responseRecorder wraps a ResponseWriter to capture the status code and
+// body size for access logging. It forwards Flush and Hijack so streaming
+// and WebSocket handlers keep working, and Unwrap for http.ResponseController.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+	// finished is set once the handler has returned rather than panicked.
+	finished bool
+}
+
+// finalStatus is the status the client gets: the one written, 200 if the
+// handler wrote none, or 500 if it panicked first, as recoverPanics then
+// answers.
+func (rec *responseRecorder) finalStatus() int {
+	switch {
+	case rec.status != 0:
+		return rec.status
+	case !rec.finished:
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.hijacked = true
+		if rec.status == 0 {
+			rec.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, rw, err
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// accessLog emits one structured line per request once the handler returns,
+// or panics. Paths listed in ACCESS_LOG_SKIP_PATHS (e.g. health probes) are
+// not logged.
+func (app *App) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, skip := app.accessLogSkip[r.URL.Path]; skip {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			app.logger.Info("request",
+				"request_id", reqctx.RequestID(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.finalStatus(),
+				"bytes", rec.bytes,
+				"duration_ms", float64(time.Since(start).Microseconds())/1000,
+				"remote_addr", app.clientIP(r),
+				"user_agent", r.UserAgent(),
+				"hijacked", rec.hijacked,
+			)
+		}()
+		next.ServeHTTP(rec, r)
+		rec.finished = true
+	})
+}
+
+// remoteHost returns the IP of the immediate peer.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recoverPanics turns a handler panic into a logged stack trace and a 500
+// response (JSON envelope for API routes, the error page otherwise) instead
+// of a reset connection. http.ErrAbortHandler is re-panicked, as net/http
+// uses it to abort a response deliberately.
+func (app *App) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			app.metrics.panics.Inc()
+			// Outermost, r predates the request id; withRequestID left it
+			// on the response.
+			if id := w.Header().Get(RequestIDHeader); id != "" {
+				r = r.WithContext(reqctx.WithRequestID(r.Context(), id))
+			}
+			app.requestLogger(r).Error("panic recovered", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+
+			if isAPIRequest(r) {
+				writeJSONError(w, r, http.StatusInternalServerError, "internal", "Internal Server Error")
+				return
+			}
+			app.renderError(w, r, http.StatusInternalServerError, "Something went wrong on our side.")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAPIRequest reports whether r targets a JSON route, whose errors must use
+// the JSON envelope rather than an HTML page.
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/_internal/")
+}
+
+// limitBody wraps every request body in http.MaxBytesReader so a huge POST
+// fails fast with 413 instead of exhausting memory.
+func (app *App) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := app.bodyLimits.Body
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+			limit = app.bodyLimits.Upload
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// parseForm parses an HTML form post, rendering the error page with 413 when
+// the body is over the limit or 400 when it is malformed.
+func (app *App) parseForm(w http.ResponseWriter, r *http.Request) bool {
+	err := r.ParseForm()
+	switch {
+	case err == nil:
+		return true
+	case isBodyTooLarge(err):
+		app.renderError(w, r, http.StatusRequestEntityTooLarge, "The submitted form is too large.")
+	default:
+		app.renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.")
+	}
+	return false
+}
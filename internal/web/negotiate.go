@@ -0,0 +1,79 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const jsonContentType = "application/json; charset=utf-8"
+
+// mediaRange is one entry of an Accept header, such as text/* or
+// application/json;q=0.5.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept splits an Accept header into its media ranges. Entries that
+// aren't type/subtype are skipped, and a missing or malformed q counts as 1.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mt, params, _ := strings.Cut(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mt)), "/")
+		if !ok || typ == "" || subtype == "" {
+			continue
+		}
+		mr := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q >= 0 && q <= 1 {
+					mr.q = q
+				}
+			}
+		}
+		ranges = append(ranges, mr)
+	}
+	return ranges
+}
+
+// accepts reports whether an Accept header allows mediaType. The most
+// specific matching range decides, so "*/*, application/json;q=0" refuses
+// JSON. An empty header accepts anything.
+func accepts(header, mediaType string) bool {
+	if strings.TrimSpace(header) == "" {
+		return true
+	}
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	best, q := -1, 0.0
+	for _, mr := range parseAccept(header) {
+		specificity := -1
+		switch {
+		case mr.typ == typ && mr.subtype == subtype:
+			specificity = 2
+		case mr.typ == typ && mr.subtype == "*":
+			specificity = 1
+		case mr.typ == "*" && mr.subtype == "*":
+			specificity = 0
+		}
+		if specificity > best {
+			best, q = specificity, mr.q
+		}
+	}
+	return q > 0
+}
+
+// acceptJSON answers 406 to API requests whose Accept header rules out
+// JSON, the only format the API speaks.
+func (app *App) acceptJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if !accepts(r.Header.Get("Accept"), "application/json") {
+			writeJSONError(w, r, http.StatusNotAcceptable, "not_acceptable", "Responses are only available as application/json.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,125 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/store"
+)
+
+// Option customizes an App built by NewApp.
+type Option func(*appOptions)
+
+// appOptions is what NewApp builds from. Anything no Option sets keeps the
+// default from defaultOptions.
+type appOptions struct {
+	cfg       config.Config
+	logger    *slog.Logger
+	logLevel  *slog.LevelVar
+	templates fs.FS
+	clock     clock.Clock
+	ids       clock.IDGenerator
+	notifiers []namedNotifier
+	jobs      map[string]JobHandler
+}
+
+type namedNotifier struct {
+	name string
+	n    Notifier
+}
+
+func defaultOptions() appOptions {
+	templates, _ := fs.Sub(templateFS, "templates")
+	return appOptions{
+		cfg:       config.Default(),
+		logger:    slog.Default(),
+		templates: templates,
+		clock:     clock.System,
+		ids:       clock.RandomIDs,
+	}
+}
+
+// WithConfig replaces config.Default, which is what the app runs with
+// when no environment or flags are given.
+func WithConfig(cfg config.Config) Option {
+	return func(o *appOptions) { o.cfg = cfg }
+}
+
+// WithLogger sends the app's logs to logger instead of slog.Default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *appOptions) { o.logger = logger }
+}
+
+// WithLogLevel hands over the level the logger is already filtered at, for
+// /_internal/loglevel to adjust. Without it NewApp filters the logger itself,
+// starting at the config's LogLevel.
+func WithLogLevel(level *slog.LevelVar) Option {
+	return func(o *appOptions) { o.logLevel = level }
+}
+
+// WithTemplates renders pages from fsys instead of the embedded templates.
+// fsys is laid out like internal/web/templates: layout.html, partials/ and
+// pages/.
+func WithTemplates(fsys fs.FS) Option {
+	return func(o *appOptions) { o.templates = fsys }
+}
+
+// WithClock makes the app tell time by c, for the timestamps it hands out
+// and the intervals it measures.
+func WithClock(c clock.Clock) Option {
+	return func(o *appOptions) { o.clock = c }
+}
+
+// WithIDGenerator makes the app draw request ids and API token secrets from
+// ids.
+func WithIDGenerator(ids clock.IDGenerator) Option {
+	return func(o *appOptions) { o.ids = ids }
+}
+
+// WithNotifier subscribes n to user events under name, which labels its
+// log lines. It may be given more than once.
+func WithNotifier(name string, n Notifier) Option {
+	return func(o *appOptions) { o.notifiers = append(o.notifiers, namedNotifier{name, n}) }
+}
+
+// WithJobHandler runs jobs of type typ with h. Jobs are only run by
+// stores that can keep them, i.e. Postgres.
+func WithJobHandler(typ string, h JobHandler) Option {
+	return func(o *appOptions) {
+		if o.jobs == nil {
+			o.jobs = map[string]JobHandler{}
+		}
+		o.jobs[typ] = h
+	}
+}
+
+// validate reports the first piece the App can't run without.
+func (o *appOptions) validate(users store.UserStore) error {
+	switch {
+	case users == nil:
+		return errors.New("no user store")
+	case o.logger == nil:
+		return errors.New("no logger")
+	case o.templates == nil:
+		return errors.New("no templates")
+	case o.clock == nil:
+		return errors.New("no clock")
+	case o.ids == nil:
+		return errors.New("no id generator")
+	}
+	for _, nn := range o.notifiers {
+		if nn.n == nil {
+			return fmt.Errorf("notifier %q is nil", nn.name)
+		}
+	}
+	for typ, h := range o.jobs {
+		if h == nil {
+			return fmt.Errorf("handler for job type %q is nil", typ)
+		}
+	}
+	return nil
+}
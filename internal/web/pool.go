@@ -0,0 +1,59 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// poolReport is the body of /_internal/pool: the pool's current state, its
+// configured limits and its lifetime counters.
+type poolReport struct {
+	poolStats
+	Ready                   bool    `json:"ready"`
+	ConstructingConns       int32   `json:"constructing_conns"`
+	AcquireCount            int64   `json:"acquire_count"`
+	AcquireDurationMs       float64 `json:"acquire_duration_ms"`
+	EmptyAcquireCount       int64   `json:"empty_acquire_count"`
+	CanceledAcquireCount    int64   `json:"canceled_acquire_count"`
+	NewConnsCount           int64   `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64   `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64   `json:"max_idle_destroy_count"`
+}
+
+func newPoolStats(pool *pgxpool.Pool) poolStats {
+	stat, cfg := pool.Stat(), pool.Config()
+	return poolStats{
+		TotalConns:         stat.TotalConns(),
+		IdleConns:          stat.IdleConns(),
+		AcquiredConns:      stat.AcquiredConns(),
+		MaxConns:           stat.MaxConns(),
+		MinConns:           cfg.MinConns,
+		MaxConnLifetimeS:   cfg.MaxConnLifetime.Seconds(),
+		MaxConnIdleTimeS:   cfg.MaxConnIdleTime.Seconds(),
+		HealthCheckPeriodS: cfg.HealthCheckPeriod.Seconds(),
+	}
+}
+
+// handlePool reports the connection pool for incident debugging. It only
+// reads the pool's in-memory counters, so polling it costs nothing.
+func (app *App) handlePool(w http.ResponseWriter, r *http.Request) {
+	pool := app.db.Pool()
+	if pool == nil {
+		app.writeJSON(w, r, http.StatusOK, poolReport{})
+		return
+	}
+	stat := pool.Stat()
+	app.writeJSON(w, r, http.StatusOK, poolReport{
+		poolStats:               newPoolStats(pool),
+		Ready:                   true,
+		ConstructingConns:       stat.ConstructingConns(),
+		AcquireCount:            stat.AcquireCount(),
+		AcquireDurationMs:       float64(stat.AcquireDuration().Microseconds()) / 1000,
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	})
+}
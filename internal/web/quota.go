@@ -0,0 +1,317 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/store"
+)
+
+const (
+	// quotaWindow is the clock period API token quotas are counted over.
+	quotaWindow = time.Hour
+	// quotaFlushInterval is how often each instance writes its count of
+	// API calls and reads everyone's back, which bounds by how much the
+	// instances together can overshoot a quota.
+	quotaFlushInterval = 5 * time.Second
+	// apiTokenTTL is how long a looked up token is reused, so a changed
+	// quota applies within it.
+	apiTokenTTL = time.Minute
+	// apiTokensMax bounds the looked up tokens kept.
+	apiTokensMax = 1024
+)
+
+// quotas counts API calls per token in memory and writes them to the store
+// in batches, so a call costs no write of its own.
+type quotas struct {
+	store store.APITokens
+	clock clock.Clock
+
+	mu sync.Mutex
+	// window is the current hour, and counted every instance's calls in it
+	// as last read from the store.
+	window  time.Time
+	counted map[int64]int
+	// pending is this instance's calls not yet written, by hour.
+	pending map[time.Time]map[int64]int
+	tokens  map[[sha256.Size]byte]cachedToken
+}
+
+type cachedToken struct {
+	token   store.APIToken
+	expires time.Time
+}
+
+func newQuotas(st store.APITokens, clk clock.Clock) *quotas {
+	return &quotas{
+		store:   st,
+		clock:   clk,
+		counted: map[int64]int{},
+		pending: map[time.Time]map[int64]int{},
+		tokens:  map[[sha256.Size]byte]cachedToken{},
+	}
+}
+
+// lookup returns the token whose secret is secret.
+func (q *quotas) lookup(ctx context.Context, secret string) (store.APIToken, error) {
+	hash := sha256.Sum256([]byte(secret))
+	now := q.clock.Now()
+	q.mu.Lock()
+	c, ok := q.tokens[hash]
+	q.mu.Unlock()
+	if ok && now.Before(c.expires) {
+		return c.token, nil
+	}
+
+	t, err := q.store.APITokenByHash(ctx, hash[:])
+	if err != nil {
+		return store.APIToken{}, err
+	}
+	q.mu.Lock()
+	if len(q.tokens) >= apiTokensMax {
+		clear(q.tokens)
+	}
+	q.tokens[hash] = cachedToken{token: t, expires: now.Add(apiTokenTTL)}
+	q.mu.Unlock()
+	return t, nil
+}
+
+// take counts a call by t unless it is over quota, returning its calls in
+// the current window, including this one, and when the window ends.
+func (q *quotas) take(t store.APIToken) (used int, reset time.Time, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll()
+	used = q.counted[t.ID] + q.pending[q.window][t.ID]
+	if used >= t.RequestsPerHour {
+		return used, q.window.Add(quotaWindow), false
+	}
+	if q.pending[q.window] == nil {
+		q.pending[q.window] = map[int64]int{}
+	}
+	q.pending[q.window][t.ID]++
+	return used + 1, q.window.Add(quotaWindow), true
+}
+
+// used is t's calls in the current window as this instance knows them.
+func (q *quotas) used(t store.APIToken) (used int, window time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll()
+	return q.counted[t.ID] + q.pending[q.window][t.ID], q.window
+}
+
+// roll starts a new window when the hour has turned. It must be called
+// with mu held.
+func (q *quotas) roll() {
+	if w := q.clock.Now().UTC().Truncate(quotaWindow); !w.Equal(q.window) {
+		q.window = w
+		clear(q.counted)
+	}
+}
+
+// flush writes the pending calls, putting them back if that fails, then
+// reads back every instance's for the current window.
+func (q *quotas) flush(ctx context.Context) error {
+	q.mu.Lock()
+	q.roll()
+	pending, window := q.pending, q.window
+	q.pending = map[time.Time]map[int64]int{}
+	q.mu.Unlock()
+
+	var errs []error
+	for w, requests := range pending {
+		if err := q.store.AddUsage(ctx, w, requests); err != nil {
+			errs = append(errs, err)
+			q.mu.Lock()
+			for id, n := range requests {
+				if q.pending[w] == nil {
+					q.pending[w] = map[int64]int{}
+				}
+				q.pending[w][id] += n
+			}
+			q.mu.Unlock()
+		}
+	}
+	usage, err := q.store.Usage(ctx, window)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	q.mu.Lock()
+	if q.window.Equal(window) {
+		clear(q.counted)
+		for _, u := range usage {
+			q.counted[u.Token.ID] = u.Requests
+		}
+	}
+	q.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// flushQuotas flushes every quotaFlushInterval, and once more on Shutdown
+// so no counted call is lost.
+func (app *App) flushQuotas(ctx context.Context) {
+	t := time.NewTicker(quotaFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := app.quotas.flush(ctx); err != nil {
+				app.logger.Warn("failed to write api usage", "error", err)
+			}
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), quotaFlushInterval)
+			defer cancel()
+			if err := app.quotas.flush(ctx); err != nil {
+				app.logger.Error("failed to write api usage on shutdown", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// withAPIQuota holds calls that carry an API token to the token's hourly
+// quota, answering 429 once it is used up, and to every call it lets
+// through adds X-RateLimit-Limit, -Remaining and -Reset, the Unix time the
+// window ends. Calls without a token are let through as they are; ones
+// with an unknown token get 401.
+func (app *App) withAPIQuota(next http.Handler) http.Handler {
+	if app.quotas == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, err := app.quotas.lookup(r.Context(), secret)
+		if errors.Is(err, store.ErrUnknownToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			writeJSONError(w, r, http.StatusUnauthorized, "invalid_token", "The API token is not valid.")
+			return
+		}
+		if err != nil {
+			app.respondError(w, r, fmt.Errorf("look up api token: %w", err))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), apiTokenKey, t)
+		// Checking usage is free, so a token that used up its quota can
+		// still see when it resets.
+		if r.URL.Path == "/api/usage" {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		used, reset, ok := app.quotas.take(t)
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(t.RequestsPerHour))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(max(0, t.RequestsPerHour-used)))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if !ok {
+			app.metrics.rateLimited.WithLabelValues("api_quota").Inc()
+			h.Set("Retry-After", strconv.Itoa(max(1, int(reset.Sub(app.clock.Now()).Seconds()))))
+			writeJSONError(w, r, http.StatusTooManyRequests, "quota_exceeded", "The API token's hourly quota is used up.")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type usageResponse struct {
+	Token       store.APIToken `json:"token"`
+	WindowStart time.Time      `json:"window_start"`
+	ResetAt     time.Time      `json:"reset_at"`
+	Requests    int            `json:"requests"`
+	Remaining   int            `json:"remaining"`
+}
+
+// handleUsage shows the calling token its use of the current window.
+func (app *App) handleUsage(w http.ResponseWriter, r *http.Request) {
+	t, ok := r.Context().Value(apiTokenKey).(store.APIToken)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeJSONError(w, r, http.StatusUnauthorized, "unauthorized", "An API token is required.")
+		return
+	}
+	used, window := app.quotas.used(t)
+	app.writeJSON(w, r, http.StatusOK, usageResponse{
+		Token:       t,
+		WindowStart: window,
+		ResetAt:     window.Add(quotaWindow),
+		Requests:    used,
+		Remaining:   max(0, t.RequestsPerHour-used),
+	})
+}
+
+type allUsageResponse struct {
+	WindowStart time.Time          `json:"window_start"`
+	Tokens      []store.TokenUsage `json:"tokens"`
+}
+
+// handleAllUsage lists every token's use of the current window, after
+// writing this instance's.
+func (app *App) handleAllUsage(w http.ResponseWriter, r *http.Request) {
+	if app.quotas == nil {
+		app.writeJSON(w, r, http.StatusOK, allUsageResponse{Tokens: []store.TokenUsage{}})
+		return
+	}
+	if err := app.quotas.flush(r.Context()); err != nil {
+		app.respondError(w, r, fmt.Errorf("write api usage: %w", err))
+		return
+	}
+	window := app.clock.Now().UTC().Truncate(quotaWindow)
+	usage, err := app.quotas.store.Usage(r.Context(), window)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("list api usage: %w", err))
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, allUsageResponse{WindowStart: window, Tokens: usage})
+}
+
+type createTokenRequest struct {
+	Name            string `json:"name"`
+	RequestsPerHour int    `json:"requests_per_hour"`
+}
+
+type createTokenResponse struct {
+	store.APIToken
+	// Secret is only ever shown here.
+	Secret string `json:"secret"`
+}
+
+// handleCreateToken issues an API token and returns its secret.
+func (app *App) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if app.quotas == nil {
+		writeJSONError(w, r, http.StatusNotImplemented, "unsupported", "API tokens need the Postgres store.")
+		return
+	}
+	var req createTokenRequest
+	if !decodeJSON(w, r, &req, "Request body must be a JSON object.") {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || req.RequestsPerHour <= 0 {
+		writeJSONError(w, r, http.StatusUnprocessableEntity, "validation_failed", "A name and a positive requests_per_hour are required.")
+		return
+	}
+	// The real generator's ids are 96 random bits, too many to guess
+	// through the API.
+	secret := app.ids.NewID()
+	hash := sha256.Sum256([]byte(secret))
+	t, err := app.quotas.store.CreateAPIToken(r.Context(), name, hash[:], req.RequestsPerHour)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("create api token: %w", err))
+		return
+	}
+	app.writeJSON(w, r, http.StatusCreated, createTokenResponse{APIToken: t, Secret: secret})
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/store"
+)
+
+// localRatesMax is how many keys localRates holds at most.
+const localRatesMax = 10_000
+
+// rateLimiter allows each key limit hits in any window. The counts are
+// kept by shared when the store can keep them for every instance, and by
+// local otherwise or while shared fails, in which case each instance
+// allows the limit by itself.
+type rateLimiter struct {
+	shared store.RateCounter
+	local  *localRates
+	limit  int
+	window time.Duration
+}
+
+func newRateLimiter(users store.UserStore, clk clock.Clock, limit int, window time.Duration) *rateLimiter {
+	rl := &rateLimiter{local: newLocalRates(clk, localRatesMax), limit: limit, window: window}
+	rl.shared, _ = users.(store.RateCounter)
+	return rl
+}
+
+// localRates is the in-process store.RateCounter. Its keys are kept in an
+// LRU list: each hit drops the keys at the back whose windows no longer
+// count, and once max keys are held the least recently hit one goes too.
+type localRates struct {
+	clock   clock.Clock
+	max     int
+	mu      sync.Mutex
+	windows map[string]*list.Element
+	// lru holds the *localRate of every key, most recently hit first.
+	lru *list.List
+}
+
+type localRate struct {
+	key string
+	win store.RateWindow
+}
+
+func newLocalRates(clk clock.Clock, max int) *localRates {
+	return &localRates{clock: clk, max: max, windows: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (c *localRates) CountHit(ctx context.Context, key string, window time.Duration) (store.RateWindow, error) {
+	now := c.clock.Now()
+	start := now.Truncate(window)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The list is in order of last hit, so the keys at its back are the
+	// only ones whose windows can have run out.
+	for e := c.lru.Back(); e != nil && e.Value.(*localRate).win.Start.Before(start.Add(-window)); e = c.lru.Back() {
+		c.remove(e)
+	}
+
+	e, ok := c.windows[key]
+	if !ok {
+		if c.lru.Len() >= c.max {
+			c.remove(c.lru.Back())
+		}
+		e = c.lru.PushFront(&localRate{key: key})
+		c.windows[key] = e
+	}
+	c.lru.MoveToFront(e)
+	rate := e.Value.(*localRate)
+	w := rate.win
+	switch {
+	case ok && w.Start.Equal(start):
+	case ok && w.Start.Equal(start.Add(-window)):
+		w.PrevHits, w.Hits = w.Hits, 0
+	default:
+		w.PrevHits, w.Hits = 0, 0
+	}
+	w.Start, w.Now = start, now
+	w.Hits++
+	rate.win = w
+	return w, nil
+}
+
+func (c *localRates) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.windows, e.Value.(*localRate).key)
+}
+
+// rateLimited answers 429 with Retry-After to a client that has called h,
+// or any other handler limited under the same name, too often. Hits are
+// counted per client IP whether or not they are allowed, so a client that
+// keeps retrying stays limited.
+func (app *App) rateLimited(name string, h http.HandlerFunc) http.HandlerFunc {
+	rl := app.rateLimit
+	if rl == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := name + ":" + app.clientIP(r)
+		var (
+			win store.RateWindow
+			err error
+		)
+		if rl.shared != nil {
+			win, err = rl.shared.CountHit(r.Context(), key, rl.window)
+			if err != nil {
+				app.requestLogger(r).Warn("shared rate limit unavailable, counting locally", "error", err)
+			}
+		}
+		if rl.shared == nil || err != nil {
+			win, _ = rl.local.CountHit(r.Context(), key, rl.window)
+		}
+
+		ok, retryAfter := win.Allow(rl.limit, rl.window)
+		if ok {
+			h(w, r)
+			return
+		}
+		app.metrics.rateLimited.WithLabelValues(name).Inc()
+		wait := max(1, int(math.Ceil(retryAfter.Seconds())))
+		w.Header().Set("Retry-After", strconv.Itoa(wait))
+		if isAPIRequest(r) {
+			writeJSONError(w, r, http.StatusTooManyRequests, "rate_limited", "Too many requests. Please retry later.")
+			return
+		}
+		app.renderError(w, r, http.StatusTooManyRequests, fmt.Sprintf("Slow down! You're doing that too often. Please wait %d seconds and try again.", wait))
+	}
+}
@@ -0,0 +1,50 @@
+package web
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"exam/internal/clock"
+)
+
+func TestLocalRates(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFixed(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	c := newLocalRates(clk, 3)
+	hit := func(key string) int {
+		w, _ := c.CountHit(ctx, key, time.Minute)
+		return w.Hits
+	}
+
+	hit("a")
+	hit("b")
+	hit("c")
+	if got := hit("a"); got != 2 {
+		t.Errorf("second hit of a = %d, want 2", got)
+	}
+	// b is now the least recently hit and makes room for d.
+	hit("d")
+	if c.lru.Len() != 3 {
+		t.Errorf("%d keys held, want at most 3", c.lru.Len())
+	}
+	if _, ok := c.windows["b"]; ok {
+		t.Error("b still held after d pushed it out")
+	}
+	if got := hit("a"); got != 3 {
+		t.Errorf("third hit of a = %d, want its count kept", got)
+	}
+
+	// A window later the counts carry over as the previous window's; two
+	// windows later they no longer count and are dropped.
+	clk.Advance(time.Minute)
+	w, _ := c.CountHit(ctx, "a", time.Minute)
+	if w.Hits != 1 || w.PrevHits != 3 {
+		t.Errorf("a a window later = %d hits, %d previous; want 1, 3", w.Hits, w.PrevHits)
+	}
+	clk.Advance(2 * time.Minute)
+	hit("e")
+	if c.lru.Len() != 1 || len(c.windows) != 1 {
+		t.Errorf("%d keys held after their windows ran out, want only e", c.lru.Len())
+	}
+}
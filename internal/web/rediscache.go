@@ -0,0 +1,147 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// redisOpTimeout bounds each cache command, so a slow Redis costs a request
+// no more than this before it falls back.
+const redisOpTimeout = 100 * time.Millisecond
+
+// redisCache is the bodyCache shared between instances through Redis.
+// Entries live under a version that invalidate bumps and announces on a
+// channel; every instance follows the announcements, so its next lookup
+// uses the new version and the old entries are left to expire. While Redis
+// can't be reached, lookups go to the in-process fallback instead.
+type redisCache struct {
+	client   *redis.Client
+	logger   *slog.Logger
+	ttl      time.Duration
+	prefix   string
+	fallback *responseCache
+	flight   singleflight.Group
+	// version is the entries' current version as last heard from Redis.
+	version atomic.Int64
+}
+
+// newRedisCache connects to the Redis at url, where name namespaces the
+// cache's keys and channel.
+func newRedisCache(url, name string, ttl time.Duration, fallback *responseCache, logger *slog.Logger) (*redisCache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redis url: %w", err)
+	}
+	return &redisCache{
+		client:   redis.NewClient(opts),
+		logger:   logger.With("cache", name),
+		ttl:      ttl,
+		prefix:   "exam:cache:" + name + ":",
+		fallback: fallback,
+	}, nil
+}
+
+func (c *redisCache) versionKey() string { return c.prefix + "version" }
+func (c *redisCache) channel() string    { return c.prefix + "invalidate" }
+
+func (c *redisCache) get(ctx context.Context, key string, fill func(ctx context.Context) ([]byte, error)) ([]byte, bool, error) {
+	entry := c.prefix + strconv.FormatInt(c.version.Load(), 10) + ":" + key
+	opCtx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	body, err := c.client.Get(opCtx, entry).Bytes()
+	cancel()
+	switch {
+	case err == nil:
+		return body, true, nil
+	case !errors.Is(err, redis.Nil):
+		c.logger.Debug("redis cache unavailable, using local cache", "error", err)
+		return c.fallback.get(ctx, key, fill)
+	}
+
+	body, err = fillOnce(ctx, &c.flight, entry, fill)
+	if err != nil {
+		return nil, false, err
+	}
+	opCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), redisOpTimeout)
+	defer cancel()
+	if err := c.client.Set(opCtx, entry, body, c.ttl).Err(); err != nil {
+		c.logger.Debug("failed to store in redis cache", "error", err)
+	}
+	return body, false, nil
+}
+
+// invalidate bumps the version for every instance. The local fallback is
+// dropped here too, and on the others when they hear of it.
+func (c *redisCache) invalidate(ctx context.Context) {
+	c.fallback.invalidate(ctx)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisOpTimeout)
+	defer cancel()
+	v, err := c.client.Incr(ctx, c.versionKey()).Result()
+	if err == nil {
+		c.version.Store(v)
+		err = c.client.Publish(ctx, c.channel(), v).Err()
+	}
+	if err != nil {
+		// Other instances serve what they have until the TTL runs out.
+		c.logger.Warn("failed to invalidate redis cache", "error", err)
+	}
+}
+
+// follow tracks other instances' invalidations until ctx is done, then
+// closes the client. The subscription reconnects by itself; the version is
+// reread on every (re)subscription, since announcements made while it was
+// down are lost.
+func (c *redisCache) follow(ctx context.Context) {
+	defer c.client.Close()
+	sub := c.client.Subscribe(ctx, c.channel())
+	defer sub.Close()
+	for {
+		msg, err := sub.Receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			c.refreshVersion(ctx)
+		case *redis.Message:
+			if v, err := strconv.ParseInt(msg.Payload, 10, 64); err == nil && v > c.version.Load() {
+				c.version.Store(v)
+				c.fallback.invalidate(ctx)
+			}
+		}
+		if err != nil {
+			c.logger.Debug("redis subscription interrupted", "error", err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (c *redisCache) refreshVersion(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+	v, err := c.client.Get(ctx, c.versionKey()).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		c.logger.Warn("failed to read redis cache version", "error", err)
+		return
+	}
+	if v != c.version.Load() {
+		c.version.Store(v)
+		c.fallback.invalidate(ctx)
+	}
+}
+
+// ping is the readiness detail for Redis.
+func (c *redisCache) ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"exam/internal/store"
+)
+
+type resetResponse struct {
+	Removed map[string]int `json:"removed"`
+}
+
+// handleReset empties every table the app owns and restarts the ids, for
+// end-to-end suites to start each run from nothing. It is only routed when
+// ENABLE_TEST_ENDPOINTS is set.
+func (app *App) handleReset(w http.ResponseWriter, r *http.Request) {
+	rs, ok := app.users.(store.Resetter)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotImplemented, "unsupported", "This store can't be reset.")
+		return
+	}
+	removed, err := rs.Reset(r.Context())
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("reset store: %w", err))
+		return
+	}
+	if app.usersCache != nil {
+		app.usersCache.invalidate(r.Context())
+	}
+	app.requestLogger(r).Warn("store reset", "removed", removed)
+	app.writeJSON(w, r, http.StatusOK, resetResponse{Removed: removed})
+}
@@ -0,0 +1,70 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The reset endpoint exists only with ENABLE_TEST_ENDPOINTS, and then only
+// for the internal token.
+func TestResetGating(t *testing.T) {
+	reset := func(app *App, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/_internal/reset", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(app.Handler(), r)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		token   string
+	}{
+		{"disabled", false, "secret"},
+		{"enabled without an internal token", true, ""},
+	} {
+		cfg := testConfig()
+		cfg.TestEndpoints, cfg.InternalToken = tc.enabled, tc.token
+		app, _ := newTestApp(t, WithConfig(cfg))
+		for _, token := range []string{"", "secret"} {
+			if rec := reset(app, token); rec.Code != http.StatusNotFound {
+				t.Errorf("%s: reset with token %q = %d, want 404", tc.name, token, rec.Code)
+			}
+		}
+	}
+
+	cfg := testConfig()
+	cfg.TestEndpoints, cfg.InternalToken = true, "secret"
+	app, _ := newTestApp(t, WithConfig(cfg))
+	for _, name := range []string{"Ada", "Grace"} {
+		if _, err := app.users.Create(t.Context(), name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, token := range []string{"", "wrong"} {
+		if rec := reset(app, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("reset with token %q = %d, want 401", token, rec.Code)
+		}
+	}
+	if n, _ := app.users.Count(t.Context()); n != 2 {
+		t.Fatalf("%d users after refused resets, want 2", n)
+	}
+
+	rec := reset(app, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var body resetResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Removed["users"] != 2 {
+		t.Errorf("reset removed %v, want 2 users", body.Removed)
+	}
+	if n, _ := app.users.Count(t.Context()); n != 0 {
+		t.Errorf("%d users after reset, want none", n)
+	}
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"exam/internal/clock"
+)
+
+// bodyCache caches encoded response bodies. get returns the body cached
+// under key or fills it with fill, hit saying which; invalidate drops every
+// entry. Neither may fail a request because the cache itself is down.
+type bodyCache interface {
+	get(ctx context.Context, key string, fill func(ctx context.Context) ([]byte, error)) (body []byte, hit bool, err error)
+	invalidate(ctx context.Context)
+}
+
+// responseCacheMax bounds how many keys a responseCache holds, since they
+// come from query strings.
+const responseCacheMax = 1024
+
+// responseCache is the in-process bodyCache, keeping bodies for ttl.
+// Concurrent misses on one key share a single fill, and invalidate drops
+// everything at once.
+// Only this instance's changes invalidate it; others' show up once the TTL
+// runs out.
+type responseCache struct {
+	clock  clock.Clock
+	ttl    time.Duration
+	flight singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]cachedBody
+	// gen counts invalidations, so a fill that started before one neither
+	// stores its result nor is joined by requests that came after.
+	gen uint64
+}
+
+type cachedBody struct {
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(clk clock.Clock, ttl time.Duration) *responseCache {
+	return &responseCache{clock: clk, ttl: ttl, entries: map[string]cachedBody{}}
+}
+
+// get misses when this call, or one it waited on, ran fill.
+func (c *responseCache) get(ctx context.Context, key string, fill func(ctx context.Context) ([]byte, error)) (body []byte, hit bool, err error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	gen := c.gen
+	c.mu.Unlock()
+	if ok && c.clock.Now().Before(e.expires) {
+		return e.body, true, nil
+	}
+
+	body, err = fillOnce(ctx, &c.flight, strconv.FormatUint(gen, 10)+" "+key, fill)
+	if err != nil {
+		return nil, false, err
+	}
+	c.store(gen, key, body)
+	return body, false, nil
+}
+
+// fillOnce runs fill for key unless a call for the same key is already
+// running, in which case it waits for that one's result.
+func fillOnce(ctx context.Context, flight *singleflight.Group, key string, fill func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	v, err, _ := flight.Do(key, func() (any, error) {
+		// Waiters shouldn't fail because the request that went first was
+		// cancelled, but its deadline still holds.
+		fillCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			fillCtx, cancel = context.WithDeadline(fillCtx, deadline)
+			defer cancel()
+		}
+		return fill(fillCtx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+func (c *responseCache) store(gen uint64, key string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	now := c.clock.Now()
+	if len(c.entries) >= responseCacheMax {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= responseCacheMax {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = cachedBody{body: body, expires: now.Add(c.ttl)}
+}
+
+func (c *responseCache) invalidate(context.Context) {
+	c.mu.Lock()
+	c.gen++
+	clear(c.entries)
+	c.mu.Unlock()
+}
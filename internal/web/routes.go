@@ -0,0 +1,147 @@
+package web
+
+import (
+	"net/http"
+
+	"exam/internal/config"
+)
+
+// handler registers the routes on app.mux and wraps it in the middleware
+// stacks. It is called once per App.
+//
+// Every request goes through base, outermost first: panic recovery,
+// request id, debug logging, access log, then latency metrics. Recovery
+// comes first so a panic in any middleware is caught too; the access log
+// and latency metrics still record a request that panics, as the 500
+// recovery answers it with. Each route then adds the stack for its kind:
+//
+//   - public, for pages and assets: compression, cache policy, maintenance
+//     mode, the concurrency limit, the database guard, the request timeout
+//     and the body limit.
+//   - api, for the JSON routes: public behind a 406 for clients that
+//     don't accept JSON, and API token quotas within it.
+//   - internal, for probes, metrics and the admin endpoints: compression,
+//     cache policy, the timeout and the body limit. They stay up during
+//     maintenance and never wait on the database. Admin endpoints also
+//     require the internal token.
+//   - unlimited, for restores: internal without the body limit.
+func (app *App) handler(cfg config.Config) http.Handler {
+	base := Chain(app.recoverPanics, app.withRequestID, app.debugRequests, app.accessLog, app.trackLatency)
+	public := Chain(app.compress, app.cacheControl, app.withMaintenance, app.limitConcurrency, app.guardDB, app.withTimeout, app.limitBody)
+	api := Chain(app.acceptJSON, public, app.withAPIQuota)
+	internal := Chain(app.compress, app.cacheControl, app.withTimeout, app.limitBody)
+	unlimited := Chain(app.compress, app.cacheControl, app.withTimeout)
+
+	mux := app.mux
+	handle := func(pattern string, stack Middleware, h http.HandlerFunc) {
+		mux.Handle(pattern, stack(h))
+	}
+	handle("GET /{$}", public, app.handleHome)
+	handle("POST /{$}", public, app.rateLimited("create_user", app.handleAddUser))
+	handle("GET "+staticPrefix, public, app.assets.ServeHTTP)
+	handle("GET /users/{id}", public, app.handleUser)
+	handle("POST /users/bulk", public, app.rateLimited("create_user", app.handleBulkAdd))
+	handle("GET /users/fragment", public, app.handleUsersFragment)
+	handle("POST /users/update", public, app.handleUpdateUser)
+	handle("POST /users/delete", public, app.handleDeleteUser)
+	handle("POST /users/undo", public, app.handleUndoDelete)
+	handle("GET /trash", public, app.handleTrash)
+	handle("POST /trash/{id}/restore", public, app.handleRestoreTrashed)
+	handle("GET /version", public, app.handleVersion)
+	handle("GET /api/users", api, app.handleGetUsers)
+	handle("POST /api/users", api, app.rateLimited("create_user", app.handleCreateUser))
+	handle("GET /api/users/{id}", api, app.handleGetUser)
+	handle("POST /api/users/{id}/merge", api, app.handleMergeUsers)
+	handle("GET /api/users/trash", api, app.handleGetTrash)
+	handle("POST /api/users/{id}/restore", api, app.handleRestoreUser)
+	handle("GET /api/stats", api, app.handleStats)
+	handle("GET /api/usage", api, app.handleUsage)
+	handle("GET /_internal/livez", internal, app.handleLivez)
+	handle("GET /_internal/readyz", internal, app.handleReadyz)
+	// Kept for existing probes and the Docker HEALTHCHECK; same as readyz.
+	handle("GET /_internal/health", internal, app.handleReadyz)
+	handle("GET /metrics", internal, app.metrics.handler().ServeHTTP)
+
+	// Debug endpoints live on their own port when DEBUG_PORT is set, so they
+	// are never reachable through the public listener.
+	if cfg.DebugPort == "" {
+		handle(debugPrefix, internal, app.debugMux(cfg.EnablePprof).ServeHTTP)
+	}
+	handle("GET /_internal/loglevel", internal, app.requireInternalAuth(app.handleLogLevel))
+	handle("PUT /_internal/loglevel", internal, app.requireInternalAuth(app.handleLogLevel))
+	handle("GET /_internal/maintenance", internal, app.requireInternalAuth(app.handleMaintenance))
+	handle("PUT /_internal/maintenance", internal, app.requireInternalAuth(app.handleMaintenance))
+	handle("GET /_internal/flags", internal, app.requireInternalAuth(app.handleFlags))
+	handle("PUT /_internal/flags", internal, app.requireInternalAuth(app.handleFlags))
+	handle("GET /_internal/pool", internal, app.requireInternalAuth(app.handlePool))
+	handle("GET /_internal/slo", internal, app.requireInternalAuth(app.handleSLO))
+	handle("GET /_internal/jobs/dead", internal, app.requireInternalAuth(app.handleDeadJobs))
+	handle("GET /_internal/usage", internal, app.requireInternalAuth(app.handleAllUsage))
+	handle("POST /_internal/tokens", internal, app.requireInternalAuth(app.handleCreateToken))
+	handle("GET /_internal/backup", internal, app.requireInternalAuth(app.handleBackup))
+	handle("POST /_internal/restore", unlimited, app.requireInternalAuth(app.handleRestore))
+	// Registered only for test environments, so anywhere else it is a 404
+	// whatever token is sent.
+	if cfg.TestEndpoints {
+		handle("POST /_internal/reset", internal, app.requireInternalAuth(app.handleReset))
+	}
+
+	handler := base(app.route(mux))
+	if app.tracing {
+		handler = traceHandler(handler)
+	}
+	return handler
+}
+
+// route serves requests matching a registered pattern through mux and
+// answers the rest itself, so an unknown path or method gets the same JSON
+// envelope or error page as any other failure instead of the mux's plain
+// text.
+func (app *App) route(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		// The mux's fallback tells 404 from 405 and computes Allow; run it
+		// against a recorder to learn which.
+		rec := &unmatchedRecorder{header: http.Header{}}
+		h.ServeHTTP(rec, r)
+		if rec.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", rec.header.Get("Allow"))
+			app.methodNotAllowed(w, r)
+			return
+		}
+		app.notFound(w, r)
+	})
+}
+
+// notFound answers 404 in the format the client expects: the JSON envelope
+// under /api/ and /_internal/, the error page elsewhere.
+func (app *App) notFound(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		writeJSONError(w, r, http.StatusNotFound, "not_found", "Not Found")
+		return
+	}
+	app.renderError(w, r, http.StatusNotFound, "There is nothing at this address.")
+}
+
+func (app *App) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		writeJSONError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method Not Allowed")
+		return
+	}
+	app.renderError(w, r, http.StatusMethodNotAllowed, "This address does not accept "+r.Method+" requests.")
+}
+
+// unmatchedRecorder keeps the status and headers the mux's fallback handler
+// writes and drops its body.
+type unmatchedRecorder struct {
+	header http.Header
+	status int
+}
+
+func (u *unmatchedRecorder) Header() http.Header         { return u.header }
+func (u *unmatchedRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (u *unmatchedRecorder) WriteHeader(status int)      { u.status = status }
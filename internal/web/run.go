@@ -0,0 +1,207 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"exam/internal/config"
+	"exam/internal/store"
+)
+
+// Run serves the app around users on every listener cfg asks for until
+// SIGTERM or SIGINT, then drains them and releases the app. It returns the
+// process exit code.
+func Run(cfg config.Config, users store.UserStore, logger *slog.Logger, logLevel *slog.LevelVar) int {
+	app, err := NewApp(users, WithConfig(cfg), WithLogger(logger), WithLogLevel(logLevel))
+	if err != nil {
+		logger.Error("failed to init app", "error", err)
+		return 1
+	}
+	// Every return from here releases the App. When the servers drain they
+	// set releaseBy, so the App shares their deadline and is only released
+	// once in-flight requests have finished their queries.
+	var releaseBy time.Time
+	defer func() {
+		if releaseBy.IsZero() {
+			releaseBy = time.Now().Add(cfg.ShutdownTimeout)
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), releaseBy)
+		defer cancel()
+		if err := app.Shutdown(ctx); err != nil {
+			logger.Warn("failed to release resources", "error", err)
+			return
+		}
+		logger.Info("shutdown complete")
+	}()
+
+	tlsSetup, err := loadTLS(logger, cfg.TLS)
+	if err != nil {
+		logger.Error("invalid TLS configuration", "error", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	conns := &connTracker{}
+	handler := app.Handler()
+	errorLog := slog.NewLogLogger(logger.Handler(), slog.LevelWarn)
+
+	// Listeners are opened up front so a SIGUSR2 restart can hand them to the
+	// new process, and are taken over from the previous process when this one
+	// was started that way.
+	inherited, err := loadInheritedListeners()
+	if err != nil {
+		logger.Error("failed to inherit listeners", "error", err)
+		return 1
+	}
+	open := func(name string, listen func() (net.Listener, error)) net.Listener {
+		if ln, ok := inherited[name]; ok {
+			return ln
+		}
+		ln, err := listen()
+		if err != nil {
+			logger.Error("failed to listen", "listener", name, "error", err)
+		}
+		return ln
+	}
+	tcp := func(port string) func() (net.Listener, error) {
+		return func() (net.Listener, error) { return net.Listen("tcp", cfg.Addr(port)) }
+	}
+
+	// Under systemd socket activation the inherited socket replaces the
+	// APP_HOST/APP_PORT listener.
+	activated, err := activationListener()
+	if err != nil {
+		logger.Error("socket activation failed", "error", err)
+		return 1
+	}
+
+	var listeners []listener
+	if cfg.Port != "" || activated != nil {
+		name := "http"
+		if tlsSetup != nil {
+			name = "https"
+		}
+		ln := open(name, func() (net.Listener, error) {
+			if activated != nil {
+				return activated, nil
+			}
+			return tcp(cfg.Port)()
+		})
+		if ln == nil {
+			return 1
+		}
+		srv := newHTTPServer(ln.Addr().String(), handler, cfg.Timeouts, conns)
+		srv.ErrorLog = errorLog
+		// The TLS listener negotiates HTTP/2 through ALPN; h2c only applies
+		// to the cleartext ones.
+		if cfg.EnableH2C && tlsSetup == nil {
+			enableH2C(srv)
+		}
+		if tlsSetup != nil {
+			srv.TLSConfig = tlsSetup.config
+		}
+		listeners = append(listeners, listener{name: name, srv: srv, ln: ln, tls: tlsSetup != nil})
+	}
+
+	if cfg.SocketPath != "" {
+		ln := open("unix", func() (net.Listener, error) { return listenUnix(cfg.SocketPath, cfg.SocketMode) })
+		if ln == nil {
+			return 1
+		}
+		socketSrv := newHTTPServer(cfg.SocketPath, handler, cfg.Timeouts, conns)
+		if cfg.EnableH2C {
+			enableH2C(socketSrv)
+		}
+		socketSrv.ErrorLog = errorLog
+		listeners = append(listeners, listener{name: "unix", srv: socketSrv, ln: ln})
+	}
+
+	// With TLS on, a plain HTTP listener redirects to HTTPS: on :80 in ACME
+	// mode, where it also answers challenges, or on HTTP_REDIRECT_PORT.
+	redirectPort := cfg.TLS.RedirectPort
+	var acme *autocert.Manager
+	if tlsSetup != nil && tlsSetup.acme != nil {
+		redirectPort, acme = config.ACMEHTTPPort, tlsSetup.acme
+	}
+	if tlsSetup != nil && redirectPort != "" {
+		ln := open("http-redirect", tcp(redirectPort))
+		if ln == nil {
+			return 1
+		}
+		redirect := redirectToHTTPS(cfg.TLS.CanonicalHost, cfg.Port, acme)
+		redirectSrv := newHTTPServer(ln.Addr().String(), app.recoverPanics(app.withRequestID(app.accessLog(redirect))), cfg.Timeouts, conns)
+		redirectSrv.ErrorLog = errorLog
+		listeners = append(listeners, listener{name: "http-redirect", srv: redirectSrv, ln: ln})
+	}
+
+	if cfg.DebugPort != "" {
+		ln := open("debug", tcp(cfg.DebugPort))
+		if ln == nil {
+			return 1
+		}
+		// No read/write timeouts: CPU profiles and traces stream for as long
+		// as the caller asks.
+		debugSrv := &http.Server{
+			Addr:              ln.Addr().String(),
+			Handler:           app.recoverPanics(app.withRequestID(app.accessLog(app.debugMux(cfg.EnablePprof)))),
+			ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+			ErrorLog:          errorLog,
+		}
+		listeners = append(listeners, listener{name: "debug", srv: debugSrv, ln: ln})
+	}
+
+	// Sockets the previous process had but this configuration no longer uses.
+	for name, ln := range inherited {
+		if !slices.ContainsFunc(listeners, func(l listener) bool { return l.name == name }) {
+			logger.Warn("closing unused inherited listener", "listener", name)
+			ln.Close()
+		}
+	}
+
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			logger.Info("listening", "listener", l.name, "addr", l.srv.Addr)
+			if err := l.serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- fmt.Errorf("%s listener: %w", l.name, err)
+			}
+		}()
+	}
+
+	if err := sdNotify("READY=1"); err != nil {
+		logger.Warn("failed to notify service manager", "error", err)
+	}
+	app.notifyUpgradeReady(ctx)
+	app.upgradeOnSIGUSR2(listeners, stop)
+
+	select {
+	case err := <-serveErr:
+		logger.Error("server failed", "error", err)
+		return 1
+	case <-ctx.Done():
+		stop()
+	}
+
+	sdNotify("STOPPING=1")
+	logger.Info("shutdown signal received, draining connections", "connections", conns.count(), "timeout", cfg.ShutdownTimeout.String())
+	releaseBy = time.Now().Add(cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), releaseBy)
+	defer cancel()
+	if err := shutdownAll(shutdownCtx, listeners); err != nil {
+		logger.Error("shutdown deadline exceeded", "connections", conns.count(), "error", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"exam/internal/store"
+)
+
+// schedule runs fn every interval until Shutdown. Each wait is stretched by
+// up to a tenth at random, and the first is only that random part, so
+// instances started together spread their runs out. On a store shared
+// between instances fn holds the store's lock called name, and instances
+// that find it taken skip the round.
+func (app *App) schedule(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	logger := app.logger.With("task", name)
+	app.goBackground(name, func(ctx context.Context) {
+		wait := time.Duration(0)
+		for {
+			wait += rand.N(interval/10 + 1)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			wait = interval
+
+			err := app.runExclusive(ctx, name, fn)
+			switch {
+			case err == nil:
+				app.metrics.scheduledRuns.WithLabelValues(name, "ok").Inc()
+			case errors.Is(err, store.ErrLocked):
+				app.metrics.scheduledRuns.WithLabelValues(name, "busy").Inc()
+				logger.Debug("scheduled task skipped", "error", err)
+			case ctx.Err() != nil:
+				return
+			default:
+				app.metrics.scheduledRuns.WithLabelValues(name, "error").Inc()
+				logger.Warn("scheduled task failed", "error", err)
+			}
+		}
+	})
+}
+
+func (app *App) runExclusive(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	if ex, ok := app.users.(store.Exclusive); ok {
+		return ex.RunExclusive(ctx, name, fn)
+	}
+	return fn(ctx)
+}
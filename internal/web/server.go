@@ -0,0 +1,86 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"exam/internal/config"
+)
+
+// newHTTPServer builds the server with explicit timeouts, so slow clients
+// (slowloris) can't hold connections open indefinitely.
+func newHTTPServer(addr string, handler http.Handler, t config.ServerTimeouts, conns *connTracker) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: t.ReadHeader,
+		ReadTimeout:       t.Read,
+		WriteTimeout:      t.Write,
+		IdleTimeout:       t.Idle,
+		ConnState:         conns.track,
+	}
+}
+
+// enableH2C lets srv accept prior-knowledge HTTP/2 on a cleartext listener
+// alongside HTTP/1.1. Each stream is served as its own request, so
+// per-request middleware such as the request timeout runs per stream, not
+// once for the whole HTTP/2 connection.
+func enableH2C(srv *http.Server) {
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+}
+
+// connTracker counts open client connections via http.Server.ConnState so
+// shutdown can report how many it is waiting on.
+type connTracker struct {
+	open atomic.Int64
+}
+
+func (t *connTracker) track(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		t.open.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		t.open.Add(-1)
+	}
+}
+
+func (t *connTracker) count() int64 {
+	return t.open.Load()
+}
+
+// listener is one of the HTTP servers the process runs together with the
+// socket it accepts on.
+type listener struct {
+	name string
+	srv  *http.Server
+	ln   net.Listener
+	tls  bool
+}
+
+// serve blocks until the server stops, like http.Server.ListenAndServe.
+func (l listener) serve() error {
+	if l.tls {
+		return l.srv.ServeTLS(l.ln, "", "")
+	}
+	return l.srv.Serve(l.ln)
+}
+
+// shutdownAll gracefully shuts down every listener in parallel and returns
+// the first error, typically the context deadline.
+func shutdownAll(ctx context.Context, listeners []listener) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { errs <- l.srv.Shutdown(ctx) }()
+	}
+	var first error
+	for range listeners {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
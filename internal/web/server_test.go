@@ -0,0 +1,48 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"exam/internal/config"
+)
+
+func TestEnableH2C(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Proto)) }
+	srv := newHTTPServer(ln.Addr().String(), http.HandlerFunc(proto), config.Default().Timeouts, &connTracker{})
+	enableH2C(srv)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	// A prior-knowledge client speaks HTTP/2 from the first byte, without
+	// an Upgrade.
+	h2 := &http.Transport{Protocols: new(http.Protocols)}
+	h2.Protocols.SetUnencryptedHTTP2(true)
+	defer h2.CloseIdleConnections()
+
+	for _, tc := range []struct {
+		name   string
+		client *http.Client
+		want   string
+	}{
+		{"h2c", &http.Client{Transport: h2}, "HTTP/2.0"},
+		{"http/1.1", &http.Client{}, "HTTP/1.1"},
+	} {
+		resp, err := tc.client.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := string(body); got != tc.want || resp.Proto != tc.want {
+			t.Errorf("%s: served as %q, answered as %s; want %s", tc.name, got, resp.Proto, tc.want)
+		}
+	}
+}
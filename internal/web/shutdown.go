@@ -0,0 +1,85 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// background tracks the App's long-running goroutines so that Shutdown can
+// stop them before closing the resources they use.
+type background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackground() *background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &background{ctx: ctx, cancel: cancel}
+}
+
+// goBackground runs fn in a goroutine until Shutdown cancels its context.
+func (app *App) goBackground(name string, fn func(ctx context.Context)) {
+	app.background.wg.Add(1)
+	go func() {
+		defer app.background.wg.Done()
+		fn(app.background.ctx)
+		app.logger.Debug("background task stopped", "task", name)
+	}()
+}
+
+// Shutdown releases the App's resources once the HTTP servers have drained:
+// background tasks first, since they may still be querying, then the
+// database pools or store, then the trace exporter. Each step waits at most until
+// ctx is done.
+func (app *App) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	app.background.cancel()
+	if err := waitCtx(ctx, app.background.wg.Wait); err != nil {
+		errs = append(errs, fmt.Errorf("stopping background tasks: %w", err))
+	}
+
+	if app.db != nil {
+		var conns int32
+		if pool := app.db.Pool(); pool != nil {
+			conns = pool.Stat().TotalConns()
+		}
+		// pgxpool's Close waits for acquired connections to be released.
+		if err := waitCtx(ctx, app.db.Close); err != nil {
+			errs = append(errs, fmt.Errorf("closing database pool: %w", err))
+		} else {
+			app.logger.Info("database pool closed", "connections_released", conns)
+		}
+	}
+
+	if c, ok := app.users.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing store: %w", err))
+		}
+	}
+
+	if err := app.shutdownTracing(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("flushing traces: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// waitCtx runs fn and returns when it does or when ctx is done, whichever
+// comes first.
+func waitCtx(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,238 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+	"exam/internal/reqctx"
+)
+
+const (
+	// sloWindow and sloMaxSamples bound the per-route sample buffer behind
+	// /_internal/slo: samples older than the window are ignored, and a busy
+	// route keeps only its most recent sloMaxSamples.
+	sloWindow     = 5 * time.Minute
+	sloMaxSamples = 2048
+)
+
+// requestPhases times the named parts of one request, such as "db" and
+// "render", so an over-budget request can say where its time went.
+type requestPhases struct {
+	clock clock.Clock
+	mu    sync.Mutex
+	names []string
+	spent []time.Duration
+}
+
+// startPhase starts timing phase name for the request in ctx and returns
+// the function that stops it. Without phase tracking in ctx it does nothing.
+func startPhase(ctx context.Context, name string) (stop func()) {
+	p, ok := ctx.Value(phasesKey).(*requestPhases)
+	if !ok {
+		return func() {}
+	}
+	start := p.clock.Now()
+	return func() { p.add(name, p.clock.Now().Sub(start)) }
+}
+
+func (p *requestPhases) add(name string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i := slices.Index(p.names, name); i >= 0 {
+		p.spent[i] += d
+		return
+	}
+	p.names = append(p.names, name)
+	p.spent = append(p.spent, d)
+}
+
+// slowest returns the phase that took longest, or "" if none was timed.
+func (p *requestPhases) slowest() (string, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var name string
+	var longest time.Duration
+	for i, d := range p.spent {
+		if d > longest {
+			name, longest = p.names[i], d
+		}
+	}
+	return name, longest
+}
+
+// latencyTracker keeps a sliding window of request durations per route for
+// /_internal/slo. Each route holds a ring of its latest samples.
+type latencyTracker struct {
+	clock   clock.Clock
+	budgets config.LatencyBudgets
+
+	mu     sync.Mutex
+	routes map[string]*latencyRing
+}
+
+type latencyRing struct {
+	budget  time.Duration
+	samples []latencySample
+	next    int
+}
+
+type latencySample struct {
+	at time.Time
+	d  time.Duration
+}
+
+func newLatencyTracker(clk clock.Clock, budgets config.LatencyBudgets) *latencyTracker {
+	return &latencyTracker{clock: clk, budgets: budgets, routes: map[string]*latencyRing{}}
+}
+
+func (t *latencyTracker) observe(route string, budget, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ring, ok := t.routes[route]
+	if !ok {
+		ring = &latencyRing{budget: budget}
+		t.routes[route] = ring
+	}
+	s := latencySample{at: t.clock.Now(), d: d}
+	if len(ring.samples) < sloMaxSamples {
+		ring.samples = append(ring.samples, s)
+		return
+	}
+	ring.samples[ring.next] = s
+	ring.next = (ring.next + 1) % sloMaxSamples
+}
+
+// routeLatency is one route's entry in /_internal/slo.
+type routeLatency struct {
+	Route      string  `json:"route"`
+	Count      int     `json:"count"`
+	P50Ms      float64 `json:"p50_ms"`
+	P95Ms      float64 `json:"p95_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	BudgetMs   float64 `json:"budget_ms"`
+	OverBudget int     `json:"over_budget"`
+}
+
+type sloReport struct {
+	WindowS float64        `json:"window_s"`
+	Routes  []routeLatency `json:"routes"`
+}
+
+func (t *latencyTracker) report() sloReport {
+	cutoff := t.clock.Now().Add(-sloWindow)
+	out := sloReport{WindowS: sloWindow.Seconds(), Routes: []routeLatency{}}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for route, ring := range t.routes {
+		var ds []time.Duration
+		for _, s := range ring.samples {
+			if s.at.After(cutoff) {
+				ds = append(ds, s.d)
+			}
+		}
+		if len(ds) == 0 {
+			continue
+		}
+		slices.Sort(ds)
+		budget := ring.budget
+		rl := routeLatency{
+			Route:    route,
+			Count:    len(ds),
+			P50Ms:    ms(percentile(ds, 50)),
+			P95Ms:    ms(percentile(ds, 95)),
+			P99Ms:    ms(percentile(ds, 99)),
+			BudgetMs: ms(budget),
+		}
+		if budget > 0 {
+			rl.OverBudget = len(ds) - sortedIndexAbove(ds, budget)
+		}
+		out.Routes = append(out.Routes, rl)
+	}
+	slices.SortFunc(out.Routes, func(a, b routeLatency) int { return strings.Compare(a.Route, b.Route) })
+	return out
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted ds.
+func percentile(ds []time.Duration, p int) time.Duration {
+	i := (len(ds)*p + 99) / 100
+	return ds[max(i, 1)-1]
+}
+
+// sortedIndexAbove returns the index of the first duration in sorted ds
+// that exceeds d.
+func sortedIndexAbove(ds []time.Duration, d time.Duration) int {
+	i, _ := slices.BinarySearchFunc(ds, d, func(e, target time.Duration) int {
+		if e <= target {
+			return -1
+		}
+		return 1
+	})
+	return i
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// routeOf names the route r is served by: its registered mux pattern, so
+// that /users/42 and /users/43 share one entry.
+func (app *App) routeOf(r *http.Request) string {
+	if _, pattern := app.mux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return "unmatched"
+}
+
+// trackLatency times each request of the main listener per route, feeding
+// the http_request_duration_seconds histogram and /_internal/slo, and warns
+// when a request runs past its route's budget, naming the phase that took
+// longest.
+func (app *App) trackLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		phases := &requestPhases{clock: app.clock}
+		start := app.clock.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			if !rec.hijacked {
+				app.observeLatency(r, phases, rec.finalStatus(), app.clock.Now().Sub(start))
+			}
+		}()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), phasesKey, phases)))
+		rec.finished = true
+	})
+}
+
+func (app *App) observeLatency(r *http.Request, phases *requestPhases, status int, elapsed time.Duration) {
+	route := app.routeOf(r)
+	app.metrics.requestDuration.WithLabelValues(route).Observe(elapsed.Seconds())
+	budget := app.latency.budgets.ForPath(r.URL.Path)
+	app.latency.observe(route, budget, elapsed)
+
+	if budget <= 0 || elapsed <= budget {
+		return
+	}
+	attrs := []any{
+		"request_id", reqctx.RequestID(r.Context()),
+		"method", r.Method,
+		"route", route,
+		"status", status,
+		"duration_ms", ms(elapsed),
+		"budget_ms", ms(budget),
+	}
+	if name, d := phases.slowest(); name != "" {
+		attrs = append(attrs, "slowest_phase", name, "slowest_phase_ms", ms(d))
+	}
+	app.logger.Warn("request over latency budget", attrs...)
+}
+
+// handleSLO reports p50/p95/p99 latency per route over the last sloWindow.
+func (app *App) handleSLO(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, r, http.StatusOK, app.latency.report())
+}
@@ -0,0 +1,119 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/config"
+)
+
+const (
+	// formStampMaxAge is how long a rendered add-user form can be
+	// submitted, so a bot can't fetch one stamp and post with it forever.
+	// The homepage's ETag turns over every half of it, so a revalidated
+	// page never carries a stamp that has run out.
+	formStampMaxAge = 24 * time.Hour
+	// honeypotField is an input the homepage form hides from people. Bots
+	// that fill in every field fill it in too.
+	honeypotField = "website"
+	stampField    = "form_stamp"
+)
+
+// formGuard signs the time the add-user form was rendered, so its
+// submission can show it wasn't posted faster than a person types.
+type formGuard struct {
+	clock   clock.Clock
+	key     []byte
+	minFill time.Duration
+}
+
+func newFormGuard(clk clock.Clock, key []byte, minFill time.Duration) *formGuard {
+	return &formGuard{clock: clk, key: key, minFill: minFill}
+}
+
+// formKey is the key form stamps and undo links are signed with: FORM_SECRET,
+// which deployments running more than one instance must set, the same on
+// each, for a form rendered by one to be accepted by another. Without it
+// the key is random per process, which only suits a single instance and is
+// logged as such.
+func formKey(logger *slog.Logger, cfg config.Config) []byte {
+	if cfg.FormSecret != "" {
+		return []byte(cfg.FormSecret)
+	}
+	logger.Warn("FORM_SECRET is not set; form stamps and undo links signed by this process stop working after a restart and on other instances")
+	key := make([]byte, sha256.Size)
+	rand.Read(key)
+	return key
+}
+
+// stamp returns the form's render time and its signature.
+func (g *formGuard) stamp() string {
+	ms := strconv.FormatInt(g.clock.Now().UnixMilli(), 10)
+	return ms + "." + g.sign(ms)
+}
+
+func (g *formGuard) sign(ms string) string {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write([]byte(ms))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// check returns why a submission carrying stamp looks automated, or "" if
+// it doesn't: "stale" when the stamp is missing, forged or too old, and
+// "too_fast" when it was posted within minFill of the render.
+func (g *formGuard) check(stamp string) string {
+	ms, sig, ok := strings.Cut(stamp, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(g.sign(ms))) {
+		return "stale"
+	}
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return "stale"
+	}
+	age := g.clock.Now().Sub(time.UnixMilli(n))
+	switch {
+	case age > formStampMaxAge:
+		return "stale"
+	case age < g.minFill:
+		return "too_fast"
+	}
+	return ""
+}
+
+// rejectSpam answers submissions of the add-user form that look automated
+// and reports whether it did. A filled honeypot gets the same redirect as
+// a user added, so its bot learns nothing. A stale or hurried one gets the
+// form back with what was typed and a fresh stamp, so a person only has
+// to submit it again.
+func (app *App) rejectSpam(w http.ResponseWriter, r *http.Request, page homePage) bool {
+	reason := ""
+	if r.PostFormValue(honeypotField) != "" {
+		reason = "honeypot"
+	} else if app.forms.minFill > 0 {
+		reason = app.forms.check(r.PostFormValue(stampField))
+	}
+	if reason == "" {
+		return false
+	}
+	app.metrics.spamRejected.WithLabelValues(reason).Inc()
+	app.requestLogger(r).Debug("rejected add-user form", "reason", reason)
+	switch reason {
+	case "honeypot":
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	case "too_fast":
+		page.Error = "That was quick! Please check the form and submit it again."
+		app.renderHome(w, r, http.StatusUnprocessableEntity, page)
+	default:
+		page.Error = "The form had expired. Please submit it again."
+		app.renderHome(w, r, http.StatusUnprocessableEntity, page)
+	}
+	return true
+}
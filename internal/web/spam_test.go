@@ -0,0 +1,110 @@
+package web
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"exam/internal/clock"
+)
+
+func TestFormGuard(t *testing.T) {
+	clk := clock.NewFixed(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	g := newFormGuard(clk, []byte("secret"), 3*time.Second)
+	stamp := g.stamp()
+
+	if got := g.check(stamp); got != "too_fast" {
+		t.Errorf("check right after render = %q, want too_fast", got)
+	}
+	clk.Advance(5 * time.Second)
+	if got := g.check(stamp); got != "" {
+		t.Errorf("check after 5s = %q, want none", got)
+	}
+	ms, sig, _ := strings.Cut(stamp, ".")
+	earlier := strings.Replace(ms, "1", "0", 1)
+	for _, forged := range []string{"", "garbage", ms, earlier + "." + sig, ms + "." + sig + "x"} {
+		if got := g.check(forged); got != "stale" {
+			t.Errorf("check(%q) = %q, want stale", forged, got)
+		}
+	}
+	if got := newFormGuard(clk, []byte("other"), 3*time.Second).check(stamp); got != "stale" {
+		t.Errorf("check with another key = %q, want stale", got)
+	}
+	clk.Advance(formStampMaxAge)
+	if got := g.check(stamp); got != "stale" {
+		t.Errorf("check after %s = %q, want stale", formStampMaxAge, got)
+	}
+}
+
+func TestFormKey(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	cfg := testConfig()
+	cfg.FormSecret = "secret"
+	if key := formKey(logger, cfg); string(key) != "secret" {
+		t.Errorf("key with FORM_SECRET = %q, want it", key)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("FORM_SECRET logged %q", logs.String())
+	}
+
+	// Without it the key is random, even on Postgres: the database password
+	// is no secret to sign with.
+	cfg.FormSecret = ""
+	cfg.Store = "postgres"
+	cfg.DB.Password = "p@ss"
+	if a, b := formKey(logger, cfg), formKey(logger, cfg); bytes.Equal(a, b) {
+		t.Error("keys without FORM_SECRET are not random")
+	}
+	if !strings.Contains(logs.String(), "FORM_SECRET is not set") {
+		t.Errorf("a random key wasn't warned about; logged %q", logs.String())
+	}
+}
+
+func TestRejectSpam(t *testing.T) {
+	clk := clock.NewFixed(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	cfg := testConfig()
+	cfg.FormMinFillTime = 3 * time.Second
+	app, _ := newTestApp(t, WithConfig(cfg), WithClock(clk))
+	h := app.Handler()
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(h, r)
+	}
+	count := func() int {
+		n, _ := app.users.Count(t.Context())
+		return n
+	}
+	stamp := app.forms.stamp()
+
+	rec := post(url.Values{"name": {"Bot"}, stampField: {stamp}, honeypotField: {"http://spam.example"}})
+	if rec.Code != http.StatusSeeOther || count() != 0 {
+		t.Errorf("honeypot filled in = %d with %d users, want a 303 adding none", rec.Code, count())
+	}
+
+	rec = post(url.Values{"name": {"Ada"}, stampField: {stamp}})
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "That was quick!") || count() != 0 {
+		t.Errorf("posted right after render = %d with %d users, want 422 asking to submit again", rec.Code, count())
+	}
+	if !strings.Contains(rec.Body.String(), `value="Ada"`) {
+		t.Error("the form came back without what was typed")
+	}
+
+	clk.Advance(5 * time.Second)
+	rec = post(url.Values{"name": {"Ada"}, stampField: {stamp + "x"}})
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "The form had expired.") || count() != 0 {
+		t.Errorf("tampered stamp = %d with %d users, want 422 saying the form expired", rec.Code, count())
+	}
+
+	rec = post(url.Values{"name": {"Ada"}, stampField: {stamp}})
+	if rec.Code != http.StatusSeeOther || count() != 1 {
+		t.Errorf("posted after 5s = %d with %d users, want a 303 adding Ada", rec.Code, count())
+	}
+}
@@ -0,0 +1,161 @@
+// Progressive enhancements for the server-rendered pages:
+//   - infinite scroll for the homepage user table (the sentinel's "Next page"
+//     link still works as plain pagination without JavaScript);
+//   - the add-user modal, which creates users through the JSON API and
+//     inserts the row optimistically before the server answers.
+(function () {
+  "use strict";
+
+  function initInfiniteScroll() {
+    var sentinel = document.getElementById("users-sentinel");
+    var body = document.getElementById("users-body");
+    if (!sentinel || !body || !("IntersectionObserver" in window)) {
+      return;
+    }
+
+    var link = sentinel.querySelector("a");
+    if (link) {
+      link.hidden = true;
+    }
+
+    var after = sentinel.dataset.nextAfter;
+    var loading = false;
+
+    var observer = new IntersectionObserver(function (entries) {
+      if (!entries.some(function (e) { return e.isIntersecting; }) || loading) {
+        return;
+      }
+      loading = true;
+      fetch("/users/fragment?after=" + encodeURIComponent(after), { headers: { Accept: "text/html" } })
+        .then(function (res) {
+          if (!res.ok) {
+            throw new Error("HTTP " + res.status);
+          }
+          return res.text().then(function (html) {
+            return { html: html.trim(), more: res.headers.get("X-More") !== "false" };
+          });
+        })
+        .then(function (page) {
+          if (page.html) {
+            body.insertAdjacentHTML("beforeend", page.html);
+            var rows = body.querySelectorAll("tr[data-user-id]");
+            after = rows[rows.length - 1].dataset.userId;
+          }
+          if (!page.html || !page.more) {
+            observer.disconnect();
+            sentinel.remove();
+          }
+        })
+        .catch(function () {
+          // Fall back to the plain link so the user can still page manually.
+          observer.disconnect();
+          if (link) {
+            link.href = "/?after=" + encodeURIComponent(after);
+            link.hidden = false;
+          }
+        })
+        .finally(function () {
+          loading = false;
+        });
+    });
+    observer.observe(sentinel);
+  }
+
+  function fillRow(row, user) {
+    row.dataset.userId = user.id || "";
+    row.querySelector('[data-field="id"]').textContent = user.id || "\u2026";
+    var name = row.querySelector('[data-field="name"]');
+    name.textContent = user.name;
+    name.href = user.id ? "/users/" + user.id : "#";
+    row.querySelector('[data-field="email"]').textContent = user.email || "";
+    row.querySelector('input[name="id"]').value = user.id || "";
+    if (user.created_at) {
+      var created = row.querySelector('[data-field="created"]');
+      created.textContent = "just now";
+      created.title = new Date(user.created_at).toUTCString();
+    }
+  }
+
+  function showError(form, message) {
+    var el = form.querySelector('[data-field="error"]');
+    el.textContent = message;
+    el.hidden = false;
+  }
+
+  function initAddUserModal() {
+    document.querySelectorAll("[data-open-modal]").forEach(function (button) {
+      button.addEventListener("click", function () {
+        var dialog = document.getElementById(button.dataset.openModal);
+        if (dialog) {
+          dialog.showModal();
+        }
+      });
+    });
+
+    var dialog = document.getElementById("add-user-modal");
+    if (!dialog) {
+      return;
+    }
+    var form = dialog.querySelector("form");
+    dialog.querySelector("[data-close-modal]").addEventListener("click", function () {
+      dialog.close();
+    });
+
+    form.addEventListener("submit", function (event) {
+      event.preventDefault();
+      var payload = { name: form.elements.name.value, email: form.elements.email.value };
+      var body = document.getElementById("users-body");
+      var template = document.getElementById("user-row-template");
+
+      // Optimistic insert: show the row immediately, reconcile below.
+      var row = null;
+      if (body && template) {
+        row = template.content.firstElementChild.cloneNode(true);
+        row.classList.add("opacity-50");
+        fillRow(row, payload);
+        var empty = body.querySelector("td[colspan]");
+        if (empty) {
+          empty.parentElement.remove();
+        }
+        body.appendChild(row);
+      }
+      form.querySelector('[data-field="error"]').hidden = true;
+      dialog.close();
+
+      fetch(form.dataset.apiCreate, {
+        method: "POST",
+        headers: { "Content-Type": "application/json", Accept: "application/json" },
+        body: JSON.stringify(payload)
+      })
+        .then(function (res) {
+          return res.json().then(function (data) {
+            return { ok: res.ok, data: data };
+          });
+        })
+        .then(function (result) {
+          if (!result.ok) {
+            throw new Error(result.data.error ? result.data.error.message : "Failed to add user.");
+          }
+          form.reset();
+          if (!row) {
+            window.location.href = "/users/" + result.data.id;
+            return;
+          }
+          fillRow(row, result.data);
+          row.classList.remove("opacity-50");
+        })
+        .catch(function (err) {
+          if (row) {
+            row.remove();
+          }
+          showError(form, err.message || "Failed to add user.");
+          dialog.showModal();
+        });
+    });
+  }
+
+  document.addEventListener("DOMContentLoaded", function () {
+    initInfiniteScroll();
+    initAddUserModal();
+  });
+})();
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// statsRollupInterval is how often completed days are rolled up for
+	// stores that keep a rollup.
+	statsRollupInterval = time.Hour
+	// Stats cover statsDefaultDays up to today unless ?days= asks for
+	// another number, at most statsMaxDays.
+	statsDefaultDays = 30
+	statsMaxDays     = 366
+)
+
+type statsResponse struct {
+	Days  []dayStats `json:"days"`
+	Total int        `json:"total"`
+}
+
+type dayStats struct {
+	Day     string `json:"day"`
+	Signups int    `json:"signups"`
+}
+
+// handleStats reports signups per UTC day over the last ?days= days,
+// today included and so far, with days without signups as zeros.
+func (app *App) handleStats(w http.ResponseWriter, r *http.Request) {
+	days := statsDefaultDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > statsMaxDays {
+			writeJSONError(w, r, http.StatusBadRequest, "invalid_days", fmt.Sprintf("days must be between 1 and %d.", statsMaxDays))
+			return
+		}
+		days = n
+	}
+
+	today := app.clock.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-days)
+	counts, err := app.users.DailySignups(r.Context(), since)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("count signups: %w", err))
+		return
+	}
+
+	resp := statsResponse{Days: make([]dayStats, 0, days)}
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		n := 0
+		for len(counts) > 0 && !counts[0].Day.After(day) {
+			if counts[0].Day.Equal(day) {
+				n += counts[0].Signups
+			}
+			counts = counts[1:]
+		}
+		resp.Days = append(resp.Days, dayStats{Day: day.Format(time.DateOnly), Signups: n})
+		resp.Total += n
+	}
+	app.writeJSON(w, r, http.StatusOK, resp)
+}
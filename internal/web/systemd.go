@@ -0,0 +1,69 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	listenFdsStart = 3 // SD_LISTEN_FDS_START
+)
+
+// activationListener returns the first socket passed by systemd socket
+// activation, or nil when the process was not socket activated. The
+// LISTEN_* variables are cleared so child processes don't inherit them.
+func activationListener() (net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		// Only the first socket is used; close the rest so they aren't leaked.
+		for fd := listenFdsStart + 1; fd < listenFdsStart+n; fd++ {
+			syscall.Close(fd)
+		}
+	}
+
+	syscall.CloseOnExec(listenFdsStart)
+	f := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_3")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %w", err)
+	}
+	return ln, nil
+}
+
+// sdNotify sends state (e.g. "READY=1") to the service manager. It is a
+// no-op when NOTIFY_SOCKET is unset, i.e. when not running under a
+// Type=notify unit.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// Abstract namespace socket.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,145 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"exam/internal/clock"
+	"exam/internal/reqctx"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// templateSet holds one fully composed template per page plus the shared
+// partials, which can also be rendered on their own as HTML fragments.
+type templateSet struct {
+	pages    map[string]*template.Template
+	partials *template.Template
+}
+
+// parseTemplates composes layout.html and partials/ in fsys with every file
+// in pages/, keyed by the page's base name ("home" for home.html). Each page
+// defines the "content" block and may override "title" and "head".
+func parseTemplates(fsys fs.FS, clk clock.Clock, assets *assetManifest, flags featureFlags, branding Branding) (*templateSet, error) {
+	funcs := template.FuncMap{
+		"relativeTime": func(t time.Time) string { return relativeTime(clk.Now(), t) },
+		"gravatar":     gravatarURL,
+		"asset":        assets.URL,
+		"build":        func() BuildInfo { return Build },
+		"flag":         flags.enabled,
+		"brand":        func() Branding { return branding },
+	}
+	layout, err := template.New("layout.html").Funcs(funcs).ParseFS(fsys, "layout.html", "partials/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse layout: %w", err)
+	}
+
+	pages, err := fs.Glob(fsys, "pages/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("list pages: %w", err)
+	}
+
+	set := &templateSet{pages: make(map[string]*template.Template, len(pages)), partials: layout}
+	for _, page := range pages {
+		t, err := template.Must(layout.Clone()).ParseFS(fsys, page)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", page, err)
+		}
+		set.pages[strings.TrimSuffix(path.Base(page), ".html")] = t
+	}
+	return set, nil
+}
+
+// render writes the named page with a 200 status.
+func (app *App) render(w http.ResponseWriter, r *http.Request, name string, data any) {
+	app.renderStatus(w, r, http.StatusOK, name, data)
+}
+
+// renderStatus writes the named page with the given status.
+func (app *App) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	t, ok := app.templates.pages[name]
+	if !ok {
+		app.logger.Error("unknown template", "template", name)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	app.execute(w, r, status, t, "layout.html", data)
+}
+
+// errorPage is the data rendered by the "error" page.
+type errorPage struct {
+	Status    int
+	Title     string
+	Message   string
+	RequestID string
+}
+
+// renderError writes the styled error page, including the request id as a
+// reference users can quote when reporting the problem.
+func (app *App) renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Cache-Control", noStore)
+	app.renderStatus(w, r, status, "error", errorPage{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Message:   message,
+		RequestID: reqctx.RequestID(r.Context()),
+	})
+}
+
+// renderPartial writes a single partial (e.g. "user_rows") without the layout,
+// for endpoints that return HTML fragments.
+func (app *App) renderPartial(w http.ResponseWriter, r *http.Request, name string, data any) {
+	app.execute(w, r, http.StatusOK, app.templates.partials, name, data)
+}
+
+// execute renders into a buffer first so a template error turns into a clean
+// 500 instead of a half-written page.
+func (app *App) execute(w http.ResponseWriter, r *http.Request, status int, t *template.Template, name string, data any) {
+	var buf bytes.Buffer
+	start := time.Now()
+	err := t.ExecuteTemplate(&buf, name, data)
+	if dl, ok := reqctx.DebugLogger(r.Context()); ok {
+		dl.Debug("rendered template", "request_id", reqctx.RequestID(r.Context()), "template", t.Name(), "entry", name,
+			"bytes", buf.Len(), "duration_ms", ms(time.Since(start)))
+	}
+	if err != nil {
+		app.requestLogger(r).Error("failed to render template", "template", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// normalizeEmail trims and lowercases an address the way Gravatar expects.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// gravatarURL returns the Gravatar image URL for email at the given pixel
+// size, falling back to a generated identicon. Only the SHA-256 hash of the
+// address ends up in the page. It returns "" when there is no email.
+func gravatarURL(email string, size int) string {
+	email = normalizeEmail(email)
+	if email == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(email))
+	q := url.Values{"d": {"identicon"}, "s": {strconv.Itoa(size)}}
+	return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?" + q.Encode()
+}
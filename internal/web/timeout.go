@@ -0,0 +1,139 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// routeTimeouts overrides the request timeout for path prefixes; the first
+// match wins. Zero disables the timeout, for endpoints that stream.
+var routeTimeouts = []struct {
+	prefix  string
+	timeout time.Duration
+}{
+	{debugPrefix, 0},
+	{"/users/bulk", time.Minute},
+	{"/_internal/backup", 0},
+	{"/_internal/restore", 0},
+}
+
+func (app *App) timeoutFor(path string) time.Duration {
+	for _, rt := range routeTimeouts {
+		if strings.HasPrefix(path, rt.prefix) {
+			return rt.timeout
+		}
+	}
+	return app.requestTimeout
+}
+
+// withTimeout bounds each request's context so a slow query is cancelled
+// instead of holding the connection. If the deadline passes before the
+// handler has started its response, the client gets a 503 right away and
+// anything the handler writes afterwards is discarded.
+func (app *App) withTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := app.timeoutFor(r.URL.Path)
+		if d <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, h: make(http.Header), ctx: ctx}
+		fired := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			defer close(fired)
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return // the client went away; nobody to answer
+			}
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if tw.wroteHeader {
+				return
+			}
+			tw.timedOut = true
+			app.requestLogger(r).Warn("request timed out", "timeout", d.String())
+			if isAPIRequest(r) {
+				writeJSONError(w, r, http.StatusServiceUnavailable, "timeout", "The request took too long to complete.")
+				return
+			}
+			app.renderError(w, r, http.StatusServiceUnavailable, "This page took too long to load. Please try again.")
+		})
+
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		if !stop() {
+			// The timeout response may still be being written.
+			<-fired
+		}
+	})
+}
+
+// timeoutWriter lets the handler and the timeout race for the response
+// without both writing to it. The handler gets its own header map so the
+// timeout response can't pick up half-set headers. Once ctx's deadline has
+// passed the response is the timeout's, even if the handler, woken by the
+// same deadline, gets to the lock first.
+type timeoutWriter struct {
+	w   http.ResponseWriter
+	h   http.Header
+	ctx context.Context
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeaderLocked(status)
+}
+
+// expiredLocked reports whether the handler may no longer respond.
+func (tw *timeoutWriter) expiredLocked() bool {
+	if !tw.wroteHeader && errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.timedOut = true
+	}
+	return tw.timedOut
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+	if tw.expiredLocked() || tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	maps.Copy(tw.w.Header(), tw.h)
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter { return tw.w }
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = 20 * time.Millisecond
+	app, logs := newTestApp(t, WithConfig(cfg))
+
+	// A handler stuck on a query until its context is cancelled.
+	writeErr := make(chan error, 1)
+	slow := app.withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		_, err := w.Write([]byte("too late"))
+		writeErr <- err
+	}))
+
+	start := time.Now()
+	rec := serve(slow, httptest.NewRequest(http.MethodGet, "/", nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("slow handler held the request for %s", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Body.String(), "too late") {
+		t.Errorf("slow page = %d %q, want 503 without the handler's late write", rec.Code, rec.Body)
+	}
+	if err := <-writeErr; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("write after the timeout = %v, want ErrHandlerTimeout", err)
+	}
+	if len(logs.find("request timed out")) != 1 {
+		t.Error("timeout was not logged")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	rec = serve(slow, r)
+	<-writeErr
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != jsonContentType {
+		t.Errorf("slow API request = %d %s, want a 503 JSON error", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	// A response started before the deadline is the handler's to finish.
+	started := app.withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		<-r.Context().Done()
+	}))
+	if rec := serve(started, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusAccepted {
+		t.Errorf("started response = %d, want the handler's 202", rec.Code)
+	}
+
+	// Streaming routes have no deadline at all.
+	var bounded bool
+	stream := app.withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, bounded = r.Context().Deadline()
+	}))
+	serve(stream, httptest.NewRequest(http.MethodGet, "/_internal/backup", nil))
+	if bounded {
+		t.Error("/_internal/backup got a deadline")
+	}
+}
+
+func TestNewHTTPServerTimeouts(t *testing.T) {
+	cfg := testConfig()
+	srv := newHTTPServer(":0", http.NotFoundHandler(), cfg.Timeouts, &connTracker{})
+	for name, d := range map[string]time.Duration{
+		"ReadHeaderTimeout": srv.ReadHeaderTimeout,
+		"ReadTimeout":       srv.ReadTimeout,
+		"WriteTimeout":      srv.WriteTimeout,
+		"IdleTimeout":       srv.IdleTimeout,
+	} {
+		if d <= 0 {
+			t.Errorf("%s = %s, want a bound", name, d)
+		}
+	}
+}
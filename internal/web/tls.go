@@ -0,0 +1,197 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"exam/internal/config"
+)
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// certReloader serves a certificate loaded from disk and re-reads it on
+// demand, so certificates can be rotated without restarting.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate %s: %w", c.certFile, err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// reloadOnSIGHUP re-reads the certificate every time the process gets SIGHUP.
+// A failed reload keeps serving the previous certificate.
+func (c *certReloader) reloadOnSIGHUP(logger *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := c.reload(); err != nil {
+				logger.Error("certificate reload failed, keeping previous certificate", "error", err)
+				continue
+			}
+			logger.Info("certificate reloaded", "cert_file", c.certFile)
+		}
+	}()
+}
+
+// tlsSetup is how the main listener serves TLS: from certificate files or
+// from certificates obtained over ACME.
+type tlsSetup struct {
+	config *tls.Config
+	// acme is set in ACME mode, where the main listener moves to :443 and
+	// :80 answers HTTP-01 challenges.
+	acme *autocert.Manager
+}
+
+// loadTLS returns the TLS setup for the main listener, or nil when TLS is
+// off. cfg has been validated, so at most one mode is set.
+func loadTLS(logger *slog.Logger, cfg config.TLSConfig) (*tlsSetup, error) {
+	switch {
+	case cfg.DevTLS:
+		cert, fingerprint, err := selfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		logger.Warn("serving a self-signed development certificate", "sha256_fingerprint", fingerprint)
+		return &tlsSetup{config: newTLSConfig(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert, nil })}, nil
+
+	case len(cfg.ACMEDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		}
+		logger.Info("ACME enabled", "domains", cfg.ACMEDomains, "cache_dir", cfg.ACMECacheDir)
+		return &tlsSetup{config: newTLSConfig(m.GetCertificate), acme: m}, nil
+
+	case cfg.CertFile != "":
+		reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		reloader.reloadOnSIGHUP(logger)
+		return &tlsSetup{config: newTLSConfig(reloader.getCertificate)}, nil
+	}
+	return nil, nil
+}
+
+// selfSignedCertificate generates an in-memory certificate for localhost and
+// returns it with its SHA-256 fingerprint, for trusting it by hand.
+func selfSignedCertificate() (*tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{"exam development"}},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(30 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, "", fmt.Errorf("create self-signed certificate: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, strings.Join(parts, ":"), nil
+}
+
+func newTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+	}
+}
+
+// redirectToHTTPS answers every request with a 301 to the same path and
+// query on the HTTPS listener. The target host is canonicalHost when set;
+// otherwise the request's Host header, which is only used if it is a plain
+// hostname or IP. ACME HTTP-01 challenges are never redirected: acme answers
+// them when set, and without it they get a 404.
+func redirectToHTTPS(canonicalHost, httpsPort string, acme *autocert.Manager) http.Handler {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		host := canonicalHost
+		if host == "" {
+			h, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				h = r.Host
+			}
+			if !config.ValidHost(h) {
+				http.Error(w, "invalid Host header", http.StatusBadRequest)
+				return
+			}
+			host = h
+		}
+		if httpsPort != config.ACMEHTTPSPort {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), httpsPort)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+	if acme != nil {
+		return acme.HTTPHandler(redirect)
+	}
+	return redirect
+}
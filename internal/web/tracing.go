@@ -0,0 +1,59 @@
+package web
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+const serviceName = "exam"
+
+// setupTracing installs the OTel SDK when OTEL_EXPORTER_OTLP_ENDPOINT is set
+// and reports whether it did. The exporter reads the rest of its settings
+// (headers, protocol, timeouts) from the standard OTEL_* variables. When
+// tracing is off nothing is installed and the returned shutdown is a no-op.
+func setupTracing(ctx context.Context, endpoint string) (enabled bool, shutdown func(context.Context) error, err error) {
+	if endpoint == "" {
+		return false, func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return false, nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(Build.Version),
+	))
+	if err != nil {
+		return false, nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return true, provider.Shutdown, nil
+}
+
+// traceHandler wraps h so every request gets a server span, continuing any
+// trace from an incoming traceparent header.
+func traceHandler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
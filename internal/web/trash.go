@@ -0,0 +1,228 @@
+package web
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"exam/internal/store"
+)
+
+const (
+	// undoWindow is how long the undo offered after a delete works.
+	undoWindow = 5 * time.Minute
+	// trashPageSize is how many deleted users the recycle bin lists.
+	trashPageSize = 100
+)
+
+type trashPage struct {
+	Users         []store.TrashedUser
+	RetentionDays int
+	// Conflict is the user whose restore was refused for its name, with
+	// Error asking for another.
+	Conflict int
+	Name     string
+	Error    string
+}
+
+type trashResponse struct {
+	Users []store.TrashedUser `json:"users"`
+}
+
+type restoreUserRequest struct {
+	Name string `json:"name"`
+}
+
+// actor names who made a request, for the recycle bin: the API token's
+// name when it carries one, else the client's address.
+func (app *App) actor(r *http.Request) string {
+	if t, ok := r.Context().Value(apiTokenKey).(store.APIToken); ok {
+		return "token " + t.Name
+	}
+	return "ip " + app.clientIP(r)
+}
+
+// undoToken lets whoever deleted id at deletedAt restore it for
+// undoWindow. It is signed with the form key and only matches that one
+// deletion, so once the user is restored it can't be used again.
+func (app *App) undoToken(id int, deletedAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d.%d", id, deletedAt.UnixMicro(), app.clock.Now().Add(undoWindow).UnixMilli())
+	return payload + "." + app.forms.sign("undo."+payload)
+}
+
+// parseUndo returns the deletion token undoes, if it is genuine and has
+// not run out.
+func (app *App) parseUndo(token string) (id int, deletedAt time.Time, ok bool) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(app.forms.sign("undo."+token[:i]))) {
+		return 0, time.Time{}, false
+	}
+	parts := strings.Split(token[:i], ".")
+	if len(parts) != 3 {
+		return 0, time.Time{}, false
+	}
+	id, err1 := strconv.Atoi(parts[0])
+	at, err2 := strconv.ParseInt(parts[1], 10, 64)
+	expires, err3 := strconv.ParseInt(parts[2], 10, 64)
+	if err := errors.Join(err1, err2, err3); err != nil || app.clock.Now().After(time.UnixMilli(expires)) {
+		return 0, time.Time{}, false
+	}
+	return id, time.UnixMicro(at), true
+}
+
+// deleteUser moves id to the recycle bin when the store has one, returning
+// the token that undoes it, and deletes it outright otherwise.
+func (app *App) deleteUser(r *http.Request, id int) (undo string, err error) {
+	trash, ok := app.users.(store.Trash)
+	if !ok {
+		return "", app.users.Delete(r.Context(), id)
+	}
+	at, err := trash.TrashUser(r.Context(), id, app.actor(r))
+	if err != nil {
+		return "", err
+	}
+	return app.undoToken(id, at), nil
+}
+
+// handleUndoDelete restores the user an undo token names.
+func (app *App) handleUndoDelete(w http.ResponseWriter, r *http.Request) {
+	if !app.parseForm(w, r) {
+		return
+	}
+	trash, ok := app.users.(store.Trash)
+	id, deletedAt, valid := app.parseUndo(r.PostFormValue("token"))
+	if !ok || !valid {
+		app.renderError(w, r, http.StatusGone, "This undo link has expired. The user may still be in the recycle bin.")
+		return
+	}
+	user, err := trash.RestoreUser(r.Context(), id, deletedAt, "")
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		app.renderError(w, r, http.StatusGone, "This delete has already been undone.")
+		return
+	case errors.Is(err, store.ErrConflict):
+		app.renderTrash(w, r, http.StatusConflict, trashPage{Conflict: id, Error: "Another user now has this name. Choose a new one to restore it under."})
+		return
+	case err != nil:
+		app.respondError(w, r, fmt.Errorf("undo delete of user %d: %w", id, err))
+		return
+	}
+	app.publish(r, UserCreated, user)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleTrash shows the recycle bin.
+func (app *App) handleTrash(w http.ResponseWriter, r *http.Request) {
+	app.renderTrash(w, r, http.StatusOK, trashPage{})
+}
+
+func (app *App) renderTrash(w http.ResponseWriter, r *http.Request, status int, page trashPage) {
+	trash, ok := app.users.(store.Trash)
+	if !ok {
+		app.renderError(w, r, http.StatusNotImplemented, "The recycle bin needs the Postgres store; deletes here are permanent.")
+		return
+	}
+	users, err := trash.Trashed(r.Context(), trashPageSize)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("list trashed users: %w", err))
+		return
+	}
+	page.Users = users
+	page.RetentionDays = int(app.cfg.Retention.Hours() / 24)
+	if page.Conflict != 0 && page.Name == "" {
+		for _, u := range users {
+			if u.ID == page.Conflict {
+				page.Name = u.Name
+			}
+		}
+	}
+	app.renderStatus(w, r, status, "trash", page)
+}
+
+// handleRestoreTrashed restores a user from the recycle bin page, under the
+// posted name if there is one.
+func (app *App) handleRestoreTrashed(w http.ResponseWriter, r *http.Request) {
+	trash, ok := app.users.(store.Trash)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if !ok || err != nil || id <= 0 {
+		app.notFound(w, r)
+		return
+	}
+	if !app.parseForm(w, r) {
+		return
+	}
+	name := ""
+	if raw := r.PostFormValue("name"); raw != "" {
+		if name, err = validateName(raw); err != nil {
+			app.renderTrash(w, r, http.StatusUnprocessableEntity, trashPage{Conflict: id, Name: strings.TrimSpace(raw), Error: err.Error()})
+			return
+		}
+	}
+	user, err := trash.RestoreUser(r.Context(), id, time.Time{}, name)
+	if errors.Is(err, store.ErrConflict) {
+		app.renderTrash(w, r, http.StatusConflict, trashPage{Conflict: id, Name: name, Error: "Another user now has this name. Choose a new one to restore it under."})
+		return
+	}
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("restore user %d: %w", id, err))
+		return
+	}
+	app.publish(r, UserCreated, user)
+	http.Redirect(w, r, "/users/"+strconv.Itoa(user.ID), http.StatusSeeOther)
+}
+
+// handleGetTrash lists the recycle bin as JSON.
+func (app *App) handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	trash, ok := app.users.(store.Trash)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotImplemented, "unsupported", "The recycle bin needs the Postgres store.")
+		return
+	}
+	users, err := trash.Trashed(r.Context(), trashPageSize)
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("list trashed users: %w", err))
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, trashResponse{Users: users})
+}
+
+// handleRestoreUser restores a user from the recycle bin, renamed if the
+// body is {"name": "..."}. A 409 name_conflict asks for such a name.
+func (app *App) handleRestoreUser(w http.ResponseWriter, r *http.Request) {
+	trash, ok := app.users.(store.Trash)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotImplemented, "unsupported", "The recycle bin needs the Postgres store.")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		app.notFound(w, r)
+		return
+	}
+	var req restoreUserRequest
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req, `Request body must be empty or {"name": "..."}.`) {
+		return
+	}
+	name := ""
+	if req.Name != "" {
+		if name, err = validateName(req.Name); err != nil {
+			writeJSONError(w, r, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+			return
+		}
+	}
+	user, err := trash.RestoreUser(r.Context(), id, time.Time{}, name)
+	if errors.Is(err, store.ErrConflict) {
+		writeJSONError(w, r, http.StatusConflict, "name_conflict", `A live user has this name. Retry with {"name": "..."} to restore it under another.`)
+		return
+	}
+	if err != nil {
+		app.respondError(w, r, fmt.Errorf("restore user %d: %w", id, err))
+		return
+	}
+	app.publish(r, UserCreated, user)
+	app.writeJSON(w, r, http.StatusOK, user)
+}
@@ -0,0 +1,179 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// Set by the old process for the new one during a SIGUSR2 restart.
+	upgradeListenersEnv = "UPGRADE_LISTENERS" // "name=fd,name=fd"
+	upgradeReadyFdEnv   = "UPGRADE_READY_FD"
+
+	upgradeTimeout     = time.Minute
+	upgradeReadyPoll   = 500 * time.Millisecond
+	upgradeReadyByte   = 'R'
+	firstExtraFileDesc = 3
+)
+
+// loadInheritedListeners returns the listeners handed over by the previous
+// process, keyed by listener name. It is empty unless this process was
+// started by a SIGUSR2 restart.
+func loadInheritedListeners() (map[string]net.Listener, error) {
+	raw := os.Getenv(upgradeListenersEnv)
+	os.Unsetenv(upgradeListenersEnv)
+	inherited := make(map[string]net.Listener)
+	if raw == "" {
+		return inherited, nil
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		name, fdStr, ok := strings.Cut(entry, "=")
+		fd, err := strconv.Atoi(fdStr)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%s: malformed entry %q", upgradeListenersEnv, entry)
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherit %s listener: %w", name, err)
+		}
+		if ul, ok := ln.(*net.UnixListener); ok {
+			// This process owns the socket file now.
+			ul.SetUnlinkOnClose(true)
+		}
+		inherited[name] = ln
+	}
+	return inherited, nil
+}
+
+// upgradeOnSIGUSR2 re-executes the binary on SIGUSR2, handing it every
+// listening socket. Once the new process passes its readiness check, done is
+// called so this process drains and exits; connections keep being accepted
+// throughout because both processes share the sockets. If the new process
+// fails to become ready it is killed and this one keeps serving.
+func (app *App) upgradeOnSIGUSR2(listeners []listener, done context.CancelFunc) {
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			app.logger.Info("restart requested, starting new process")
+			pid, err := startUpgrade(listeners)
+			if err != nil {
+				app.logger.Error("restart failed, keeping current process", "error", err)
+				continue
+			}
+			app.logger.Info("new process ready, draining", "pid", pid)
+			signal.Stop(usr2)
+			for _, l := range listeners {
+				if ul, ok := l.ln.(*net.UnixListener); ok {
+					// The new process serves on the same socket file.
+					ul.SetUnlinkOnClose(false)
+				}
+			}
+			done()
+			return
+		}
+	}()
+}
+
+// startUpgrade starts the new process and waits for it to report ready.
+func startUpgrade(listeners []listener) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	var entries []string
+	for _, l := range listeners {
+		filer, ok := l.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, fmt.Errorf("%s listener cannot be handed over", l.name)
+		}
+		f, err := filer.File()
+		if err != nil {
+			return 0, fmt.Errorf("%s listener: %w", l.name, err)
+		}
+		entries = append(entries, fmt.Sprintf("%s=%d", l.name, firstExtraFileDesc+len(files)))
+		files = append(files, f)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+	readyFd := firstExtraFileDesc + len(files)
+	files = append(files, readyW)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		upgradeListenersEnv+"="+strings.Join(entries, ","),
+		upgradeReadyFdEnv+"="+strconv.Itoa(readyFd),
+	)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	// Reap the child if it exits while this process is still around.
+	go cmd.Wait()
+
+	// Close our copy of the write end so a crashing child shows up as EOF.
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	ready.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	buf := make([]byte, 1)
+	if _, err := ready.Read(buf); err != nil || buf[0] != upgradeReadyByte {
+		cmd.Process.Kill()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return 0, fmt.Errorf("new process not ready after %s", upgradeTimeout)
+		}
+		return 0, fmt.Errorf("new process exited before becoming ready: %v", err)
+	}
+	return cmd.Process.Pid, nil
+}
+
+// notifyUpgradeReady tells the parent of a SIGUSR2 restart that this process
+// can take over, once the same readiness check /_internal/readyz uses passes.
+// It does nothing when the process wasn't started by a restart.
+func (app *App) notifyUpgradeReady(ctx context.Context) {
+	fd, err := strconv.Atoi(os.Getenv(upgradeReadyFdEnv))
+	os.Unsetenv(upgradeReadyFdEnv)
+	if err != nil {
+		return
+	}
+	pipe := os.NewFile(uintptr(fd), "upgrade-ready")
+	go func() {
+		defer pipe.Close()
+		ticker := time.NewTicker(upgradeReadyPoll)
+		defer ticker.Stop()
+		for {
+			if app.checkReady(ctx, true).Status == "ok" {
+				pipe.Write([]byte{upgradeReadyByte})
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}